@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// adminOnly guards admin endpoints with the token configured in ADMIN_API_TOKEN,
+// sent by the caller in the X-Admin-Token header. Admin endpoints are disabled when no token is configured.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	token := getEnv("ADMIN_API_TOKEN", "")
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) == 1
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// asyncPlacements tracks the background placements so the shutdown can wait for them, an order left
+// pending by an interrupted placement would never be placed nor failed
+var asyncPlacements sync.WaitGroup
+
+// response of an order accepted for asynchronous placement, the client polls the status url
+type AcceptedOrderResponse struct {
+	ID        string      `json:"id"`
+	Status    OrderStatus `json:"status"`
+	StatusURL string      `json:"status_url"`
+}
+
+// wantsAsyncPlacement reports whether the client asked for the order to be placed in the background
+// via the "Prefer: respond-async" header
+func wantsAsyncPlacement(r *http.Request) bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.TrimSpace(pref) == "respond-async" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptOrder stores the order as pending, responds with 202 and places the order in the background
+func acceptOrder(w http.ResponseWriter, r *http.Request, store OrderRepository, o Order, oReq CreateOrderRequest, couponPercent int64) {
+	o.Status = OrderPending
+	appendStatusHistory(&o, "", o.StatusChangedAt, requestActor(r))
+	o, err := store.SaveOrder(o, nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.Info("accepted order for asynchronous placement", "order_id", o.ID)
+
+	asyncPlacements.Add(1)
+	go func() {
+		defer asyncPlacements.Done()
+		completeOrderPlacement(store, o, oReq, couponPercent)
+	}()
+
+	statusURL := "/orders/" + o.ID
+	w.Header().Set("Location", statusURL)
+	writeJSON(w, http.StatusAccepted, AcceptedOrderResponse{
+		ID:        o.ID,
+		Status:    o.Status,
+		StatusURL: statusURL,
+	})
+}
+
+// completeOrderPlacement places a pending order, an order that can't be placed is marked as failed
+// with the reason of the failure
+func completeOrderPlacement(store OrderRepository, o Order, oReq CreateOrderRequest, couponPercent int64) {
+	// the request is long gone, the placement runs on its own context
+	ctx := context.Background()
+
+	now := clock.Now()
+	o.StatusChangedAt = now
+	o.UpdatedAt = now
+	o.Version++
+
+	placed, _, _, pErr := placeOrder(ctx, store, o, oReq, couponPercent, ActorSystem)
+	if pErr == nil {
+		logger.Info("completed the asynchronous placement", "order_id", placed.ID)
+		return
+	}
+
+	logger.Warn("asynchronous placement failed", "order_id", o.ID, "err", pErr.message)
+	if err := store.MarkFailed(o.ID, pErr.message, clock.Now()); err != nil {
+		logger.Error("order could not be marked as failed", "order_id", o.ID, "err", err)
+		return
+	}
+	recordFinalStatus(OrderFailed)
+}
+
+// waitForAsyncPlacements waits for the background placements to finish, it reports false when ctx is
+// done first
+func waitForAsyncPlacements(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		asyncPlacements.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
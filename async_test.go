@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsyncPlacement(t *testing.T) {
+	tests := []struct {
+		name       string
+		productId  string
+		failUpdate bool
+		want       OrderStatus
+		wantReason string
+	}{
+		{"placed in the background", "p1", false, OrderPlaced, ""},
+		{"unknown product", "p404", false, OrderFailed, "product with id: p404 does not exist"},
+		{"inventory update failure", "p1", true, OrderFailed, "inventory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			if tt.failUpdate {
+				fake.FailUpdate(tt.productId, errors.New("product service unavailable"))
+			}
+
+			req := newTenantRequest(http.MethodPost, "/orders",
+				`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"`+tt.productId+`","quantity":3}]}`, nil)
+			req.Header.Set("Prefer", "respond-async")
+			rec := httptest.NewRecorder()
+			PlaceOrderHandler(rec, req)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("placing the order answered %v, want 202: %s", rec.Code, rec.Body)
+			}
+			var accepted AcceptedOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+				t.Fatalf("decoding the accepted order failed: %v", err)
+			}
+			if accepted.Status != OrderPending || accepted.StatusURL != "/orders/"+accepted.ID || rec.Header().Get("Location") != accepted.StatusURL {
+				t.Fatalf("accepted order = %+v with location %q, want a pending order and its status url", accepted, rec.Header().Get("Location"))
+			}
+
+			// the order is read through the status url once the background placement is done, the placement
+			// shows the order as placed before a failed inventory update fails it
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if !waitForAsyncPlacements(ctx) {
+				t.Fatal("the order is still pending")
+			}
+			rec = httptest.NewRecorder()
+			GetOrderDetailsHandler(rec, newTenantRequest(http.MethodGet, accepted.StatusURL, "", map[string]string{"order_id": accepted.ID}))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("polling the order answered %v: %s", rec.Code, rec.Body)
+			}
+			var o CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+				t.Fatalf("decoding the polled order failed: %v", err)
+			}
+
+			if o.Status != tt.want {
+				t.Fatalf("order is %v, want %v", o.Status, tt.want)
+			}
+			if !strings.Contains(o.FailureReason, tt.wantReason) || (tt.wantReason == "") != (o.FailureReason == "") {
+				t.Errorf("failure reason = %q, want %q", o.FailureReason, tt.wantReason)
+			}
+
+			// only a placed order keeps the stock it took
+			wantQuantity := int64(100)
+			if tt.want == OrderPlaced {
+				wantQuantity = 97
+			}
+			p1, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if p1.Quantity != wantQuantity {
+				t.Errorf("product quantity = %v, want %v", p1.Quantity, wantQuantity)
+			}
+		})
+	}
+}
+
+// heldProductClient holds the product lookups until it is released
+type heldProductClient struct {
+	*fakeProductClient
+	release chan struct{}
+}
+
+func (c *heldProductClient) ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error) {
+	<-c.release
+	return c.fakeProductClient.ListProductDetails(ctx, productIds)
+}
+
+func TestShutdownWaitsForAsyncPlacements(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	held := &heldProductClient{fakeProductClient: fake, release: make(chan struct{})}
+	productClient = held
+
+	req := newTenantRequest(http.MethodPost, "/orders",
+		`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":3}]}`, nil)
+	req.Header.Set("Prefer", "respond-async")
+	rec := httptest.NewRecorder()
+	PlaceOrderHandler(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("placing the order answered %v, want 202: %s", rec.Code, rec.Body)
+	}
+	var accepted AcceptedOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+		t.Fatalf("decoding the accepted order failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan bool)
+	go func() { done <- waitForAsyncPlacements(ctx) }()
+	select {
+	case <-done:
+		t.Fatal("the wait returned while the placement was still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(held.release)
+	if !<-done {
+		t.Fatal("the placement didn't finish")
+	}
+	// once the wait returns the order is no longer pending
+	o, _, ok, err := tenantStore("t1").GetOrder(accepted.ID)
+	if err != nil || !ok {
+		t.Fatalf("reading the order failed: %v, found: %v", err, ok)
+	}
+	if o.Status != OrderPlaced {
+		t.Errorf("order is %v, want %v", o.Status, OrderPlaced)
+	}
+}
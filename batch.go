@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchGetIds caps the number of orders a single batch get can fetch
+const maxBatchGetIds = 100
+
+type BatchGetOrdersRequest struct {
+	Ids []string `json:"ids"`
+}
+
+func (b *BatchGetOrdersRequest) Validate() (err error) {
+	if len(b.Ids) == 0 {
+		return errors.New("order ids not provided")
+	}
+	if len(b.Ids) > maxBatchGetIds {
+		return fmt.Errorf("at most %v order ids can be fetched at once", maxBatchGetIds)
+	}
+	for _, id := range b.Ids {
+		if id == "" {
+			return errors.New("order ids can't be empty")
+		}
+	}
+	return nil
+}
+
+type BatchGetOrdersResponse struct {
+	Orders   []CreateOrderResponse `json:"orders"`
+	NotFound []string              `json:"not_found"`
+}
+
+// BatchGetOrdersHandler returns the requested orders in one response, the items of all the orders are
+// looked up with a single ListProductDetails call
+func BatchGetOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	var batchReq BatchGetOrdersRequest
+	err := decodeJSONBody(w, r, &batchReq)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if err = batchReq.Validate(); err != nil {
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fields, err := parseFieldSelection(r)
+	if err != nil {
+		logger.InfoContext(r.Context(), "invalid fields value", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// copy the orders out of the store, in the requested order and without duplicates
+	var orders []Order
+	orderItems := make(map[string][]OrderItem)
+	notFound := []string{}
+	seen := make(map[string]bool)
+	for _, id := range batchReq.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		o, items, ok, err := store.GetOrder(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if !ok || isDeleted(o) {
+			notFound = append(notFound, id)
+			continue
+		}
+		orders = append(orders, o)
+		orderItems[id] = items
+	}
+
+	// one lookup for the products of every order, none if the items weren't selected or are all
+	// rendered from their snapshots
+	live := useLiveItemDetails(r)
+	var productIds []string
+	products := make(map[string]*ProductDetails)
+	if !fields.wants("items") {
+		orderItems = nil
+	}
+	for _, o := range orders {
+		for _, item := range orderItems[o.ID] {
+			if !live && hasItemSnapshot(item) {
+				continue
+			}
+			if _, ok := products[item.ProductId]; !ok {
+				products[item.ProductId] = nil
+				productIds = append(productIds, item.ProductId)
+			}
+		}
+	}
+	if len(productIds) > 0 {
+		details, err := productClient.ListProductDetails(r.Context(), productIds)
+		if err != nil {
+			writeProductError(w, err)
+			return
+		}
+		for _, p := range details {
+			products[p.ID] = p
+		}
+	}
+
+	resp := BatchGetOrdersResponse{Orders: []CreateOrderResponse{}, NotFound: notFound}
+	for _, o := range orders {
+		orderDetails := newOrderResponse(o)
+		orderDetails.fields = fields
+		for _, item := range orderItems[o.ID] {
+			if !live && hasItemSnapshot(item) {
+				orderDetails.Items = append(orderDetails.Items, newOrderItemResponse(item, amountCurrency(o), nil, false))
+				continue
+			}
+			productDetails := products[item.ProductId]
+			if productDetails == nil {
+				logger.WarnContext(r.Context(), "product of the order does not exist", "order_id", o.ID, "product_id", item.ProductId)
+				writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("product with id: %v, does not exist", item.ProductId))
+				return
+			}
+			orderDetails.Items = append(orderDetails.Items, newOrderItemResponse(item, amountCurrency(o), productDetails, live))
+		}
+		resp.Orders = append(resp.Orders, orderDetails)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBatchGetOrders(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	productClient = &countingProductClient{fake}
+	saveTestOrders(t,
+		Order{ID: "o1", Status: OrderPlaced},
+		Order{ID: "o2", Status: OrderDispatched},
+		Order{ID: "o3", Status: OrderPlaced, DeletedAt: clock.Now()},
+	)
+
+	req := newTenantRequest(http.MethodPost, "/orders/batch-get", `{"ids":["o2","o404","o1","o2","o3"]}`, nil)
+	req = req.WithContext(context.WithValue(req.Context(), lookupCounterKey{}, new(int64)))
+	rec := httptest.NewRecorder()
+	BatchGetOrdersHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fetching the orders answered %v: %s", rec.Code, rec.Body)
+	}
+	var resp BatchGetOrdersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding the orders failed: %v", err)
+	}
+
+	var ids []string
+	for _, o := range resp.Orders {
+		ids = append(ids, o.ID)
+		if len(o.Items) != 1 || o.Items[0].Name != "Watch" {
+			t.Errorf("order %v has the items %+v, want the watch", o.ID, o.Items)
+		}
+	}
+	// in the requested order without duplicates, the deleted order isn't found
+	if !reflect.DeepEqual(ids, []string{"o2", "o1"}) || !reflect.DeepEqual(resp.NotFound, []string{"o404", "o3"}) {
+		t.Errorf("orders = %v not found %v, want [o2 o1] not found [o404 o3]", ids, resp.NotFound)
+	}
+	if lookups := productLookups(req.Context()); lookups != 1 {
+		t.Errorf("the items took %v product lookups, want a single batch", lookups)
+	}
+}
+
+func TestBatchGetOrdersValidation(t *testing.T) {
+	useMemoryStores(t)
+	for _, body := range []string{`{"ids":[]}`, `{"ids":[""]}`} {
+		rec := httptest.NewRecorder()
+		BatchGetOrdersHandler(rec, newTenantRequest(http.MethodPost, "/orders/batch-get", body, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("fetching %v answered %v, want 400", body, rec.Code)
+		}
+	}
+}
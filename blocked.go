@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	blockedProductsMu sync.RWMutex
+	// product ids that can't be ordered, e.g. under a regulatory hold. Set at startup by BLOCKED_PRODUCTS,
+	// a comma separated list, and replaced at runtime via PUT /admin/blocked-products.
+	blockedProducts = map[string]bool{}
+)
+
+func loadBlockedProducts() {
+	var productIds []string
+	for _, productId := range strings.Split(getEnv("BLOCKED_PRODUCTS", ""), ",") {
+		if productId = strings.TrimSpace(productId); productId != "" {
+			productIds = append(productIds, productId)
+		}
+	}
+	setBlockedProducts(productIds)
+	logger.Info("blocked products loaded", "product_ids", productIds)
+}
+
+func setBlockedProducts(productIds []string) {
+	blocked := make(map[string]bool, len(productIds))
+	for _, productId := range productIds {
+		blocked[productId] = true
+	}
+	blockedProductsMu.Lock()
+	defer blockedProductsMu.Unlock()
+	blockedProducts = blocked
+}
+
+// listBlockedProducts returns the blocked product ids, sorted
+func listBlockedProducts() []string {
+	blockedProductsMu.RLock()
+	defer blockedProductsMu.RUnlock()
+	productIds := make([]string, 0, len(blockedProducts))
+	for productId := range blockedProducts {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+	return productIds
+}
+
+// blockedItems returns the ids of the blocked products among the items, in the order of the items
+func blockedItems(items []CreateOrderItemsRequest) []string {
+	blockedProductsMu.RLock()
+	defer blockedProductsMu.RUnlock()
+	var productIds []string
+	for _, item := range items {
+		if blockedProducts[item.ProductId] {
+			productIds = append(productIds, item.ProductId)
+		}
+	}
+	return productIds
+}
+
+type BlockedProductsRequest struct {
+	ProductIds []string `json:"product_ids"`
+}
+
+type BlockedProductsResponse struct {
+	ProductIds []string `json:"product_ids"`
+}
+
+func GetBlockedProductsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, BlockedProductsResponse{ProductIds: listBlockedProducts()})
+}
+
+// UpdateBlockedProductsHandler replaces the list of blocked products
+func UpdateBlockedProductsHandler(w http.ResponseWriter, r *http.Request) {
+	var bReq BlockedProductsRequest
+	if err := decodeJSONBody(w, r, &bReq); err != nil || bReq.ProductIds == nil {
+		logger.InfoContext(r.Context(), "error unmarshaling the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid Request Body")
+		return
+	}
+	for _, productId := range bReq.ProductIds {
+		if strings.TrimSpace(productId) == "" {
+			logger.InfoContext(r.Context(), "empty blocked product id")
+			writeJSONError(w, http.StatusBadRequest, "product ids can't be empty")
+			return
+		}
+	}
+
+	setBlockedProducts(bReq.ProductIds)
+	logger.InfoContext(r.Context(), "blocked products set", "product_ids", bReq.ProductIds)
+	writeJSON(w, http.StatusOK, BlockedProductsResponse{ProductIds: listBlockedProducts()})
+}
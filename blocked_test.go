@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBlockedProducts(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	t.Setenv("BLOCKED_PRODUCTS", "p2, p3")
+	loadBlockedProducts()
+	t.Cleanup(func() { setBlockedProducts(nil) })
+	body := `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1}]}`
+
+	rec := placeTestOrder(t, body)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "p2") || strings.Contains(rec.Body.String(), "p3") {
+		t.Errorf("placing an order of a blocked product answered %v: %s, want a 422 naming p2", rec.Code, rec.Body)
+	}
+	p1, _ := fake.GetProductDetails(context.Background(), "p1")
+	if p1.Quantity != 100 {
+		t.Errorf("the rejected order took stock, product quantity = %v", p1.Quantity)
+	}
+
+	// the list is replaced at runtime
+	rec = httptest.NewRecorder()
+	UpdateBlockedProductsHandler(rec, newTenantRequest(http.MethodPut, "/admin/blocked-products", `{"product_ids":["p1"]}`, nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"product_ids":["p1"]}` {
+		t.Fatalf("updating the blocked products answered %v: %s", rec.Code, rec.Body)
+	}
+	if rec := placeTestOrder(t, body); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "p1") {
+		t.Errorf("placing an order of the newly blocked product answered %v: %s, want a 422 naming p1", rec.Code, rec.Body)
+	}
+	if rec := placeTestOrder(t, strings.Replace(body, `"p1"`, `"p3"`, 1)); rec.Code != http.StatusOK {
+		t.Errorf("placing an order of the unblocked products answered %v: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	UpdateBlockedProductsHandler(rec, newTenantRequest(http.MethodPut, "/admin/blocked-products", `{"product_ids":[" "]}`, nil))
+	if rec.Code != http.StatusBadRequest || strings.Join(listBlockedProducts(), ",") != "p1" {
+		t.Errorf("an empty product id answered %v and left %v blocked, want a 400 keeping p1", rec.Code, listBlockedProducts())
+	}
+}
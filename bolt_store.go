@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bolt store keeps every tenant in its own bucket under the tenants bucket:
+//
+//	tenants/<tenant id>/orders       order id -> the order with its items, as JSON
+//	tenants/<tenant id>/carts        cart id -> order id
+//	tenants/<tenant id>/numbers      order number -> order id
+//	tenants/<tenant id>/idempotency  idempotency key -> the claimed record, as JSON
+//	tenants/<tenant id>              last_order_number -> the last order number of the tenant
+var (
+	boltTenantsBucket     = []byte("tenants")
+	boltOrdersBucket      = []byte("orders")
+	boltCartsBucket       = []byte("carts")
+	boltNumbersBucket     = []byte("numbers")
+	boltIdempotencyBucket = []byte("idempotency")
+	boltLastOrderNumber   = []byte("last_order_number")
+)
+
+// boltOrder is the stored value of an order
+type boltOrder struct {
+	Order Order       `json:"order"`
+	Items []OrderItem `json:"items"`
+}
+
+// openBoltDB opens the database file, creating it if it doesn't exist. Bolt locks the file, so only one
+// instance of the service can use it.
+func openBoltDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening the bolt database: %v, err: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltTenantsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating the bolt buckets, err: %w", err)
+	}
+	return db, nil
+}
+
+// boltTenants returns the tenants with orders in the database
+func boltTenants(db *bolt.DB) ([]string, error) {
+	var tenantIds []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTenantsBucket).ForEachBucket(func(k []byte) error {
+			tenantIds = append(tenantIds, string(k))
+			return nil
+		})
+	})
+	return tenantIds, err
+}
+
+// BoltStore keeps the orders of a single tenant in an embedded bolt database file, for single node
+// deployments without a database server. Bolt serializes the writes, every mutation is one transaction.
+type BoltStore struct {
+	db *bolt.DB
+	// the transaction of WithTx the calls run in, nil outside of one
+	tx       *bolt.Tx
+	tenantId string
+}
+
+func NewBoltStore(db *bolt.DB, tenantId string) *BoltStore {
+	return &BoltStore{db: db, tenantId: tenantId}
+}
+
+// update runs fn in a read-write transaction, the one of WithTx if the call is within it. Bolt has no
+// savepoints, a call within WithTx that fails leaves its writes to the transaction to be dropped by fn
+// returning the error.
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// view runs fn in a read-only transaction, the one of WithTx if the call is within it
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+// WithTx runs fn in a single bolt transaction, a WithTx within fn joins it
+func (s *BoltStore) WithTx(fn func(tx OrderRepository) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return fn(&BoltStore{db: s.db, tx: tx, tenantId: s.tenantId})
+	})
+}
+
+// bucket returns the sub bucket of the tenant, nil if the tenant has no writes yet
+func (s *BoltStore) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	tenant := tx.Bucket(boltTenantsBucket).Bucket([]byte(s.tenantId))
+	if tenant == nil {
+		return nil
+	}
+	return tenant.Bucket(name)
+}
+
+// tenantBucket returns the bucket of the tenant with its sub buckets, creating them on first write
+func (s *BoltStore) tenantBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	tenant, err := tx.Bucket(boltTenantsBucket).CreateBucketIfNotExists([]byte(s.tenantId))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{boltOrdersBucket, boltCartsBucket, boltNumbersBucket, boltIdempotencyBucket} {
+		if _, err := tenant.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return tenant, nil
+}
+
+func boltNumberKey(n int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(n))
+	return key
+}
+
+func getBoltOrder(orders *bolt.Bucket, orderId string) (boltOrder, bool, error) {
+	var stored boltOrder
+	if orders == nil {
+		return stored, false, nil
+	}
+	data := orders.Get([]byte(orderId))
+	if data == nil {
+		return stored, false, nil
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return stored, false, fmt.Errorf("invalid stored order: %v, err: %w", orderId, err)
+	}
+	return stored, true, nil
+}
+
+func putBoltOrder(orders *bolt.Bucket, stored boltOrder) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return orders.Put([]byte(stored.Order.ID), data)
+}
+
+// SaveOrder numbers the order within the transaction so the numbers have no gaps or duplicates
+func (s *BoltStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	err := s.update(func(tx *bolt.Tx) error {
+		tenant, err := s.tenantBucket(tx)
+		if err != nil {
+			return err
+		}
+		if o.Status != OrderPending && o.OrderNumber == 0 {
+			var last int64
+			if data := tenant.Get(boltLastOrderNumber); data != nil {
+				last = int64(binary.BigEndian.Uint64(data))
+			}
+			o.OrderNumber = last + 1
+			if err := tenant.Put(boltLastOrderNumber, boltNumberKey(o.OrderNumber)); err != nil {
+				return err
+			}
+		}
+		if o.OrderNumber != 0 {
+			if err := tenant.Bucket(boltNumbersBucket).Put(boltNumberKey(o.OrderNumber), []byte(o.ID)); err != nil {
+				return err
+			}
+		}
+		if o.CartId != "" {
+			if err := tenant.Bucket(boltCartsBucket).Put([]byte(o.CartId), []byte(o.ID)); err != nil {
+				return err
+			}
+		}
+		return putBoltOrder(tenant.Bucket(boltOrdersBucket), boltOrder{Order: o, Items: items})
+	})
+	return o, err
+}
+
+func (s *BoltStore) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	var stored boltOrder
+	var ok bool
+	err := s.view(func(tx *bolt.Tx) (err error) {
+		stored, ok, err = getBoltOrder(s.bucket(tx, boltOrdersBucket), orderId)
+		return err
+	})
+	if err != nil || !ok {
+		return Order{}, nil, false, err
+	}
+	return stored.Order, stored.Items, true, nil
+}
+
+func (s *BoltStore) ListOrders() ([]Order, error) {
+	orders := []Order{}
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := s.bucket(tx, boltOrdersBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var stored boltOrder
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("invalid stored order: %v, err: %w", k, err)
+			}
+			orders = append(orders, stored.Order)
+			return nil
+		})
+	})
+	return orders, err
+}
+
+// UpdateOrder leaves the items of the order untouched, they don't change once the order is placed
+func (s *BoltStore) UpdateOrder(o Order, version int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		orders := s.bucket(tx, boltOrdersBucket)
+		stored, ok, err := getBoltOrder(orders, o.ID)
+		if err != nil {
+			return err
+		}
+		if !ok || stored.Order.Version != version {
+			return errVersionConflict
+		}
+		stored.Order = o
+		return putBoltOrder(orders, stored)
+	})
+}
+
+func (s *BoltStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		orders := s.bucket(tx, boltOrdersBucket)
+		stored, ok, err := getBoltOrder(orders, o.ID)
+		if err != nil {
+			return err
+		}
+		if !ok || stored.Order.Version != version {
+			return errVersionConflict
+		}
+		stored.Order = o
+		stored.Items = items
+		return putBoltOrder(orders, stored)
+	})
+}
+
+func (s *BoltStore) FindByCartId(cartId string) (string, bool, error) {
+	return s.findId(boltCartsBucket, []byte(cartId))
+}
+
+func (s *BoltStore) FindByNumber(orderNumber int64) (string, bool, error) {
+	return s.findId(boltNumbersBucket, boltNumberKey(orderNumber))
+}
+
+// findId looks the key up in the index bucket
+func (s *BoltStore) findId(index, key []byte) (string, bool, error) {
+	var orderId string
+	err := s.view(func(tx *bolt.Tx) error {
+		if bucket := s.bucket(tx, index); bucket != nil {
+			orderId = string(bucket.Get(key))
+		}
+		return nil
+	})
+	return orderId, orderId != "", err
+}
+
+func (s *BoltStore) MarkFailed(orderId, reason string, now time.Time) error {
+	return s.update(func(tx *bolt.Tx) error {
+		orders := s.bucket(tx, boltOrdersBucket)
+		stored, ok, err := getBoltOrder(orders, orderId)
+		if err != nil || !ok {
+			return err
+		}
+		o := stored.Order
+		o.Status = OrderFailed
+		o.FailureReason = reason
+		o.StatusChangedAt = now
+		o.UpdatedAt = now
+		o.History = append(o.History, failedStatusChange(now))
+		o.Version++
+		stored.Order = o
+		// the cart is free to be ordered again
+		carts := s.bucket(tx, boltCartsBucket)
+		if o.CartId != "" && string(carts.Get([]byte(o.CartId))) == orderId {
+			if err := carts.Delete([]byte(o.CartId)); err != nil {
+				return err
+			}
+		}
+		return putBoltOrder(orders, stored)
+	})
+}
+
+// DeleteOrder also drops the index entries and the idempotency key of the order
+func (s *BoltStore) DeleteOrder(orderId string, version int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		orders := s.bucket(tx, boltOrdersBucket)
+		stored, ok, err := getBoltOrder(orders, orderId)
+		if err != nil {
+			return err
+		}
+		if !ok || stored.Order.Version != version {
+			return errVersionConflict
+		}
+		o := stored.Order
+		if err := orders.Delete([]byte(orderId)); err != nil {
+			return err
+		}
+		carts := s.bucket(tx, boltCartsBucket)
+		if o.CartId != "" && string(carts.Get([]byte(o.CartId))) == orderId {
+			if err := carts.Delete([]byte(o.CartId)); err != nil {
+				return err
+			}
+		}
+		if o.OrderNumber != 0 {
+			if err := s.bucket(tx, boltNumbersBucket).Delete(boltNumberKey(o.OrderNumber)); err != nil {
+				return err
+			}
+		}
+
+		idempotency := s.bucket(tx, boltIdempotencyBucket)
+		var keys [][]byte
+		err = idempotency.ForEach(func(k, v []byte) error {
+			var record IdempotencyRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.OrderId == orderId {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := idempotency.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	claimed := record
+	ok := false
+	err := s.update(func(tx *bolt.Tx) error {
+		tenant, err := s.tenantBucket(tx)
+		if err != nil {
+			return err
+		}
+		idempotency := tenant.Bucket(boltIdempotencyBucket)
+		if data := idempotency.Get([]byte(key)); data != nil {
+			return json.Unmarshal(data, &claimed)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		ok = true
+		return idempotency.Put([]byte(key), data)
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return claimed, ok, nil
+}
+
+func (s *BoltStore) ReleaseIdempotencyKey(key string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if idempotency := s.bucket(tx, boltIdempotencyBucket); idempotency != nil {
+			return idempotency.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+// RefreshSLAFlags flags the orders in a single transaction, only the orders whose flag changed are written
+func (s *BoltStore) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	breaches := make(map[OrderStatus]int)
+	err := s.update(func(tx *bolt.Tx) error {
+		orders := s.bucket(tx, boltOrdersBucket)
+		if orders == nil {
+			return nil
+		}
+		var changed []boltOrder
+		err := orders.ForEach(func(k, v []byte) error {
+			var stored boltOrder
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("invalid stored order: %v, err: %w", k, err)
+			}
+			isBreached := slaBreached(stored.Order, now)
+			if stored.Order.SlaBreached != isBreached {
+				stored.Order.SlaBreached = isBreached
+				changed = append(changed, stored)
+			}
+			if isBreached {
+				breaches[stored.Order.Status]++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// bolt doesn't allow writes to a bucket while iterating it
+		for _, stored := range changed {
+			if err := putBoltOrder(orders, stored); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return breaches, nil
+}
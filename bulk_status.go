@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBulkStatusIds caps the number of orders a single bulk status update can change
+const maxBulkStatusIds = 100
+
+// BulkUpdateOrderStatusRequest moves every order of the ids to the status, with the reason of the
+// status if it needs one
+type BulkUpdateOrderStatusRequest struct {
+	Ids []string `json:"ids"`
+	UpdateOrderStatusRequest
+}
+
+func (b *BulkUpdateOrderStatusRequest) Validate() (err error) {
+	if len(b.Ids) == 0 {
+		return errors.New("order ids not provided")
+	}
+	if len(b.Ids) > maxBulkStatusIds {
+		return fmt.Errorf("at most %v orders can be updated at once", maxBulkStatusIds)
+	}
+	for _, id := range b.Ids {
+		if id == "" {
+			return errors.New("order ids can't be empty")
+		}
+	}
+	return b.UpdateOrderStatusRequest.Validate()
+}
+
+// outcome of the status update of one of the orders, Code is the http status the single status update
+// would have answered with
+type BulkStatusUpdateResult struct {
+	ID     string      `json:"id"`
+	Code   int         `json:"code"`
+	Status OrderStatus `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type BulkUpdateOrderStatusResponse struct {
+	Results []BulkStatusUpdateResult `json:"results"`
+	Updated int                      `json:"updated"`
+	Failed  int                      `json:"failed"`
+}
+
+// BulkUpdateOrderStatusHandler moves the orders to the status one by one, each order is checked like on
+// PUT /orders/{order_id}/status and one failing doesn't stop the others. It answers with the outcome of
+// every order, in the requested order and without duplicates.
+func BulkUpdateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	var bulkReq BulkUpdateOrderStatusRequest
+	err := decodeJSONBody(w, r, &bulkReq)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if err = bulkReq.Validate(); err != nil {
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := BulkUpdateOrderStatusResponse{Results: []BulkStatusUpdateResult{}}
+	seen := make(map[string]bool)
+	for _, id := range bulkReq.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		o, _, sErr := updateOrderStatus(r, store, id, bulkReq.UpdateOrderStatusRequest, false)
+		if sErr != nil {
+			resp.Results = append(resp.Results, BulkStatusUpdateResult{ID: id, Code: sErr.status, Error: sErr.message})
+			resp.Failed++
+			continue
+		}
+		resp.Results = append(resp.Results, BulkStatusUpdateResult{ID: id, Code: http.StatusOK, Status: o.Status})
+		resp.Updated++
+	}
+	logger.InfoContext(r.Context(), "bulk status update", "status", bulkReq.Status, "updated", resp.Updated, "failed", resp.Failed)
+
+	writeJSON(w, http.StatusOK, resp)
+}
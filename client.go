@@ -3,54 +3,145 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"sync"
+	"time"
 
 	"github.com/microServicesExamples/gRPC/product/productpb"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
-var conn productpb.ProductServiceClient
+// ProductClient is the order-service's view of the product service
+type ProductClient interface {
+	GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error)
+	ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error)
+	UpdateProductQuantity(ctx context.Context, productId string, quantity int64) error
+}
+
+// productClient is used by the handlers to reach the product service, wired in main
+var productClient ProductClient
 
-func createProductGRPCClientConnection() {
-	fmt.Println("Initiating the gRPC client connection")
+// grpcProductClient talks to the product service over gRPC, the connection can be swapped by the reconnect monitor
+type grpcProductClient struct {
+	mu   sync.RWMutex
+	cc   *grpc.ClientConn
+	conn productpb.ProductServiceClient
+}
+
+// productGRPCClient is set when the service talks to the real product service
+var productGRPCClient *grpcProductClient
+
+// dialProductService connects to the product service at PRODUCT_SERVICE_ADDR. The dial doesn't block,
+// the connection is established in the background and the calls wait for it.
+func dialProductService() (*grpc.ClientConn, error) {
+	// keepalive pings keep idle connections from being dropped by NATs and load balancers,
+	// the defaults match the product service's default keepalive enforcement policy
+	kaParams := keepalive.ClientParameters{
+		Time:                getEnvDuration("GRPC_KEEPALIVE_TIME", 5*time.Minute),
+		Timeout:             getEnvDuration("GRPC_KEEPALIVE_TIMEOUT", 20*time.Second),
+		PermitWithoutStream: getEnvBool("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", false),
+	}
+	backoffConfig := backoff.DefaultConfig
+	backoffConfig.BaseDelay = getEnvDuration("GRPC_BACKOFF_BASE_DELAY", backoffConfig.BaseDelay)
+	backoffConfig.MaxDelay = getEnvDuration("GRPC_BACKOFF_MAX_DELAY", backoffConfig.MaxDelay)
+	connectParams := grpc.ConnectParams{
+		Backoff:           backoffConfig,
+		MinConnectTimeout: getEnvDuration("GRPC_MIN_CONNECT_TIMEOUT", 20*time.Second),
+	}
+	addr := getEnv("PRODUCT_SERVICE_ADDR", "localhost:5051")
+	logger.Info("gRPC product service client settings",
+		"addr", addr,
+		"keepalive_time", kaParams.Time,
+		"keepalive_timeout", kaParams.Timeout,
+		"backoff_base_delay", backoffConfig.BaseDelay,
+		"backoff_max_delay", backoffConfig.MaxDelay,
+		"min_connect_timeout", connectParams.MinConnectTimeout,
+	)
 
 	// create a client connection
-	cc, err := grpc.Dial("localhost:5051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	return grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(kaParams),
+		grpc.WithConnectParams(connectParams),
+	)
+}
+
+// createProductGRPCClientConnection connects the product client to the product service, the connection
+// is kept in productGRPCClient to be closed on shutdown
+func createProductGRPCClientConnection() error {
+	logger.Info("initiating the gRPC client connection")
+
+	cc, err := dialProductService()
 	if err != nil {
-		log.Fatalf("failed to created client stub: %v", err)
+		return fmt.Errorf("failed to create the gRPC client connection: %w", err)
 	}
-	// defer cc.Close()
 
 	// create the product service client connection
-	conn = productpb.NewProductServiceClient(cc)
+	productGRPCClient = &grpcProductClient{cc: cc, conn: productpb.NewProductServiceClient(cc)}
+	productClient = &countingProductClient{productGRPCClient}
+	if getEnvBool("PRODUCT_LOOKUP_COALESCING", true) {
+		productClient = &countingProductClient{newCoalescingProductClient(productGRPCClient)}
+	}
+	return nil
 }
 
-func GetProductDetails(productId string) (*productpb.GetProductDetailsResponse, error) {
-	fmt.Println("Get product details via gRPC function")
+// stub returns the client of the current connection
+func (c *grpcProductClient) stub() productpb.ProductServiceClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// clientConn returns the current connection
+func (c *grpcProductClient) clientConn() *grpc.ClientConn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cc
+}
 
+// Close closes the current connection
+func (c *grpcProductClient) Close() error {
+	return c.clientConn().Close()
+}
+
+// replaceConn swaps in a new connection and closes the previous one
+func (c *grpcProductClient) replaceConn(cc *grpc.ClientConn) {
+	c.mu.Lock()
+	old := c.cc
+	c.cc = cc
+	c.conn = productpb.NewProductServiceClient(cc)
+	c.mu.Unlock()
+
+	if err := old.Close(); err != nil {
+		logger.Error("error closing the previous gRPC connection", "err", err)
+	}
+}
+
+func (c *grpcProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
 	// prepare the request
 	req := &productpb.GetProductDetailsRequest{
 		Id: productId,
 	}
 
 	// execute the rpc function
-	resp, err := conn.GetProductDetails(context.Background(), req)
+	var resp *productpb.GetProductDetailsResponse
+	err := retryProductCall(ctx, "GetProductDetails", func(ctx context.Context) (err error) {
+		resp, err = c.stub().GetProductDetails(ctx, req)
+		return err
+	})
 	if err != nil {
-		fmt.Printf("error serving the request: %v\n", err)
-		return resp, fmt.Errorf("error serving the request: %v", err)
+		logger.DebugContext(ctx, "GetProductDetails failed", "product_id", productId, "err", err)
+		return nil, fmt.Errorf("error serving the request: %w", err)
 	}
+	logger.DebugContext(ctx, "GetProductDetails", "product_id", productId)
 
-	// display the response
-	fmt.Printf("The product details are %+v\n", resp)
-
-	return resp, nil
+	return productDetailsFromProto(resp), nil
 }
 
-func ListProductDetails(productIds []string) (*productpb.ListProductDetailsResponse, error) {
-	fmt.Println("Get product details list via gRPC function")
-
+func (c *grpcProductClient) ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error) {
 	// prepare the request
 	var productIdsReq []*productpb.GetProductDetailsRequest
 	for _, productId := range productIds {
@@ -63,34 +154,59 @@ func ListProductDetails(productIds []string) (*productpb.ListProductDetailsRespo
 	}
 
 	// execute the rpc function
-	resp, err := conn.ListProductDetails(context.Background(), req)
+	var resp *productpb.ListProductDetailsResponse
+	err := retryProductCall(ctx, "ListProductDetails", func(ctx context.Context) (err error) {
+		resp, err = c.stub().ListProductDetails(ctx, req)
+		return err
+	})
 	if err != nil {
-		fmt.Printf("error serving the request: %v\n", err)
-		return &productpb.ListProductDetailsResponse{}, fmt.Errorf("error serving the request: %v", err)
+		logger.DebugContext(ctx, "ListProductDetails failed", "product_ids", productIds, "err", err)
+		return nil, fmt.Errorf("error serving the request: %w", err)
 	}
-
-	// display the response
-	fmt.Printf("The product details are %+v\n", resp)
-	return resp, nil
+	logger.DebugContext(ctx, "ListProductDetails", "product_ids", productIds, "found", len(resp.Details))
+	details := make([]*ProductDetails, 0, len(resp.Details))
+	for _, p := range resp.Details {
+		details = append(details, productDetailsFromProto(p))
+	}
+	return details, nil
 }
 
-func UpdateProductQuantity(productId string, quantity int64) error {
-	fmt.Println("Update product quantity via gRPC function")
-
+func (c *grpcProductClient) UpdateProductQuantity(ctx context.Context, productId string, quantity int64) error {
 	// prepare the request
 	req := &productpb.UpdateProductQuantityRequest{
 		Id:       productId,
 		Quantity: quantity,
 	}
 
-	// execute the rpc function
-	resp, err := conn.UpdateProductQuantity(context.Background(), req)
+	// execute the rpc function, the quantity is set rather than decremented so a retry is safe
+	err := retryProductCall(ctx, "UpdateProductQuantity", func(ctx context.Context) error {
+		_, err := c.stub().UpdateProductQuantity(ctx, req)
+		return err
+	})
 	if err != nil {
-		fmt.Printf("error serving the request: %v\n", err)
-		return fmt.Errorf("error serving the request: %v", err)
+		logger.DebugContext(ctx, "UpdateProductQuantity failed", "product_id", productId, "err", err)
+		return fmt.Errorf("error serving the request: %w", err)
 	}
-
-	// display the response
-	fmt.Println("Updated the product details:", resp)
+	logger.DebugContext(ctx, "UpdateProductQuantity", "product_id", productId, "quantity", quantity)
 	return nil
 }
+
+// countingProductClient counts every product service call against the request in the context
+type countingProductClient struct {
+	ProductClient
+}
+
+func (c *countingProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
+	countProductLookup(ctx)
+	return c.ProductClient.GetProductDetails(ctx, productId)
+}
+
+func (c *countingProductClient) ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error) {
+	countProductLookup(ctx)
+	return c.ProductClient.ListProductDetails(ctx, productIds)
+}
+
+func (c *countingProductClient) UpdateProductQuantity(ctx context.Context, productId string, quantity int64) error {
+	countProductLookup(ctx)
+	return c.ProductClient.UpdateProductQuantity(ctx, productId, quantity)
+}
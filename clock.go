@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Clock abstracts the current time so time-dependent logic can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+var clock Clock = realClock{}
+
+// legacyTimestampLayout is the time.Time.String() layout the timestamps were stored in before RFC 3339
+const legacyTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// formatTimestamp formats the time for the order timestamps and the responses, RFC 3339 in UTC
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTimestamp parses a timestamp in RFC 3339 or in the legacy layout
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Parse(legacyTimestampLayout, s)
+	}
+	return t, nil
+}
+
+// formatOptionalTimestamp formats the time like formatTimestamp, the zero time of a timestamp that isn't
+// set, like the dispatch time of an order that wasn't dispatched, is left empty
+func formatOptionalTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatTimestamp(t)
+}
+
+// Timestamp is a time marshaled as RFC 3339 in UTC, the timestamps of the responses and of the records the
+// stores keep as JSON or documents. The zero time is an empty string, and the records written when the
+// timestamps were strings in the legacy layout still read.
+type Timestamp struct {
+	time.Time
+}
+
+// optionalTimestamp returns the time as a Timestamp, nil for the zero time of a timestamp that isn't set so
+// an omitempty field is left out
+func optionalTimestamp(t time.Time) *Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return &Timestamp{t}
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatOptionalTimestamp(t.Time))
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parseStoredTimestamp(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// parseStoredTimestamp parses a timestamp read from a store, written by formatOptionalTimestamp or in
+// the legacy layout, empty is the zero time
+func parseStoredTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := parseTimestamp(s)
+	if err != nil {
+		return t, err
+	}
+	return t.UTC(), nil
+}
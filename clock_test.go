@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+	}{
+		{"rfc 3339", `"2024-05-01T12:30:00.0000005Z"`, at},
+		{"rfc 3339 with an offset", `"2024-05-01T14:30:00.0000005+02:00"`, at},
+		{"legacy layout", `"2024-05-01 12:30:00.0000005 +0000 UTC"`, at},
+		{"empty", `""`, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ts Timestamp
+			if err := json.Unmarshal([]byte(tt.json), &ts); err != nil {
+				t.Fatalf("unmarshaling %s failed: %v", tt.json, err)
+			}
+			if !ts.Equal(tt.want) {
+				t.Errorf("got %v, want %v", ts.Time, tt.want)
+			}
+
+			data, err := json.Marshal(ts)
+			if err != nil {
+				t.Fatalf("marshaling failed: %v", err)
+			}
+			want := `""`
+			if !tt.want.IsZero() {
+				want = `"2024-05-01T12:30:00.0000005Z"`
+			}
+			if string(data) != want {
+				t.Errorf("marshaled to %s, want %s", data, want)
+			}
+		})
+	}
+
+	var ts Timestamp
+	if err := json.Unmarshal([]byte(`"yesterday"`), &ts); err == nil {
+		t.Error("unmarshaling an invalid timestamp succeeded")
+	}
+}
+
+func TestOrderResponseOptionalTimestamps(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		order   Order
+		present []string
+		absent  []string
+	}{
+		{"placed", Order{CreatedAt: at, UpdatedAt: at}, []string{`"created_at":"2024-05-01T12:00:00Z"`}, []string{"dispatched_at", "deleted_at"}},
+		{"dispatched", Order{CreatedAt: at, UpdatedAt: at, DispatchedAt: at}, []string{`"dispatched_at":"2024-05-01T12:00:00Z"`}, []string{"deleted_at"}},
+		{"deleted", Order{CreatedAt: at, UpdatedAt: at, DeletedAt: at}, []string{`"deleted_at":"2024-05-01T12:00:00Z"`}, []string{"dispatched_at"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(newOrderResponse(tt.order))
+			if err != nil {
+				t.Fatalf("marshaling the response failed: %v", err)
+			}
+			for _, field := range tt.present {
+				if !strings.Contains(string(data), field) {
+					t.Errorf("%s doesn't have %s", data, field)
+				}
+			}
+			for _, field := range tt.absent {
+				if strings.Contains(string(data), field) {
+					t.Errorf("%s has %s", data, field)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderUnmarshalLegacyTimestamps(t *testing.T) {
+	data := `{"ID":"o1","CreatedAt":"2024-05-01 12:00:00 +0000 UTC","UpdatedAt":"2024-05-01T12:00:00Z","DispatchedAt":"","DeletedAt":"",
+		"History":[{"to":"placed","at":"2024-05-01 12:00:00 +0000 UTC","actor":"client"}],
+		"Refund":{"amount":10,"refunded_at":"2024-05-01T12:00:00Z"}}`
+	var o Order
+	if err := json.Unmarshal([]byte(data), &o); err != nil {
+		t.Fatalf("unmarshaling the order failed: %v", err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if !o.CreatedAt.Equal(at) || !o.History[0].At.Equal(at) || !o.Refund.RefundedAt.Equal(at) {
+		t.Errorf("timestamps = %v, %v, %v, want %v", o.CreatedAt, o.History[0].At.Time, o.Refund.RefundedAt.Time, at)
+	}
+	if !o.DispatchedAt.IsZero() || isDeleted(o) {
+		t.Errorf("the empty timestamps weren't read as unset: dispatched %v, deleted %v", o.DispatchedAt, o.DeletedAt)
+	}
+}
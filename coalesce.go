@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescingProductClient collapses concurrent lookups of the same product into a single call to the
+// product service, so a trending product doesn't turn into a hot key. Updates are passed through.
+type coalescingProductClient struct {
+	ProductClient
+	lookups singleflight.Group
+}
+
+func newCoalescingProductClient(c ProductClient) *coalescingProductClient {
+	return &coalescingProductClient{ProductClient: c}
+}
+
+type freshLookupKey struct{}
+
+// withFreshLookup marks the context so its lookups aren't served from an in-flight lookup that may have
+// started before a change, e.g. the re-read of the inventory guarding a quantity update
+func withFreshLookup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshLookupKey{}, true)
+}
+
+// GetProductDetails shares the result of an in-flight lookup of the product. The lookup is detached from
+// the cancellation of the caller that started it and bounded by productCallTimeout instead, so that
+// caller going away doesn't fail the others waiting on it. A caller whose context is done stops waiting.
+func (c *coalescingProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
+	if fresh, _ := ctx.Value(freshLookupKey{}).(bool); fresh {
+		return c.ProductClient.GetProductDetails(ctx, productId)
+	}
+
+	ch := c.lookups.DoChan(productId, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), productCallTimeout)
+		defer cancel()
+		return c.ProductClient.GetProductDetails(lookupCtx, productId)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		// every caller gets its own copy of the shared result
+		details := *res.Val.(*ProductDetails)
+		return &details, nil
+	}
+}
+
+// UpdateProductQuantity forgets the in-flight lookup of the product once the update is done, so a lookup
+// started after the update doesn't share the result of one started before it
+func (c *coalescingProductClient) UpdateProductQuantity(ctx context.Context, productId string, quantity int64) error {
+	err := c.ProductClient.UpdateProductQuantity(ctx, productId, quantity)
+	c.lookups.Forget(productId)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingProductClient holds the lookups of the fake client until release is closed and counts them
+type blockingProductClient struct {
+	*fakeProductClient
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
+	if b.calls.Add(1) == 1 {
+		close(b.started)
+	}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.fakeProductClient.GetProductDetails(ctx, productId)
+}
+
+func TestCoalescingProductClientSharesLookups(t *testing.T) {
+	lookupErr := errors.New("product service unavailable")
+	tests := []struct {
+		name         string
+		callers      int
+		failLookup   bool
+		cancelLeader bool
+	}{
+		{"concurrent callers", 10, false, false},
+		{"leader cancels", 10, false, true},
+		{"failed lookup", 5, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProductClient(fakeSampleProducts()...)
+			if tt.failLookup {
+				fake.FailGet("p1", lookupErr)
+			}
+			inner := &blockingProductClient{fakeProductClient: fake, started: make(chan struct{}), release: make(chan struct{})}
+			client := newCoalescingProductClient(inner)
+
+			leaderCtx, cancelLeader := context.WithCancel(context.Background())
+			defer cancelLeader()
+			leaderErr := make(chan error, 1)
+			go func() {
+				_, err := client.GetProductDetails(leaderCtx, "p1")
+				leaderErr <- err
+			}()
+			<-inner.started
+
+			type result struct {
+				details *ProductDetails
+				err     error
+			}
+			results := make(chan result, tt.callers)
+			var wg sync.WaitGroup
+			for i := 0; i < tt.callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					details, err := client.GetProductDetails(context.Background(), "p1")
+					results <- result{details, err}
+				}()
+			}
+			// let the callers join the in-flight lookup before it completes
+			time.Sleep(50 * time.Millisecond)
+			if tt.cancelLeader {
+				cancelLeader()
+				if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+					t.Errorf("leader err = %v, want %v", err, context.Canceled)
+				}
+			}
+			close(inner.release)
+			wg.Wait()
+			close(results)
+
+			if calls := inner.calls.Load(); calls != 1 {
+				t.Errorf("product service was called %v times, want 1", calls)
+			}
+			for res := range results {
+				if tt.failLookup {
+					if !errors.Is(res.err, lookupErr) {
+						t.Errorf("err = %v, want %v", res.err, lookupErr)
+					}
+					continue
+				}
+				if res.err != nil {
+					t.Errorf("coalesced lookup failed: %v", res.err)
+					continue
+				}
+				if res.details.ID != "p1" {
+					t.Errorf("got product %q, want p1", res.details.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescingProductClientFreshLookup(t *testing.T) {
+	fake := newFakeProductClient(fakeSampleProducts()...)
+	inner := &blockingProductClient{fakeProductClient: fake, started: make(chan struct{}), release: make(chan struct{})}
+	close(inner.release)
+	client := newCoalescingProductClient(inner)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetProductDetails(withFreshLookup(context.Background()), "p1"); err != nil {
+			t.Fatalf("fresh lookup failed: %v", err)
+		}
+	}
+	if calls := inner.calls.Load(); calls != 3 {
+		t.Errorf("product service was called %v times, want 3", calls)
+	}
+}
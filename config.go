@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// getEnv returns the value of the environment variable or the fallback if it is not set
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration parses the environment variable as a time.Duration, falling back on a missing or invalid value
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("invalid duration, using the default", "key", key, "default", fallback, "err", err)
+		return fallback
+	}
+	return d
+}
+
+// getEnvBool parses the environment variable as a bool, falling back on a missing or invalid value
+func getEnvBool(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn("invalid bool, using the default", "key", key, "default", fallback, "err", err)
+		return fallback
+	}
+	return b
+}
+
+// getEnvInt parses the environment variable as an int, falling back on a missing or invalid value
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warn("invalid int, using the default", "key", key, "default", fallback, "err", err)
+		return fallback
+	}
+	return i
+}
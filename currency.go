@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// currency code -> number of digits of its minor unit
+var currencyMinorUnits = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+}
+
+// orderCurrency is the currency the orders are priced in, the product prices are taken to be in it
+var orderCurrency = "USD"
+
+// loadCurrencyConfig reads ORDER_CURRENCY, a currency missing from the metadata table is rejected
+func loadCurrencyConfig() error {
+	currency := strings.ToUpper(getEnv("ORDER_CURRENCY", "USD"))
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return fmt.Errorf("unsupported currency: %v", currency)
+	}
+	orderCurrency = currency
+	logger.Info("order currency loaded", "currency", orderCurrency, "minor_unit_digits", currencyMinorUnits[orderCurrency])
+	return nil
+}
+
+// minorUnitScale returns the number of minor units in one unit of the currency, 100 for USD and 1 for JPY
+func minorUnitScale(currency string) int64 {
+	return int64(math.Pow10(currencyMinorUnits[currency]))
+}
+
+// toMinorUnits converts the amount to the currency's minor units, rounding half away from zero
+func toMinorUnits(amount float64, currency string) int64 {
+	return int64(math.Round(amount * float64(minorUnitScale(currency))))
+}
+
+// fromMinorUnits converts an amount in minor units back to the currency's units
+func fromMinorUnits(units int64, currency string) float64 {
+	return float64(units) / float64(minorUnitScale(currency))
+}
+
+// storedMinorUnits returns the amount in minor units of a stored record. The records written before the
+// amounts were kept in minor units only have the amount in units, it is converted.
+func storedMinorUnits(units int64, legacyAmount float64, currency string) int64 {
+	if units == 0 && legacyAmount != 0 {
+		return toMinorUnits(legacyAmount, currency)
+	}
+	return units
+}
+
+// lineTotalMinorUnits is the total of the item in the minor units of the currency, the unit price times
+// the quantity
+func lineTotalMinorUnits(item OrderItem) int64 {
+	return item.UnitPriceMinor * item.ProductQuantity
+}
+
+// percentOfMinorUnits is the percent of the non negative amount in minor units, rounding half up. The
+// discounts are computed on the integer amounts so they don't drift with the float64 ones.
+func percentOfMinorUnits(units, percent int64) int64 {
+	return (units*percent + 50) / 100
+}
+
+// amountCurrency is the currency of the order, the orders placed before they had one are in the
+// configured currency
+func amountCurrency(o Order) string {
+	if o.Currency == "" {
+		return orderCurrency
+	}
+	return o.Currency
+}
+
+// formatAmount formats the amount in minor units with the number of decimals of the currency
+func formatAmount(units int64, currency string) string {
+	return strconv.FormatFloat(fromMinorUnits(units, currency), 'f', currencyMinorUnits[currency], 64) + " " + currency
+}
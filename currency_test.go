@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []int64
+		discount int64
+		want     []int64
+	}{
+		{"no discount", []int64{1000, 500}, 0, []int64{0, 0}},
+		{"proportional", []int64{1000, 3000}, 400, []int64{100, 300}},
+		{"rounding goes to the largest remainders", []int64{100, 100, 100}, 100, []int64{34, 33, 33}},
+		{"single line", []int64{999}, 333, []int64{333}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocateDiscount(tt.lines, tt.discount)
+			var sum int64
+			for i := range got {
+				sum += got[i]
+				if got[i] != tt.want[i] {
+					t.Errorf("shares = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			if sum != tt.discount {
+				t.Errorf("shares add up to %v, want %v", sum, tt.discount)
+			}
+		})
+	}
+}
+
+func TestPriceOrderInMinorUnits(t *testing.T) {
+	// 0.1 * 3 isn't 0.3 in float64, the minor units are exact
+	items := []OrderItem{
+		{ProductId: "p1", ProductQuantity: 3, UnitPriceMinor: toMinorUnits(0.1, "USD")},
+		{ProductId: "p2", ProductQuantity: 1, UnitPriceMinor: toMinorUnits(19.99, "USD")},
+	}
+	o := Order{Currency: "USD"}
+	priceOrder(&o, items, &AppliedDiscount{Type: DiscountCoupon, Code: "TEN", Percent: 10})
+
+	if o.AmountMinor != 2029-203 {
+		t.Errorf("AmountMinor = %v, want %v", o.AmountMinor, 2029-203)
+	}
+	if got := newOrderResponse(o).AmountFormatted; got != "18.26 USD" {
+		t.Errorf("AmountFormatted = %q, want %q", got, "18.26 USD")
+	}
+	if items[0].DiscountMinor+items[1].DiscountMinor != 203 {
+		t.Errorf("item discounts = %v + %v, want 203", items[0].DiscountMinor, items[1].DiscountMinor)
+	}
+}
+
+func TestLegacyAmountsJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		got  func(data []byte) (int64, error)
+		want int64
+	}{
+		{"order amount", `{"ID":"o1","Amount":12.34}`, func(data []byte) (int64, error) {
+			var o Order
+			err := json.Unmarshal(data, &o)
+			return o.AmountMinor, err
+		}, 1234},
+		{"order amount in a zero decimal currency", `{"ID":"o1","Amount":1200,"Currency":"JPY"}`, func(data []byte) (int64, error) {
+			var o Order
+			err := json.Unmarshal(data, &o)
+			return o.AmountMinor, err
+		}, 1200},
+		{"order amount in minor units", `{"ID":"o1","AmountMinor":1234}`, func(data []byte) (int64, error) {
+			var o Order
+			err := json.Unmarshal(data, &o)
+			return o.AmountMinor, err
+		}, 1234},
+		{"item unit price", `{"ProductId":"p1","UnitPrice":0.29,"Discount":0.03}`, func(data []byte) (int64, error) {
+			var item OrderItem
+			err := json.Unmarshal(data, &item)
+			return item.UnitPriceMinor*100 + item.DiscountMinor, err
+		}, 29*100 + 3},
+		{"discount amount", `{"type":"coupon","percent":10,"amount":1.25}`, func(data []byte) (int64, error) {
+			var d AppliedDiscount
+			err := json.Unmarshal(data, &d)
+			return d.AmountMinor, err
+		}, 125},
+		{"refund amount in its currency", `{"amount":500,"currency":"JPY"}`, func(data []byte) (int64, error) {
+			var rf Refund
+			err := json.Unmarshal(data, &rf)
+			return rf.AmountMinor, err
+		}, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.got([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unmarshaling %s failed: %v", tt.data, err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigrationToMinorUnits(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("opening the database failed: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// a database at the last version with the amounts in units of the currency
+	var legacy []migration
+	for _, m := range sqliteMigrations {
+		if m.version < 8 {
+			legacy = append(legacy, m)
+		}
+	}
+	if err := migrateSchema(db, sqliteDialect, legacy); err != nil {
+		t.Fatalf("migrating to the legacy schema failed: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO orders (tenant_id, id, discount, amount, currency, status, dispatched_at, created_at, updated_at,
+		status_changed_at, sla_breached, cart_id, discounts, version, order_number, notes, metadata, priority, callback_url,
+		failure_reason, refund) VALUES ('t1', 'o1', 10, 18.26, 'USD', 'returned', '', '2024-05-01T12:00:00Z', '2024-05-01T12:00:00Z',
+		'2024-05-01T12:00:00Z', 0, '', '[{"type":"coupon","code":"TEN","percent":10,"amount":2.03}]', 1, 1, '', '{}', 'normal', '',
+		'', '{"amount":18.26,"currency":"USD","refunded_at":"2024-05-01T12:00:00Z"}')`)
+	if err != nil {
+		t.Fatalf("inserting the legacy order failed: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO order_items (tenant_id, order_id, position, product_id, quantity, unit_price, category, discount, name)
+		VALUES ('t1', 'o1', 0, 'p1', 3, 0.1, '', 0.03, 'Pen'), ('t1', 'o1', 1, 'p2', 1, 19.99, '', 2, 'Book')`)
+	if err != nil {
+		t.Fatalf("inserting the legacy items failed: %v", err)
+	}
+
+	if err := migrateSchema(db, sqliteDialect, sqliteMigrations); err != nil {
+		t.Fatalf("migrating to minor units failed: %v", err)
+	}
+	o, items, ok, err := NewSQLiteStore(db, "t1").GetOrder("o1")
+	if err != nil || !ok {
+		t.Fatalf("reading the migrated order failed: %v, found: %v", err, ok)
+	}
+	if o.AmountMinor != 1826 || o.Discounts[0].AmountMinor != 203 || o.Refund.AmountMinor != 1826 {
+		t.Errorf("amounts = %v, discount %v, refund %v, want 1826, 203, 1826", o.AmountMinor, o.Discounts[0].AmountMinor, o.Refund.AmountMinor)
+	}
+	if items[0].UnitPriceMinor != 10 || items[0].DiscountMinor != 3 || items[1].UnitPriceMinor != 1999 || items[1].DiscountMinor != 200 {
+		t.Errorf("items = %+v, want the prices and discounts in cents", items)
+	}
+}
+
+func TestPlaceOrderInCurrencyDecimals(t *testing.T) {
+	tests := []struct {
+		currency      string
+		prices        []float64
+		wantMinor     int64
+		wantAmount    float64
+		wantFormatted string
+	}{
+		// 450 yen less the 10% premium discount
+		{"JPY", []float64{250, 120, 80}, 405, 405, "405 JPY"},
+		// 2.088 dinars less the 10% premium discount of 0.2088, rounded half up to 0.209
+		{"BHD", []float64{1.255, 0.5, 0.333}, 1879, 1.879, "1.879 BHD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			useMemoryStores(t)
+			products := fakeSampleProducts()
+			for i, price := range tt.prices {
+				products[i].Price = price
+			}
+			useFakeProductClient(t, products...)
+			prev := orderCurrency
+			orderCurrency = tt.currency
+			t.Cleanup(func() { orderCurrency = prev })
+
+			rec := httptest.NewRecorder()
+			PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+				`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}]}`, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+			}
+			var resp CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding the order failed: %v", err)
+			}
+			if resp.Currency != tt.currency || resp.Amount != tt.wantAmount || resp.AmountFormatted != tt.wantFormatted {
+				t.Errorf("amount = %v %v formatted %q, want %v %v formatted %q",
+					resp.Amount, resp.Currency, resp.AmountFormatted, tt.wantAmount, tt.currency, tt.wantFormatted)
+			}
+
+			o, items, _, err := tenantStore("t1").GetOrder(resp.ID)
+			if err != nil {
+				t.Fatalf("reading the order failed: %v", err)
+			}
+			if o.AmountMinor != tt.wantMinor {
+				t.Errorf("AmountMinor = %v, want %v", o.AmountMinor, tt.wantMinor)
+			}
+			// the discount attributed to the items adds up to the one taken off the order
+			var subtotal, discount int64
+			for _, item := range items {
+				subtotal += lineTotalMinorUnits(item)
+				discount += item.DiscountMinor
+			}
+			if subtotal-discount != o.AmountMinor {
+				t.Errorf("items total %v less their discounts %v, want %v", subtotal, discount, o.AmountMinor)
+			}
+		})
+	}
+}
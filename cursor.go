@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// orderCursor points past the last order of a page of GET /orders, the next page starts with the first
+// order sorting after it. It carries the sort it was issued for and the sort keys of the order rather
+// than a position, so orders placed or removed meanwhile don't shift the pages.
+type orderCursor struct {
+	Sort        string      `json:"sort"`
+	Descending  bool        `json:"desc"`
+	ID          string      `json:"id"`
+	CreatedAt   string      `json:"created_at,omitempty"`
+	AmountMinor int64       `json:"amount_minor,omitempty"`
+	Status      OrderStatus `json:"status,omitempty"`
+}
+
+// newOrderCursor returns the cursor of the page ending with the order
+func newOrderCursor(o Order, sortField string, descending bool) orderCursor {
+	c := orderCursor{Sort: sortField, Descending: descending, ID: o.ID}
+	switch sortField {
+	case "amount":
+		c.AmountMinor = o.AmountMinor
+	case "status":
+		c.Status = o.Status
+	default:
+		c.CreatedAt = formatTimestamp(o.CreatedAt)
+	}
+	return c
+}
+
+// order returns an order with the sort keys of the cursor, to compare the listed orders against
+func (c orderCursor) order() Order {
+	createdAt, _ := parseStoredTimestamp(c.CreatedAt)
+	return Order{ID: c.ID, CreatedAt: createdAt, AmountMinor: c.AmountMinor, Status: c.Status}
+}
+
+// encode returns the opaque form of the cursor handed to the clients
+func (c orderCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
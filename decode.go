@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The codes of the request body errors in the error envelope
+const (
+	errCodeEmptyBody          = "empty_body"
+	errCodeBodyTooLarge       = "body_too_large"
+	errCodeUnknownField       = "unknown_field"
+	errCodeQuantityOutOfRange = "quantity_out_of_range"
+	errCodeMalformedJSON      = "malformed_json"
+)
+
+// maxRequestBodyBytes caps the size of the request bodies, set by MAX_REQUEST_BODY_BYTES
+var maxRequestBodyBytes int64 = 1 << 20
+
+// decodeJSONBody decodes the request body into v. Bodies over maxRequestBodyBytes and fields v doesn't
+// have are rejected, the error is answered with writeDecodeError.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeDecodeError logs the decode error and answers with a 413 for a body over the limit and a 400
+// otherwise
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, io.EOF):
+		logger.InfoContext(r.Context(), "empty request body")
+		writeJSONErrorCode(w, http.StatusBadRequest, errCodeEmptyBody, "request body is empty")
+	case errors.As(err, &maxBytesErr):
+		logger.InfoContext(r.Context(), "request body too large", "limit", maxBytesErr.Limit)
+		writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, fmt.Sprintf("request body must be at most %v bytes", maxBytesErr.Limit))
+	case isUnknownField(err):
+		logger.InfoContext(r.Context(), "unknown field in the request body", "err", err)
+		writeJSONErrorCode(w, http.StatusBadRequest, errCodeUnknownField, strings.TrimPrefix(err.Error(), "json: ")+" in the request body")
+	case isQuantityOutOfRange(err):
+		logger.InfoContext(r.Context(), "product quantity out of range", "err", err)
+		writeJSONErrorCode(w, http.StatusBadRequest, errCodeQuantityOutOfRange, "product quantity is out of range")
+	default:
+		logger.InfoContext(r.Context(), "error unmarshaling the request body", "err", err)
+		writeJSONErrorCode(w, http.StatusBadRequest, errCodeMalformedJSON, "Invalid Request Body")
+	}
+}
+
+// isUnknownField reports whether the decode error was caused by a field the request doesn't have, the
+// decoder has no typed error for it
+func isUnknownField(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field ")
+}
+
+// isQuantityOutOfRange reports whether the decode error was caused by a quantity that doesn't fit in an int64
+func isQuantityOutOfRange(err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || !strings.HasSuffix(typeErr.Field, "quantity") {
+		return false
+	}
+	number := strings.TrimPrefix(typeErr.Value, "number ")
+	if number == typeErr.Value {
+		return false
+	}
+	_, err = strconv.ParseInt(number, 10, 64)
+	return errors.Is(err, strconv.ErrRange)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWriteDecodeErrorCodes(t *testing.T) {
+	endpoints := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		vars    map[string]string
+	}{
+		{"place order", http.MethodPost, PlaceOrderHandler, nil},
+		{"update status", http.MethodPut, UpdateOrderStatusHandler, map[string]string{"order_id": "o1"}},
+	}
+	bodies := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"empty", "", http.StatusBadRequest, errCodeEmptyBody},
+		{"malformed", `{"items":`, http.StatusBadRequest, errCodeMalformedJSON},
+		{"not an object", `[1, 2]`, http.StatusBadRequest, errCodeMalformedJSON},
+		{"unknown field", `{"unknown":1}`, http.StatusBadRequest, errCodeUnknownField},
+		{"too large", `{"status":"` + strings.Repeat("x", int(maxRequestBodyBytes)) + `"}`, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge},
+	}
+	for _, e := range endpoints {
+		for _, b := range bodies {
+			t.Run(e.name+"/"+b.name, func(t *testing.T) {
+				req := httptest.NewRequest(e.method, "/orders", strings.NewReader(b.body))
+				req = req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, "t1"))
+				if e.vars != nil {
+					req = mux.SetURLVars(req, e.vars)
+				}
+				rec := httptest.NewRecorder()
+				e.handler(rec, req)
+
+				if rec.Code != b.status {
+					t.Errorf("status = %v, want %v", rec.Code, b.status)
+				}
+				var body ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("the body is not the error envelope: %v", err)
+				}
+				if body.Code != b.code || body.Status != b.status {
+					t.Errorf("body = %+v, want code %q and status %v", body, b.code, b.status)
+				}
+			})
+		}
+	}
+}
+
+func TestPlaceOrderQuantityOverflow(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+
+	rec := httptest.NewRecorder()
+	PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+		`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":99999999999999999999}]}`, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %v, want 400: %s", rec.Code, rec.Body)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("the body is not the error envelope: %v", err)
+	}
+	if body.Code != errCodeQuantityOutOfRange || body.Error != "product quantity is out of range" {
+		t.Errorf("body = %+v, want the quantity out of range error", body)
+	}
+}
+
+func TestPlaceOrderBodyChecks(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	prev := maxRequestBodyBytes
+	maxRequestBodyBytes = 128
+	t.Cleanup(func() { maxRequestBodyBytes = prev })
+
+	tests := []struct {
+		name        string
+		body        string
+		status      int
+		code        string
+		wantMessage string
+	}{
+		{"misspelled items", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","itmes":[{"product_id":"p1","quantity":1}]}`,
+			http.StatusBadRequest, errCodeUnknownField, `unknown field "itmes" in the request body`},
+		{"over the configured limit", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","notes":"` + strings.Repeat("x", 128) + `","items":[{"product_id":"p1","quantity":1}]}`,
+			http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "request body must be at most 128 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders", tt.body, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("the body is not the error envelope: %v", err)
+			}
+			if body.Code != tt.code || body.Error != tt.wantMessage {
+				t.Errorf("body = %+v, want code %q and error %q", body, tt.code, tt.wantMessage)
+			}
+
+			// a rejected body places nothing
+			p1, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil || p1.Quantity != 100 {
+				t.Errorf("product p1 = %+v, %v, want the untouched stock", p1, err)
+			}
+		})
+	}
+}
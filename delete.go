@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// isDeleted reports whether the order was deleted, a deleted order is only seen by admins asking for it
+// with ?include_deleted=true until it is restored
+func isDeleted(o Order) bool {
+	return !o.DeletedAt.IsZero()
+}
+
+// parseIncludeDeleted reads ?include_deleted=, answering the request itself if the value is invalid or
+// the caller isn't an admin
+func parseIncludeDeleted(w http.ResponseWriter, r *http.Request) (includeDeleted bool, ok bool) {
+	v := r.URL.Query().Get("include_deleted")
+	if v == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.InfoContext(r.Context(), "invalid include_deleted value", "value", v)
+		writeJSONError(w, http.StatusBadRequest, "include_deleted must be true or false")
+		return false, false
+	}
+	if b && !isAdmin(r) {
+		logger.InfoContext(r.Context(), "deleted orders requested by a non admin caller")
+		writeJSONError(w, http.StatusForbidden, "only an admin can include the deleted orders")
+		return false, false
+	}
+	return b, true
+}
+
+// DeleteOrderHandler deletes a placed or cancelled order, hiding it until it is restored. A placed order
+// is cancelled on the way and its inventory is restored, so a restored order never has to take its
+// stock again.
+func DeleteOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+
+	// a dispatched order can't be un-shipped
+	if o.Status != OrderPlaced && o.Status != OrderCancelled {
+		logger.InfoContext(r.Context(), "order can't be deleted in its status", "order_id", o.ID, "status", o.Status)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is %v, only placed or cancelled orders can be deleted", o.ID, o.Status))
+		return
+	}
+	if o.Status == OrderPlaced && !orderStateMachine.CanTransition(OrderPlaced, OrderCancelled) {
+		logger.InfoContext(r.Context(), "placed orders can't be cancelled with the order transitions", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is placed and placed orders can't be cancelled", o.ID))
+		return
+	}
+
+	readVersion := o.Version
+	now := clock.Now()
+	previousStatus, previousStatusChangedAt := o.Status, o.StatusChangedAt
+	if o.Status == OrderPlaced {
+		o.Status = OrderCancelled
+		o.StatusChangedAt = now
+		o.SlaBreached = false
+		appendStatusHistory(&o, previousStatus, now, requestActor(r))
+	}
+	o.DeletedAt = now
+	o.UpdatedAt = now
+	o.Version++
+
+	// Update the database
+	err = store.UpdateOrder(o, readVersion)
+	if errors.Is(err, errVersionConflict) {
+		logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.InfoContext(r.Context(), "deleted order", "order_id", o.ID, "status", previousStatus)
+
+	if previousStatus == OrderPlaced {
+		err = orderStateMachine.Transition(r.Context(), previousStatus, o.Status, orderTransition{order: o, items: oItems, since: previousStatusChangedAt})
+		if err != nil {
+			logger.ErrorContext(r.Context(), "error completing the order status transition", "order_id", o.ID, "err", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreOrderHandler brings a deleted order back, in the status it was deleted in
+func RestoreOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// Verify if the order is present in the database
+	if !ok {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+	if !isDeleted(o) {
+		logger.InfoContext(r.Context(), "order is not deleted", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is not deleted", o.ID))
+		return
+	}
+
+	readVersion := o.Version
+	o.DeletedAt = time.Time{}
+	o.UpdatedAt = clock.Now()
+	o.Version++
+
+	// Update the database
+	err = store.UpdateOrder(o, readVersion)
+	if errors.Is(err, errVersionConflict) {
+		logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.InfoContext(r.Context(), "restored order", "order_id", o.ID, "status", o.Status)
+
+	// Prepare the response
+	orderDetails := newOrderResponse(o)
+
+	// Get the item details
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	orderDetails.Items = orderItemsDetailsList
+
+	setOrderETag(w, o)
+	writeJSON(w, http.StatusOK, orderDetails)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// policies for combining the discounts an order qualifies for
+const (
+	// only the largest discount is applied
+	StackingBest = "best"
+	// every discount is applied
+	StackingStack = "stack"
+	// every discount is applied up to maxDiscountPercent in total
+	StackingCapped = "capped"
+)
+
+var (
+	discountStacking         = StackingBest
+	maxDiscountPercent int64 = 30
+	// coupon code -> discount percent
+	coupons = map[string]int64{}
+)
+
+// discount applied to an order, part of the order's price breakdown. AmountMinor is in the minor units
+// of the order's currency.
+type AppliedDiscount struct {
+	Type        string `json:"type"`
+	Code        string `json:"code,omitempty"`
+	Percent     int64  `json:"percent"`
+	AmountMinor int64  `json:"amount_minor"`
+}
+
+// UnmarshalJSON reads the discounts stored before the amounts were in minor units, their amount is in the
+// units of the configured currency the orders were priced in
+func (d *AppliedDiscount) UnmarshalJSON(data []byte) error {
+	type appliedDiscount AppliedDiscount
+	stored := struct {
+		*appliedDiscount
+		Amount float64 `json:"amount"`
+	}{appliedDiscount: (*appliedDiscount)(d)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	d.AmountMinor = storedMinorUnits(d.AmountMinor, stored.Amount, orderCurrency)
+	return nil
+}
+
+// DiscountResponse is an applied discount in the responses and the events, with its amount in units of
+// the currency
+type DiscountResponse struct {
+	Type    string  `json:"type"`
+	Code    string  `json:"code,omitempty"`
+	Percent int64   `json:"percent"`
+	Amount  float64 `json:"amount"`
+}
+
+// newDiscountResponses prepares the discounts of the order in its currency
+func newDiscountResponses(discounts []AppliedDiscount, currency string) []DiscountResponse {
+	responses := make([]DiscountResponse, 0, len(discounts))
+	for _, d := range discounts {
+		responses = append(responses, DiscountResponse{Type: d.Type, Code: d.Code, Percent: d.Percent, Amount: fromMinorUnits(d.AmountMinor, currency)})
+	}
+	return responses
+}
+
+// loadDiscountConfig reads DISCOUNT_STACKING (best/stack/capped), DISCOUNT_MAX_PERCENT used by the capped
+// policy and DISCOUNT_COUPONS, a comma separated list of CODE:percent pairs
+func loadDiscountConfig() {
+	discountStacking = getEnv("DISCOUNT_STACKING", StackingBest)
+	switch discountStacking {
+	case StackingBest, StackingStack, StackingCapped:
+	default:
+		logger.Warn("invalid DISCOUNT_STACKING, using the default", "value", discountStacking, "default", StackingBest)
+		discountStacking = StackingBest
+	}
+	maxDiscountPercent = int64(getEnvInt("DISCOUNT_MAX_PERCENT", 30))
+
+	coupons = map[string]int64{}
+	for _, entry := range strings.Split(getEnv("DISCOUNT_COUPONS", ""), ",") {
+		if entry == "" {
+			continue
+		}
+		code, percent, found := strings.Cut(entry, ":")
+		p, err := strconv.ParseInt(percent, 10, 64)
+		if !found || err != nil || p <= 0 || p > 100 {
+			logger.Warn("invalid coupon entry", "entry", entry)
+			continue
+		}
+		coupons[strings.ToUpper(strings.TrimSpace(code))] = p
+	}
+	logger.Info("discount config loaded", "stacking", discountStacking, "max_discount_percent", maxDiscountPercent, "coupons", len(coupons))
+}
+
+// applyDiscountPolicy picks the discounts to apply out of the ones the order qualifies for according to
+// the stacking policy and computes their amounts on the subtotal, in the minor units of the currency. It
+// returns the applied discounts and their total percent and amount in minor units.
+func applyDiscountPolicy(subtotal int64, qualified []AppliedDiscount) ([]AppliedDiscount, int64, int64) {
+	if len(qualified) == 0 {
+		return nil, 0, 0
+	}
+
+	// largest discount first, so best picks it and capped trims the smallest ones
+	candidates := append([]AppliedDiscount(nil), qualified...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Percent > candidates[j].Percent
+	})
+	if discountStacking == StackingBest {
+		candidates = candidates[:1]
+	}
+
+	var applied []AppliedDiscount
+	var totalPercent int64
+	var totalAmount int64
+	for _, d := range candidates {
+		if discountStacking == StackingCapped && totalPercent+d.Percent > maxDiscountPercent {
+			d.Percent = maxDiscountPercent - totalPercent
+		}
+		if totalPercent+d.Percent > 100 {
+			d.Percent = 100 - totalPercent
+		}
+		if d.Percent <= 0 {
+			break
+		}
+		amount := percentOfMinorUnits(subtotal, d.Percent)
+		d.AmountMinor = amount
+		totalPercent += d.Percent
+		totalAmount += amount
+		applied = append(applied, d)
+	}
+	return applied, totalPercent, totalAmount
+}
+
+// allocateDiscount splits the discount over the line totals proportionally, both in the minor units of
+// the currency. The minor units lost to rounding down go one each to the lines with the largest
+// remainders, earlier lines first on ties, so the shares always add up to the discount.
+func allocateDiscount(lineCents []int64, discountCents int64) []int64 {
+	shareCents := make([]int64, len(lineCents))
+	if discountCents <= 0 || len(lineCents) == 0 {
+		return shareCents
+	}
+
+	var subtotalCents int64
+	for _, cents := range lineCents {
+		subtotalCents += cents
+	}
+	if subtotalCents <= 0 {
+		return shareCents
+	}
+
+	remainders := make([]int64, len(lineCents))
+	var allocated int64
+	for i, cents := range lineCents {
+		shareCents[i] = cents * discountCents / subtotalCents
+		remainders[i] = cents * discountCents % subtotalCents
+		allocated += shareCents[i]
+	}
+
+	order := make([]int, len(lineCents))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; allocated < discountCents; i++ {
+		shareCents[order[i%len(order)]]++
+		allocated++
+	}
+	return shareCents
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDiscountStackingPolicies(t *testing.T) {
+	// 450 of premium products, qualifying for the 10% premium discount, with the 25% SAVE25 coupon
+	tests := []struct {
+		stacking      string
+		wantDiscounts []DiscountResponse
+		wantPercent   int64
+		wantAmount    float64
+	}{
+		{StackingBest, []DiscountResponse{{Type: DiscountCoupon, Code: "SAVE25", Percent: 25, Amount: 112.5}}, 25, 337.5},
+		{StackingStack, []DiscountResponse{
+			{Type: DiscountCoupon, Code: "SAVE25", Percent: 25, Amount: 112.5},
+			{Type: DiscountPremium, Percent: 10, Amount: 45},
+		}, 35, 292.5},
+		// the premium discount is trimmed to the 30% cap
+		{StackingCapped, []DiscountResponse{
+			{Type: DiscountCoupon, Code: "SAVE25", Percent: 25, Amount: 112.5},
+			{Type: DiscountPremium, Percent: 5, Amount: 22.5},
+		}, 30, 315},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stacking, func(t *testing.T) {
+			useMemoryStores(t)
+			useFakeProductClient(t, fakeSampleProducts()...)
+			prevStacking, prevMax, prevCoupons := discountStacking, maxDiscountPercent, coupons
+			discountStacking, maxDiscountPercent, coupons = tt.stacking, 30, map[string]int64{"SAVE25": 25}
+			t.Cleanup(func() { discountStacking, maxDiscountPercent, coupons = prevStacking, prevMax, prevCoupons })
+
+			rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","coupon_code":"save25","items":[`+
+				`{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+			}
+			var o CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+				t.Fatalf("decoding the order failed: %v", err)
+			}
+			if !reflect.DeepEqual(o.Discounts, tt.wantDiscounts) {
+				t.Errorf("discounts = %+v, want %+v", o.Discounts, tt.wantDiscounts)
+			}
+			if o.Discount != tt.wantPercent || o.Amount != tt.wantAmount {
+				t.Errorf("discount %v%% for an amount of %v, want %v%% for %v", o.Discount, o.Amount, tt.wantPercent, tt.wantAmount)
+			}
+		})
+	}
+}
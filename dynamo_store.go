@@ -0,0 +1,890 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoTimeout bounds every call to DynamoDB, set by ORDER_STORE_TIMEOUT
+var dynamoTimeout = 5 * time.Second
+
+// The store uses a single table keyed by PK and SK. Every record of a tenant lives in the tenant's
+// partition:
+//
+//	PK TENANT#<tenant id>  SK ORDER#<order id>#                 the order with its items
+//	PK TENANT#<tenant id>  SK ORDER#<order id>#ITEM#<position>  an item of an order saved before the items
+//	                                                            were kept in the order record
+//	PK TENANT#<tenant id>  SK COUNTER                           the last order number of the tenant
+//	PK TENANT#<tenant id>  SK IDEMPOTENCY#<key>                 an idempotency key
+//
+// The items are kept in the order record so an order and its items are written by a single put, with
+// no limit on the number of items but the size of the record. The item records of the orders saved
+// before are removed in the same transaction as the next write of their order.
+const (
+	dynamoOrderRecord       = "order"
+	dynamoItemRecord        = "item"
+	dynamoIdempotencyRecord = "idempotency"
+)
+
+func dynamoTenantKey(tenantId string) string { return "TENANT#" + tenantId }
+
+func dynamoOrderKey(orderId string) string { return "ORDER#" + orderId + "#" }
+
+// dynamoOrder is the record of an order
+type dynamoOrder struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"type"`
+	TenantId    string `dynamodbav:"tenant_id"`
+	ID          string `dynamodbav:"id"`
+	Discount    int64  `dynamodbav:"discount"`
+	AmountMinor int64  `dynamodbav:"amount_minor"`
+	// the amount of the orders stored before the amounts were in minor units
+	Amount          float64           `dynamodbav:"amount,omitempty"`
+	Currency        string            `dynamodbav:"currency"`
+	Status          string            `dynamodbav:"status"`
+	DispatchedAt    string            `dynamodbav:"dispatched_at"`
+	CreatedAt       string            `dynamodbav:"created_at"`
+	UpdatedAt       string            `dynamodbav:"updated_at"`
+	StatusChangedAt string            `dynamodbav:"status_changed_at"`
+	SlaBreached     bool              `dynamodbav:"sla_breached"`
+	CartId          string            `dynamodbav:"cart_id"`
+	Discounts       []dynamoDiscount  `dynamodbav:"discounts"`
+	Version         int64             `dynamodbav:"version"`
+	OrderNumber     int64             `dynamodbav:"order_number"`
+	Notes           string            `dynamodbav:"notes"`
+	Metadata        map[string]string `dynamodbav:"metadata"`
+	Priority        string            `dynamodbav:"priority"`
+	CallbackURL     string            `dynamodbav:"callback_url"`
+	FailureReason   string            `dynamodbav:"failure_reason"`
+	CustomerId      string            `dynamodbav:"customer_id"`
+	Refund          *dynamoRefund     `dynamodbav:"refund,omitempty"`
+	DeletedAt       string            `dynamodbav:"deleted_at"`
+	History         []StatusChange    `dynamodbav:"status_history,omitempty"`
+	StatusReason    *StatusReason     `dynamodbav:"status_reason,omitempty"`
+	// left out of the updates but UpdateOrderItems, an order always has items
+	Items []dynamoItem `dynamodbav:"items,omitempty"`
+}
+
+// dynamoOrderItem is the record of an item of an order saved before the items were kept in the order
+// record
+type dynamoOrderItem struct {
+	PK      string `dynamodbav:"PK"`
+	SK      string `dynamodbav:"SK"`
+	Type    string `dynamodbav:"type"`
+	OrderId string `dynamodbav:"order_id"`
+	dynamoItem
+}
+
+// dynamoItem is an item of an order
+type dynamoItem struct {
+	ProductId      string `dynamodbav:"product_id"`
+	Quantity       int64  `dynamodbav:"quantity"`
+	UnitPriceMinor int64  `dynamodbav:"unit_price_minor"`
+	Category       string `dynamodbav:"category"`
+	DiscountMinor  int64  `dynamodbav:"discount_minor"`
+	Name           string `dynamodbav:"name"`
+	// the prices of the items stored before the amounts were in minor units
+	UnitPrice float64 `dynamodbav:"unit_price,omitempty"`
+	Discount  float64 `dynamodbav:"discount,omitempty"`
+}
+
+// dynamoDiscount is an applied discount in the order record, under the names the discounts were stored
+// with before the amounts were in minor units
+type dynamoDiscount struct {
+	Type        string
+	Code        string
+	Percent     int64
+	AmountMinor int64
+	Amount      float64 `dynamodbav:",omitempty"`
+}
+
+// dynamoRefund is the refund in the order record, under the names the refunds were stored with before the
+// amounts were in minor units
+type dynamoRefund struct {
+	AmountMinor int64
+	Amount      float64 `dynamodbav:",omitempty"`
+	Currency    string
+	Reason      string
+	RefundedAt  Timestamp
+}
+
+func newDynamoOrder(o Order) dynamoOrder {
+	r := dynamoOrder{
+		PK:              dynamoTenantKey(o.TenantId),
+		SK:              dynamoOrderKey(o.ID),
+		Type:            dynamoOrderRecord,
+		TenantId:        o.TenantId,
+		ID:              o.ID,
+		Discount:        o.Discount,
+		AmountMinor:     o.AmountMinor,
+		Currency:        o.Currency,
+		Status:          string(o.Status),
+		DispatchedAt:    formatOptionalTimestamp(o.DispatchedAt),
+		CreatedAt:       formatTimestamp(o.CreatedAt),
+		UpdatedAt:       formatTimestamp(o.UpdatedAt),
+		StatusChangedAt: o.StatusChangedAt.Format(time.RFC3339Nano),
+		SlaBreached:     o.SlaBreached,
+		CartId:          o.CartId,
+		Version:         o.Version,
+		OrderNumber:     o.OrderNumber,
+		Notes:           o.Notes,
+		Metadata:        o.Metadata,
+		Priority:        string(o.Priority),
+		CallbackURL:     o.CallbackURL,
+		FailureReason:   o.FailureReason,
+		CustomerId:      o.CustomerId,
+		DeletedAt:       formatOptionalTimestamp(o.DeletedAt),
+		History:         o.History,
+		StatusReason:    o.StatusReason,
+	}
+	for _, d := range o.Discounts {
+		r.Discounts = append(r.Discounts, dynamoDiscount{Type: d.Type, Code: d.Code, Percent: d.Percent, AmountMinor: d.AmountMinor})
+	}
+	if o.Refund != nil {
+		r.Refund = &dynamoRefund{
+			AmountMinor: o.Refund.AmountMinor,
+			Currency:    o.Refund.Currency,
+			Reason:      o.Refund.Reason,
+			RefundedAt:  o.Refund.RefundedAt,
+		}
+	}
+	return r
+}
+
+func (r dynamoOrder) order() (Order, error) {
+	o := Order{
+		ID:            r.ID,
+		TenantId:      r.TenantId,
+		Discount:      r.Discount,
+		Currency:      r.Currency,
+		Status:        OrderStatus(r.Status),
+		SlaBreached:   r.SlaBreached,
+		CartId:        r.CartId,
+		Version:       r.Version,
+		OrderNumber:   r.OrderNumber,
+		Notes:         r.Notes,
+		Metadata:      r.Metadata,
+		Priority:      OrderPriority(r.Priority),
+		CallbackURL:   r.CallbackURL,
+		FailureReason: r.FailureReason,
+		CustomerId:    r.CustomerId,
+		History:       r.History,
+		StatusReason:  r.StatusReason,
+	}
+	// the records stored before the amounts were in minor units have them in units of the currency
+	currency := amountCurrency(o)
+	o.AmountMinor = storedMinorUnits(r.AmountMinor, r.Amount, currency)
+	for _, d := range r.Discounts {
+		o.Discounts = append(o.Discounts, AppliedDiscount{
+			Type:        d.Type,
+			Code:        d.Code,
+			Percent:     d.Percent,
+			AmountMinor: storedMinorUnits(d.AmountMinor, d.Amount, currency),
+		})
+	}
+	if r.Refund != nil {
+		o.Refund = &Refund{
+			Currency:   r.Refund.Currency,
+			Reason:     r.Refund.Reason,
+			RefundedAt: r.Refund.RefundedAt,
+		}
+		o.Refund.AmountMinor = storedMinorUnits(r.Refund.AmountMinor, r.Refund.Amount, refundCurrency(o.Refund))
+	}
+	var err error
+	if o.StatusChangedAt, err = time.Parse(time.RFC3339Nano, r.StatusChangedAt); err != nil {
+		return o, fmt.Errorf("invalid status changed at of order: %v, err: %w", o.ID, err)
+	}
+	if o.DispatchedAt, err = parseStoredTimestamp(r.DispatchedAt); err != nil {
+		return o, fmt.Errorf("invalid dispatched at of order: %v, err: %w", o.ID, err)
+	}
+	if o.CreatedAt, err = parseStoredTimestamp(r.CreatedAt); err != nil {
+		return o, fmt.Errorf("invalid created at of order: %v, err: %w", o.ID, err)
+	}
+	if o.UpdatedAt, err = parseStoredTimestamp(r.UpdatedAt); err != nil {
+		return o, fmt.Errorf("invalid updated at of order: %v, err: %w", o.ID, err)
+	}
+	if o.DeletedAt, err = parseStoredTimestamp(r.DeletedAt); err != nil {
+		return o, fmt.Errorf("invalid deleted at of order: %v, err: %w", o.ID, err)
+	}
+	return o, nil
+}
+
+// newDynamoItems returns the items to keep in the order record
+func newDynamoItems(items []OrderItem) []dynamoItem {
+	records := make([]dynamoItem, 0, len(items))
+	for _, item := range items {
+		records = append(records, dynamoItem{
+			ProductId:      item.ProductId,
+			Quantity:       item.ProductQuantity,
+			UnitPriceMinor: item.UnitPriceMinor,
+			Category:       item.Category,
+			DiscountMinor:  item.DiscountMinor,
+			Name:           item.Name,
+		})
+	}
+	return records
+}
+
+// item returns the item of the order, the prices stored before the amounts were in minor units are in
+// units of the currency of the order
+func (r dynamoItem) item(orderId, currency string) OrderItem {
+	return OrderItem{
+		ProductId:       r.ProductId,
+		ProductQuantity: r.Quantity,
+		OrderId:         orderId,
+		UnitPriceMinor:  storedMinorUnits(r.UnitPriceMinor, r.UnitPrice, currency),
+		Category:        r.Category,
+		DiscountMinor:   storedMinorUnits(r.DiscountMinor, r.Discount, currency),
+		Name:            r.Name,
+	}
+}
+
+// MarshalDynamoDBAttributeValue stores the timestamps of the status history and the refund as strings,
+// like the other timestamps of the order record
+func (t Timestamp) MarshalDynamoDBAttributeValue() (types.AttributeValue, error) {
+	return &types.AttributeValueMemberS{Value: formatOptionalTimestamp(t.Time)}, nil
+}
+
+func (t *Timestamp) UnmarshalDynamoDBAttributeValue(av types.AttributeValue) error {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberNULL:
+		t.Time = time.Time{}
+		return nil
+	case *types.AttributeValueMemberS:
+		parsed, err := parseStoredTimestamp(v.Value)
+		if err != nil {
+			return err
+		}
+		t.Time = parsed
+		return nil
+	default:
+		return fmt.Errorf("timestamp must be a string, got a %T", av)
+	}
+}
+
+// openDynamoDB creates the client from the default AWS configuration (AWS_REGION, the credentials chain)
+// and creates the table if it doesn't exist yet. The endpoint can be pointed at DynamoDB Local.
+func openDynamoDB(table, endpoint string) (*dynamodb.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the aws config, err: %w", err)
+	}
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	_, err = client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		err = createDynamoTable(ctx, client, table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error preparing the dynamodb table: %v, err: %w", table, err)
+	}
+	return client, nil
+}
+
+func createDynamoTable(ctx context.Context, client *dynamodb.Client, table string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("PK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("SK"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("created the dynamodb table", "table", table)
+	return dynamodb.NewTableExistsWaiter(client).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, time.Minute)
+}
+
+// dynamoTenants returns the tenants with orders in the table, it scans the whole table so it's only run
+// on startup
+func dynamoTenants(client *dynamodb.Client, table string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	seen := make(map[string]bool)
+	var tenantIds []string
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:                 aws.String(table),
+		FilterExpression:          aws.String("#type = :order"),
+		ProjectionExpression:      aws.String("tenant_id"),
+		ExpressionAttributeNames:  map[string]string{"#type": "type"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":order": &types.AttributeValueMemberS{Value: dynamoOrderRecord}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			var record struct {
+				TenantId string `dynamodbav:"tenant_id"`
+			}
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				return nil, err
+			}
+			if !seen[record.TenantId] {
+				seen[record.TenantId] = true
+				tenantIds = append(tenantIds, record.TenantId)
+			}
+		}
+	}
+	return tenantIds, nil
+}
+
+// DynamoStore keeps the orders of a single tenant in DynamoDB, every tenant shares the table and has its
+// own partition
+type DynamoStore struct {
+	client   *dynamodb.Client
+	table    string
+	tenantId string
+}
+
+func NewDynamoStore(client *dynamodb.Client, table, tenantId string) *DynamoStore {
+	return &DynamoStore{client: client, table: table, tenantId: tenantId}
+}
+
+// WithTx isn't supported, DynamoDB only applies a set of writes known upfront atomically
+// (TransactWriteItems), it can't hold a transaction open across reads and writes
+func (s *DynamoStore) WithTx(fn func(tx OrderRepository) error) error {
+	return errTransactionsNotSupported
+}
+
+// key returns the primary key of the record of the tenant
+func (s *DynamoStore) key(sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: dynamoTenantKey(s.tenantId)},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+// queryOrders returns the orders of the tenant with their items, of a single order if orderId is set.
+// The filter further narrows the order records.
+func (s *DynamoStore) queryOrders(ctx context.Context, orderId string, filter string, values map[string]types.AttributeValue) (map[string]Order, map[string][]OrderItem, error) {
+	prefix := "ORDER#"
+	if orderId != "" {
+		prefix = dynamoOrderKey(orderId)
+	}
+	if values == nil {
+		values = make(map[string]types.AttributeValue)
+	}
+	values[":pk"] = &types.AttributeValueMemberS{Value: dynamoTenantKey(s.tenantId)}
+	values[":prefix"] = &types.AttributeValueMemberS{Value: prefix}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: values,
+		ConsistentRead:            aws.Bool(true),
+	}
+	if filter != "" {
+		input.FilterExpression = aws.String(filter)
+		// DynamoDB rejects the names the expression doesn't use
+		input.ExpressionAttributeNames = make(map[string]string)
+		for _, name := range []string{"type", "status"} {
+			if strings.Contains(filter, "#"+name) {
+				input.ExpressionAttributeNames["#"+name] = name
+			}
+		}
+	}
+
+	orders := make(map[string]Order)
+	items := make(map[string][]OrderItem)
+	// orders whose items are kept in their record, their item records if any are stale
+	embedded := make(map[string]bool)
+	paginator := dynamodb.NewQueryPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, record := range page.Items {
+			var recordType string
+			if err := attributevalue.Unmarshal(record["type"], &recordType); err != nil {
+				return nil, nil, err
+			}
+			switch recordType {
+			case dynamoOrderRecord:
+				var r dynamoOrder
+				if err := attributevalue.UnmarshalMap(record, &r); err != nil {
+					return nil, nil, err
+				}
+				o, err := r.order()
+				if err != nil {
+					return nil, nil, err
+				}
+				orders[o.ID] = o
+				if len(r.Items) > 0 {
+					embedded[o.ID] = true
+					for _, item := range r.Items {
+						items[o.ID] = append(items[o.ID], item.item(o.ID, amountCurrency(o)))
+					}
+				}
+			case dynamoItemRecord:
+				var r dynamoOrderItem
+				if err := attributevalue.UnmarshalMap(record, &r); err != nil {
+					return nil, nil, err
+				}
+				// the items come sorted by their position, after the record of their order
+				if !embedded[r.OrderId] {
+					items[r.OrderId] = append(items[r.OrderId], r.item(r.OrderId, amountCurrency(orders[r.OrderId])))
+				}
+			}
+		}
+	}
+	return orders, items, nil
+}
+
+// nextOrderNumber increments the order counter of the tenant, the update is atomic so the instances
+// sharing the table never hand out the same number
+func (s *DynamoStore) nextOrderNumber(ctx context.Context) (int64, error) {
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       s.key("COUNTER"),
+		UpdateExpression:          aws.String("ADD last_order_number :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": &types.AttributeValueMemberN{Value: "1"}},
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var counter struct {
+		LastOrderNumber int64 `dynamodbav:"last_order_number"`
+	}
+	err = attributevalue.UnmarshalMap(out.Attributes, &counter)
+	return counter.LastOrderNumber, err
+}
+
+// SaveOrder writes the order with its items in a single put, in a transaction with the removal of the
+// item records of an order saved before the items were kept in the order record. A number taken by an
+// order that then fails to save is not reused.
+func (s *DynamoStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	if o.Status != OrderPending && o.OrderNumber == 0 {
+		orderNumber, err := s.nextOrderNumber(ctx)
+		if err != nil {
+			return o, err
+		}
+		o.OrderNumber = orderNumber
+	}
+
+	r := newDynamoOrder(o)
+	r.Items = newDynamoItems(items)
+	record, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return o, err
+	}
+	itemDeletes, err := s.itemRecordDeletes(ctx, o.ID)
+	if err != nil {
+		return o, err
+	}
+	writes := append([]types.TransactWriteItem{{Put: &types.Put{TableName: aws.String(s.table), Item: record}}}, itemDeletes...)
+	return o, s.transactWrite(ctx, writes)
+}
+
+// dynamoMaxTransactWrites is the most writes DynamoDB takes in a transaction
+const dynamoMaxTransactWrites = 100
+
+// transactWrite applies the writes in a single transaction, a single write goes on its own
+func (s *DynamoStore) transactWrite(ctx context.Context, writes []types.TransactWriteItem) error {
+	if len(writes) > dynamoMaxTransactWrites {
+		return fmt.Errorf("%v writes exceed the %v writes of a transaction", len(writes), dynamoMaxTransactWrites)
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	return err
+}
+
+// itemRecordDeletes returns the deletes of the item records of an order saved before the items were kept
+// in the order record. The orders were saved in a single transaction with their items back then, so
+// there are less than dynamoMaxTransactWrites of them.
+func (s *DynamoStore) itemRecordDeletes(ctx context.Context, orderId string) ([]types.TransactWriteItem, error) {
+	var deletes []types.TransactWriteItem
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: dynamoTenantKey(s.tenantId)},
+			":prefix": &types.AttributeValueMemberS{Value: dynamoOrderKey(orderId) + "ITEM#"},
+		},
+		ProjectionExpression: aws.String("PK, SK"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range page.Items {
+			deletes = append(deletes, types.TransactWriteItem{Delete: &types.Delete{
+				TableName: aws.String(s.table),
+				Key:       map[string]types.AttributeValue{"PK": record["PK"], "SK": record["SK"]},
+			}})
+		}
+	}
+	return deletes, nil
+}
+
+func (s *DynamoStore) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	orders, items, err := s.queryOrders(ctx, orderId, "", nil)
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+	o, ok := orders[orderId]
+	if !ok {
+		return Order{}, nil, false, nil
+	}
+	return o, items[orderId], true, nil
+}
+
+func (s *DynamoStore) ListOrders() ([]Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	orders, _, err := s.queryOrders(ctx, "", "#type = :order", map[string]types.AttributeValue{
+		":order": &types.AttributeValueMemberS{Value: dynamoOrderRecord},
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Order, 0, len(orders))
+	for _, o := range orders {
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+// UpdateOrder leaves the items of the order untouched, UpdateOrderItems changes them
+func (s *DynamoStore) UpdateOrder(o Order, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	record, err := attributevalue.MarshalMap(newDynamoOrder(o))
+	if err != nil {
+		return err
+	}
+	update, names, values := dynamoOrderUpdate(record)
+	values[":version"] = dynamoNumber(version)
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       s.key(dynamoOrderKey(o.ID)),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("version = :version"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errVersionConflict
+	}
+	return err
+}
+
+// dynamoOptionalAttributes are the attributes of the order record left out when they are empty, an update
+// without them removes the stored ones like a put of the record would
+var dynamoOptionalAttributes = []string{"amount", "refund", "status_history", "status_reason"}
+
+// dynamoOrderUpdate returns the update expression writing the attributes of the order record but its key
+// and its items, with the names and the values it uses
+func dynamoOrderUpdate(record map[string]types.AttributeValue) (string, map[string]string, map[string]types.AttributeValue) {
+	names := make(map[string]string)
+	values := make(map[string]types.AttributeValue)
+	var set, remove []string
+	for attribute, value := range record {
+		if attribute == "PK" || attribute == "SK" || attribute == "items" {
+			continue
+		}
+		n := strconv.Itoa(len(names))
+		names["#a"+n] = attribute
+		values[":a"+n] = value
+		set = append(set, "#a"+n+" = :a"+n)
+	}
+	for _, attribute := range dynamoOptionalAttributes {
+		if _, ok := record[attribute]; !ok {
+			n := strconv.Itoa(len(names))
+			names["#a"+n] = attribute
+			remove = append(remove, "#a"+n)
+		}
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+	return update, names, values
+}
+
+// UpdateOrderItems writes the order with its items in a single put carrying the version check, in a
+// transaction with the removal of the item records of an order saved before the items were kept in the
+// order record
+func (s *DynamoStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	r := newDynamoOrder(o)
+	r.Items = newDynamoItems(items)
+	record, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	itemDeletes, err := s.itemRecordDeletes(ctx, o.ID)
+	if err != nil {
+		return err
+	}
+	writes := append([]types.TransactWriteItem{{Put: &types.Put{
+		TableName:                 aws.String(s.table),
+		Item:                      record,
+		ConditionExpression:       aws.String("version = :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":version": dynamoNumber(version)},
+	}}}, itemDeletes...)
+	return dynamoVersionConflict(s.transactWrite(ctx, writes))
+}
+
+// dynamoVersionConflict returns errVersionConflict for a transaction canceled by the version check of its
+// first write, the order write
+func dynamoVersionConflict(err error) error {
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return errVersionConflict
+	}
+	return err
+}
+
+// FindByCartId skips the failed orders, their carts are free to be ordered again
+func (s *DynamoStore) FindByCartId(cartId string) (string, bool, error) {
+	return s.findId("#type = :order AND cart_id = :cart_id AND #status <> :failed", map[string]types.AttributeValue{
+		":order":   &types.AttributeValueMemberS{Value: dynamoOrderRecord},
+		":cart_id": &types.AttributeValueMemberS{Value: cartId},
+		":failed":  &types.AttributeValueMemberS{Value: string(OrderFailed)},
+	})
+}
+
+func (s *DynamoStore) FindByNumber(orderNumber int64) (string, bool, error) {
+	return s.findId("#type = :order AND order_number = :order_number", map[string]types.AttributeValue{
+		":order":        &types.AttributeValueMemberS{Value: dynamoOrderRecord},
+		":order_number": dynamoNumber(orderNumber),
+	})
+}
+
+// findId returns the id of an order of the tenant matching the filter
+func (s *DynamoStore) findId(filter string, values map[string]types.AttributeValue) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	orders, _, err := s.queryOrders(ctx, "", filter, values)
+	if err != nil {
+		return "", false, err
+	}
+	for orderId := range orders {
+		return orderId, true, nil
+	}
+	return "", false, nil
+}
+
+func (s *DynamoStore) MarkFailed(orderId, reason string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	change, err := attributevalue.Marshal([]StatusChange{failedStatusChange(now)})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key:       s.key(dynamoOrderKey(orderId)),
+		UpdateExpression: aws.String("SET #status = :failed, failure_reason = :reason, status_changed_at = :now, updated_at = :updated_at, " +
+			"status_history = list_append(if_not_exists(status_history, :empty), :change) ADD version :one"),
+		ConditionExpression:      aws.String("attribute_exists(PK)"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":failed":     &types.AttributeValueMemberS{Value: string(OrderFailed)},
+			":reason":     &types.AttributeValueMemberS{Value: reason},
+			":now":        &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":updated_at": &types.AttributeValueMemberS{Value: formatTimestamp(now)},
+			":one":        dynamoNumber(1),
+			":change":     change,
+			":empty":      &types.AttributeValueMemberL{},
+		},
+	})
+	// like the other stores, a missing order is nothing to mark
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// DeleteOrder removes the order if it's still at the version, with the item records of an order saved
+// before the items were kept in the order record and its idempotency key, in a single transaction
+func (s *DynamoStore) DeleteOrder(orderId string, version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	writes := []types.TransactWriteItem{{Delete: &types.Delete{
+		TableName:                 aws.String(s.table),
+		Key:                       s.key(dynamoOrderKey(orderId)),
+		ConditionExpression:       aws.String("version = :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":version": dynamoNumber(version)},
+	}}}
+	itemDeletes, err := s.itemRecordDeletes(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	writes = append(writes, itemDeletes...)
+	idempotencyKey, err := s.orderIdempotencyKey(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	if idempotencyKey != "" {
+		writes = append(writes, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: aws.String(s.table),
+			Key:       s.key("IDEMPOTENCY#" + idempotencyKey),
+		}})
+	}
+	return dynamoVersionConflict(s.transactWrite(ctx, writes))
+}
+
+// orderIdempotencyKey returns the idempotency key bound to the order, empty if there is none
+func (s *DynamoStore) orderIdempotencyKey(ctx context.Context, orderId string) (string, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		FilterExpression:       aws.String("order_id = :order_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":       &types.AttributeValueMemberS{Value: dynamoTenantKey(s.tenantId)},
+			":prefix":   &types.AttributeValueMemberS{Value: "IDEMPOTENCY#"},
+			":order_id": &types.AttributeValueMemberS{Value: orderId},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range page.Items {
+			var record struct {
+				Key string `dynamodbav:"key"`
+			}
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				return "", err
+			}
+			return record.Key, nil
+		}
+	}
+	return "", nil
+}
+
+// ClaimIdempotencyKey puts the key only if it doesn't exist, a key claimed concurrently fails the condition
+func (s *DynamoStore) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	item := s.key("IDEMPOTENCY#" + key)
+	item["type"] = &types.AttributeValueMemberS{Value: dynamoIdempotencyRecord}
+	item["key"] = &types.AttributeValueMemberS{Value: key}
+	item["request_hash"] = &types.AttributeValueMemberS{Value: record.RequestHash}
+	item["order_id"] = &types.AttributeValueMemberS{Value: record.OrderId}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if err == nil {
+		return record, true, nil
+	}
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		return IdempotencyRecord{}, false, err
+	}
+
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            s.key("IDEMPOTENCY#" + key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	var existing struct {
+		RequestHash string `dynamodbav:"request_hash"`
+		OrderId     string `dynamodbav:"order_id"`
+	}
+	err = attributevalue.UnmarshalMap(out.Item, &existing)
+	return IdempotencyRecord{RequestHash: existing.RequestHash, OrderId: existing.OrderId}, false, err
+}
+
+func (s *DynamoStore) ReleaseIdempotencyKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+	defer cancel()
+
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(s.table), Key: s.key("IDEMPOTENCY#" + key)})
+	return err
+}
+
+// RefreshSLAFlags only writes the orders whose flag changed
+func (s *DynamoStore) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	orders, err := s.ListOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	breaches := make(map[OrderStatus]int)
+	for _, o := range orders {
+		isBreached := slaBreached(o, now)
+		if o.SlaBreached != isBreached {
+			ctx, cancel := context.WithTimeout(context.Background(), dynamoTimeout)
+			_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                aws.String(s.table),
+				Key:                      s.key(dynamoOrderKey(o.ID)),
+				UpdateExpression:         aws.String("SET sla_breached = :breached"),
+				ConditionExpression:      aws.String("#status = :status"),
+				ExpressionAttributeNames: map[string]string{"#status": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":breached": &types.AttributeValueMemberBOOL{Value: isBreached},
+					":status":   &types.AttributeValueMemberS{Value: string(o.Status)},
+				},
+			})
+			cancel()
+			// the order moved on since it was listed, its flag is refreshed on the next run
+			var conditionFailed *types.ConditionalCheckFailedException
+			if err != nil && !errors.As(err, &conditionFailed) {
+				return nil, err
+			}
+		}
+		if isBreached {
+			breaches[o.Status]++
+		}
+	}
+	return breaches, nil
+}
+
+func dynamoNumber(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDynamoOrderRecordKeepsItems(t *testing.T) {
+	items := make([]OrderItem, 150)
+	for i := range items {
+		items[i] = OrderItem{ProductId: "p1", ProductQuantity: 1, OrderId: "o1", UnitPriceMinor: 1999, Category: "books", DiscountMinor: 1, Name: "Book"}
+	}
+	r := newDynamoOrder(Order{ID: "o1", TenantId: "t1", Currency: "USD", Status: OrderPlaced})
+	r.Items = newDynamoItems(items)
+	record, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		t.Fatalf("marshaling the order record failed: %v", err)
+	}
+
+	var stored dynamoOrder
+	if err := attributevalue.UnmarshalMap(record, &stored); err != nil {
+		t.Fatalf("unmarshaling the order record failed: %v", err)
+	}
+	if len(stored.Items) != len(items) {
+		t.Fatalf("the record has %v items, want %v", len(stored.Items), len(items))
+	}
+	if got := stored.Items[149].item("o1", "USD"); got != items[149] {
+		t.Errorf("item = %+v, want %+v", got, items[149])
+	}
+}
+
+func TestDynamoLegacyItemRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]types.AttributeValue
+		want   OrderItem
+	}{
+		{"minor units", map[string]types.AttributeValue{
+			"order_id":         &types.AttributeValueMemberS{Value: "o1"},
+			"product_id":       &types.AttributeValueMemberS{Value: "p1"},
+			"quantity":         &types.AttributeValueMemberN{Value: "2"},
+			"unit_price_minor": &types.AttributeValueMemberN{Value: "1999"},
+			"discount_minor":   &types.AttributeValueMemberN{Value: "100"},
+		}, OrderItem{ProductId: "p1", ProductQuantity: 2, OrderId: "o1", UnitPriceMinor: 1999, DiscountMinor: 100}},
+		{"units of the currency", map[string]types.AttributeValue{
+			"order_id":   &types.AttributeValueMemberS{Value: "o1"},
+			"product_id": &types.AttributeValueMemberS{Value: "p1"},
+			"quantity":   &types.AttributeValueMemberN{Value: "2"},
+			"unit_price": &types.AttributeValueMemberN{Value: "19.99"},
+			"discount":   &types.AttributeValueMemberN{Value: "1"},
+		}, OrderItem{ProductId: "p1", ProductQuantity: 2, OrderId: "o1", UnitPriceMinor: 1999, DiscountMinor: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r dynamoOrderItem
+			if err := attributevalue.UnmarshalMap(tt.record, &r); err != nil {
+				t.Fatalf("unmarshaling the item record failed: %v", err)
+			}
+			if got := r.item(r.OrderId, "USD"); got != tt.want {
+				t.Errorf("item = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynamoOrderUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   Order
+		removed []string
+	}{
+		{"without the optional attributes", Order{ID: "o1"}, []string{"amount", "refund", "status_history", "status_reason"}},
+		{"with a refund", Order{ID: "o1", Refund: &Refund{AmountMinor: 100}}, []string{"amount", "status_history", "status_reason"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newDynamoOrder(tt.order)
+			r.Items = newDynamoItems([]OrderItem{{ProductId: "p1", ProductQuantity: 1}})
+			record, err := attributevalue.MarshalMap(r)
+			if err != nil {
+				t.Fatalf("marshaling the order record failed: %v", err)
+			}
+			update, names, values := dynamoOrderUpdate(record)
+
+			set := make(map[string]bool)
+			for name, attribute := range names {
+				if _, ok := values[":"+strings.TrimPrefix(name, "#")]; ok {
+					set[attribute] = true
+				}
+			}
+			for _, attribute := range []string{"PK", "SK", "items"} {
+				if set[attribute] {
+					t.Errorf("the update %q sets %v", update, attribute)
+				}
+			}
+			if !set["version"] || !set["status"] {
+				t.Errorf("the update %q doesn't set the attributes of the order", update)
+			}
+			var removed []string
+			if i := strings.Index(update, " REMOVE "); i >= 0 {
+				for _, name := range strings.Split(update[i+len(" REMOVE "):], ", ") {
+					removed = append(removed, names[name])
+				}
+			}
+			if strings.Join(removed, ",") != strings.Join(tt.removed, ",") {
+				t.Errorf("the update removes %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
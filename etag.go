@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requireIfMatch makes the status updates and the amendments of an order answer 428 when they don't
+// carry an If-Match header. Set by REQUIRE_IF_MATCH, defaults to true.
+var requireIfMatch = true
+
+// orderETag is the entity tag of the order, its version
+func orderETag(o Order) string {
+	return strconv.Quote(strconv.FormatInt(o.Version, 10))
+}
+
+// setOrderETag sets the ETag of a response carrying the order, clients send it back in If-Match to
+// change the order only if nobody else did since they read it
+func setOrderETag(w http.ResponseWriter, o Order) {
+	w.Header().Set("ETag", orderETag(o))
+}
+
+// ifMatchFailure checks the If-Match header of a request changing the order against the version it was
+// read at. It returns 0 when the change can go on, the status and the message to answer with otherwise.
+// Only strong tags match, a weak tag can't guarantee the order didn't change.
+func ifMatchFailure(r *http.Request, o Order) (int, string) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if !requireIfMatch {
+			return 0, ""
+		}
+		logger.InfoContext(r.Context(), "order change without an If-Match header", "order_id", o.ID)
+		return http.StatusPreconditionRequired, "the If-Match header with the ETag of the order is required"
+	}
+	if header == "*" {
+		return 0, ""
+	}
+	etag := orderETag(o)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return 0, ""
+		}
+	}
+	logger.InfoContext(r.Context(), "order was modified since it was read", "order_id", o.ID, "etag", etag, "if_match", header)
+	return http.StatusPreconditionFailed, fmt.Sprintf("order with id: %v was modified since it was read, its current ETag is %v", o.ID, etag)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pborman/uuid"
+)
+
+// orderCreatedSchemaVersion is bumped on every breaking change to the order created event
+const orderCreatedSchemaVersion = 1
+
+// EventPublisher publishes the order domain events for downstream consumers
+type EventPublisher interface {
+	Publish(ctx context.Context, eventType string, payload []byte) error
+}
+
+// logEventPublisher writes the events to the service log, used until a message broker is wired in
+type logEventPublisher struct{}
+
+func (logEventPublisher) Publish(ctx context.Context, eventType string, payload []byte) error {
+	logger.InfoContext(ctx, "event published", "type", eventType, "payload", string(payload))
+	return nil
+}
+
+// discardEventPublisher drops the events, for deployments nobody consumes them in
+type discardEventPublisher struct{}
+
+func (discardEventPublisher) Publish(ctx context.Context, eventType string, payload []byte) error {
+	return nil
+}
+
+// eventPublisher is used to publish the order events, set by ORDER_EVENTS_PUBLISHER
+var eventPublisher EventPublisher = logEventPublisher{}
+
+// loadEventPublisherConfig reads ORDER_EVENTS_PUBLISHER, log to write the events to the service log and
+// none to drop them
+func loadEventPublisherConfig() error {
+	publisher := getEnv("ORDER_EVENTS_PUBLISHER", "log")
+	switch publisher {
+	case "log":
+		eventPublisher = logEventPublisher{}
+	case "none":
+		eventPublisher = discardEventPublisher{}
+	default:
+		return fmt.Errorf("unsupported order events publisher: %v", publisher)
+	}
+	logger.Info("order events publisher loaded", "publisher", publisher)
+	return nil
+}
+
+type OrderCreatedEventItem struct {
+	ProductId string  `json:"product_id"`
+	Quantity  int64   `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	LineTotal float64 `json:"line_total"`
+	Discount  float64 `json:"discount"`
+}
+
+// OrderCreatedEvent carries the full price breakdown of a placed order, so consumers don't have to
+// query the order back
+type OrderCreatedEvent struct {
+	SchemaVersion int                     `json:"schema_version"`
+	EventId       string                  `json:"event_id"`
+	OccurredAt    Timestamp               `json:"occurred_at"`
+	OrderId       string                  `json:"order_id"`
+	TenantId      string                  `json:"tenant_id"`
+	CustomerId    string                  `json:"customer_id,omitempty"`
+	Items         []OrderCreatedEventItem `json:"items"`
+	Subtotal      float64                 `json:"subtotal"`
+	Discounts     []DiscountResponse      `json:"discounts"`
+	Discount      float64                 `json:"discount"`
+	Tax           float64                 `json:"tax"`
+	Shipping      float64                 `json:"shipping"`
+	GrandTotal    float64                 `json:"grand_total"`
+	Currency      string                  `json:"currency"`
+}
+
+func newOrderCreatedEvent(o Order, oItems []OrderItem) OrderCreatedEvent {
+	event := OrderCreatedEvent{
+		SchemaVersion: orderCreatedSchemaVersion,
+		EventId:       uuid.New(),
+		OccurredAt:    Timestamp{clock.Now()},
+		OrderId:       o.ID,
+		TenantId:      o.TenantId,
+		CustomerId:    o.CustomerId,
+		Items:         []OrderCreatedEventItem{},
+		Discounts:     newDiscountResponses(o.Discounts, o.Currency),
+		GrandTotal:    fromMinorUnits(o.AmountMinor, o.Currency),
+		Currency:      o.Currency,
+	}
+	// the totals are added up in the minor units of the currency
+	var subtotal, discount int64
+	for _, item := range oItems {
+		lineTotal := lineTotalMinorUnits(item)
+		event.Items = append(event.Items, OrderCreatedEventItem{
+			ProductId: item.ProductId,
+			Quantity:  item.ProductQuantity,
+			UnitPrice: fromMinorUnits(item.UnitPriceMinor, o.Currency),
+			LineTotal: fromMinorUnits(lineTotal, o.Currency),
+			Discount:  fromMinorUnits(item.DiscountMinor, o.Currency),
+		})
+		subtotal += lineTotal
+	}
+	for _, d := range o.Discounts {
+		discount += d.AmountMinor
+	}
+	event.Subtotal = fromMinorUnits(subtotal, o.Currency)
+	event.Discount = fromMinorUnits(discount, o.Currency)
+	return event
+}
+
+// publishOrderCreated publishes the order created event, a failure is logged and doesn't fail the placement
+func publishOrderCreated(ctx context.Context, o Order, oItems []OrderItem) {
+	payload, err := json.Marshal(newOrderCreatedEvent(o, oItems))
+	if err != nil {
+		logger.ErrorContext(ctx, "error marshaling the order created event", "order_id", o.ID, "err", err)
+		return
+	}
+	if err := eventPublisher.Publish(ctx, "order.created", payload); err != nil {
+		logger.ErrorContext(ctx, "error publishing the order created event", "order_id", o.ID, "err", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingEventPublisher keeps the published events for the tests
+type recordingEventPublisher struct {
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+type recordedEvent struct {
+	eventType string
+	payload   []byte
+}
+
+func (p *recordingEventPublisher) Publish(ctx context.Context, eventType string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, recordedEvent{eventType, payload})
+	return nil
+}
+
+func useRecordingEventPublisher(t *testing.T) *recordingEventPublisher {
+	t.Helper()
+	p := &recordingEventPublisher{}
+	prev := eventPublisher
+	eventPublisher = p
+	t.Cleanup(func() { eventPublisher = prev })
+	return p
+}
+
+func TestOrderEvents(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	events := useRecordingEventPublisher(t)
+
+	rec := httptest.NewRecorder()
+	PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+		`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":2}]}`, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+	}
+	var o CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+		t.Fatalf("decoding the order failed: %v", err)
+	}
+
+	req := newTenantRequest(http.MethodPut, "/orders/"+o.ID, `{"status":"confirmed"}`, map[string]string{"order_id": o.ID})
+	req.Header.Set("If-Match", orderETag(Order{Version: o.Version}))
+	rec = httptest.NewRecorder()
+	UpdateOrderStatusHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirming the order answered %v: %s", rec.Code, rec.Body)
+	}
+
+	if len(events.events) != 2 || events.events[0].eventType != "order.created" || events.events[1].eventType != "order.status_changed" {
+		t.Fatalf("published %v events, want the order created and the status changed ones", len(events.events))
+	}
+
+	// the created event carries the breakdown of the placed order
+	var created OrderCreatedEvent
+	if err := json.Unmarshal(events.events[0].payload, &created); err != nil {
+		t.Fatalf("decoding the order created event failed: %v", err)
+	}
+	if created.SchemaVersion != orderCreatedSchemaVersion || created.OrderId != o.ID || created.TenantId != "t1" ||
+		created.CustomerId != "3f2504e0-4f89-11d3-9a0c-0305e82c3301" || created.Currency != "USD" {
+		t.Errorf("order created event = %+v", created)
+	}
+	// 250 + 120 + 2*80 less the 10% premium discount
+	if created.Subtotal != 530 || created.Discount != 53 || created.GrandTotal != o.Amount || o.Amount != 477 {
+		t.Errorf("totals = %v - %v = %v, want 530 - 53 = %v", created.Subtotal, created.Discount, created.GrandTotal, o.Amount)
+	}
+	if len(created.Discounts) != 1 || created.Discounts[0].Type != DiscountPremium || created.Discounts[0].Amount != 53 {
+		t.Errorf("discounts = %+v, want the premium discount of 53", created.Discounts)
+	}
+	var itemDiscounts float64
+	for i, item := range created.Items {
+		if item.ProductId != o.Items[i].ID || item.Quantity != o.Items[i].Quantity || item.LineTotal != item.UnitPrice*float64(item.Quantity) {
+			t.Errorf("item %v = %+v, want the ordered %+v", i, item, o.Items[i])
+		}
+		itemDiscounts += item.Discount
+	}
+	if len(created.Items) != 3 || itemDiscounts != created.Discount {
+		t.Errorf("items = %+v, want the 3 ordered products sharing the discount", created.Items)
+	}
+
+	var changed OrderStatusChangedEvent
+	if err := json.Unmarshal(events.events[1].payload, &changed); err != nil {
+		t.Fatalf("decoding the status changed event failed: %v", err)
+	}
+	if changed.OrderId != o.ID || changed.TenantId != "t1" || changed.OldStatus != OrderPlaced || changed.NewStatus != OrderConfirmed {
+		t.Errorf("status changed event = %+v", changed)
+	}
+}
+
+func TestLoadEventPublisherConfig(t *testing.T) {
+	tests := []struct {
+		publisher string
+		want      EventPublisher
+		wantErr   bool
+	}{
+		{"log", logEventPublisher{}, false},
+		{"none", discardEventPublisher{}, false},
+		{"kafka", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.publisher, func(t *testing.T) {
+			prev := eventPublisher
+			t.Cleanup(func() { eventPublisher = prev })
+			t.Setenv("ORDER_EVENTS_PUBLISHER", tt.publisher)
+
+			err := loadEventPublisherConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && eventPublisher != tt.want {
+				t.Errorf("publisher = %T, want %T", eventPublisher, tt.want)
+			}
+		})
+	}
+}
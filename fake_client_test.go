@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeProductClient is an in-memory ProductClient for the tests, so they run without the product
+// service. Failures can be programmed per product id.
+type fakeProductClient struct {
+	mu       sync.Mutex
+	products map[string]*ProductDetails
+	// errors returned by GetProductDetails/ListProductDetails for a product id
+	getErrors map[string]error
+	// errors returned by UpdateProductQuantity for a product id
+	updateErrors map[string]error
+}
+
+func newFakeProductClient(products ...*ProductDetails) *fakeProductClient {
+	f := &fakeProductClient{
+		products:     make(map[string]*ProductDetails),
+		getErrors:    make(map[string]error),
+		updateErrors: make(map[string]error),
+	}
+	for _, p := range products {
+		f.products[p.ID] = p
+	}
+	return f
+}
+
+// fakeSampleProducts are the products the tests seed the fake client with
+func fakeSampleProducts() []*ProductDetails {
+	return []*ProductDetails{
+		{ID: "p1", Name: "Watch", Description: "Analog wrist watch", Category: "premium", Price: 250, Quantity: 100},
+		{ID: "p2", Name: "Sunglasses", Description: "Polarized sunglasses", Category: "premium", Price: 120, Quantity: 100},
+		{ID: "p3", Name: "Wallet", Description: "Leather wallet", Category: "premium", Price: 80, Quantity: 100},
+		{ID: "p4", Name: "Notebook", Description: "A5 ruled notebook", Category: "regular", Price: 5, Quantity: 100},
+		{ID: "p5", Name: "Pen", Description: "Ballpoint pen", Category: "budget", Price: 1, Quantity: 100},
+		{ID: "p6", Name: "Gift wrap", Description: "Complimentary gift wrap", Category: "budget", Price: 0, Quantity: 100},
+	}
+}
+
+// SetProduct adds or replaces a product
+func (f *fakeProductClient) SetProduct(p *ProductDetails) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.products[p.ID] = p
+}
+
+// FailGet makes the lookups of the product fail with err, a nil err clears the failure
+func (f *fakeProductClient) FailGet(productId string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.getErrors, productId)
+		return
+	}
+	f.getErrors[productId] = err
+}
+
+// FailUpdate makes the quantity updates of the product fail with err, a nil err clears the failure
+func (f *fakeProductClient) FailUpdate(productId string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.updateErrors, productId)
+		return
+	}
+	f.updateErrors[productId] = err
+}
+
+func (f *fakeProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getProductLocked(productId)
+}
+
+func (f *fakeProductClient) getProductLocked(productId string) (*ProductDetails, error) {
+	if err, ok := f.getErrors[productId]; ok {
+		return nil, err
+	}
+	p, ok := f.products[productId]
+	if !ok {
+		return nil, fmt.Errorf("product with id: %v not found", productId)
+	}
+	// hand out a copy so callers can't mutate the stored product
+	return &ProductDetails{
+		ID:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		Price:       p.Price,
+		Quantity:    p.Quantity,
+	}, nil
+}
+
+func (f *fakeProductClient) ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var details []*ProductDetails
+	for _, productId := range productIds {
+		p, err := f.getProductLocked(productId)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, p)
+	}
+	return details, nil
+}
+
+func (f *fakeProductClient) UpdateProductQuantity(ctx context.Context, productId string, quantity int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.updateErrors[productId]; ok {
+		return err
+	}
+	p, ok := f.products[productId]
+	if !ok {
+		return fmt.Errorf("product with id: %v not found", productId)
+	}
+	p.Quantity = quantity
+	return nil
+}
+
+func TestFakeProductClientLookups(t *testing.T) {
+	errDown := errors.New("product service unavailable")
+	tests := []struct {
+		name       string
+		failGet    error
+		clear      bool
+		productIds []string
+		wantErr    error
+		wantNames  []string
+	}{
+		{"preset products", nil, false, []string{"p1", "p4"}, nil, []string{"Watch", "Notebook"}},
+		{"unknown product", nil, false, []string{"p1", "p404"}, nil, nil},
+		{"programmed failure", errDown, false, []string{"p1", "p4"}, errDown, nil},
+		{"cleared failure", errDown, true, []string{"p4"}, nil, []string{"Notebook"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProductClient(fakeSampleProducts()...)
+			fake.FailGet("p4", tt.failGet)
+			if tt.clear {
+				fake.FailGet("p4", nil)
+			}
+
+			details, err := fake.ListProductDetails(context.Background(), tt.productIds)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantNames == nil {
+				if err == nil {
+					t.Fatal("listing the products didn't fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listing the products failed: %v", err)
+			}
+			if len(details) != len(tt.wantNames) {
+				t.Fatalf("got %v products, want %v", len(details), len(tt.wantNames))
+			}
+			for i, p := range details {
+				if p.Name != tt.wantNames[i] {
+					t.Errorf("product %v is %q, want %q", p.ID, p.Name, tt.wantNames[i])
+				}
+				single, err := fake.GetProductDetails(context.Background(), p.ID)
+				if err != nil || *single != *p {
+					t.Errorf("GetProductDetails(%v) = %+v, %v, want %+v", p.ID, single, err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeProductClientReturnsCopies(t *testing.T) {
+	fake := newFakeProductClient(fakeSampleProducts()...)
+	p, err := fake.GetProductDetails(context.Background(), "p1")
+	if err != nil {
+		t.Fatalf("reading the product failed: %v", err)
+	}
+	p.Quantity = 0
+
+	p, err = fake.GetProductDetails(context.Background(), "p1")
+	if err != nil {
+		t.Fatalf("reading the product failed: %v", err)
+	}
+	if p.Quantity != 100 {
+		t.Errorf("quantity = %v, want the stored 100", p.Quantity)
+	}
+}
+
+func TestFakeProductClientUpdates(t *testing.T) {
+	errDown := errors.New("product service unavailable")
+	tests := []struct {
+		name         string
+		productId    string
+		failUpdate   error
+		wantErr      bool
+		wantQuantity int64
+	}{
+		{"update", "p1", nil, false, 42},
+		{"unknown product", "p404", nil, true, 100},
+		{"programmed failure", "p1", errDown, true, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProductClient(fakeSampleProducts()...)
+			fake.FailUpdate(tt.productId, tt.failUpdate)
+
+			err := fake.UpdateProductQuantity(context.Background(), tt.productId, 42)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if tt.failUpdate != nil && !errors.Is(err, tt.failUpdate) {
+				t.Errorf("err = %v, want %v", err, tt.failUpdate)
+			}
+			p, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if p.Quantity != tt.wantQuantity {
+				t.Errorf("quantity = %v, want %v", p.Quantity, tt.wantQuantity)
+			}
+
+			// a cleared failure lets the updates through again
+			fake.FailUpdate(tt.productId, nil)
+			if tt.failUpdate != nil {
+				if err := fake.UpdateProductQuantity(context.Background(), tt.productId, 42); err != nil {
+					t.Errorf("updating after clearing the failure failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeProductClientSetProduct(t *testing.T) {
+	fake := newFakeProductClient()
+	if _, err := fake.GetProductDetails(context.Background(), "p1"); err == nil {
+		t.Fatal("an empty fake client found a product")
+	}
+	fake.SetProduct(&ProductDetails{ID: "p1", Name: "Watch", Price: 250, Quantity: 1})
+	p, err := fake.GetProductDetails(context.Background(), "p1")
+	if err != nil || p.Name != "Watch" || p.Quantity != 1 {
+		t.Errorf("GetProductDetails = %+v, %v, want the set product", p, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// orderResponseFields are the json names of the fields of CreateOrderResponse, the fields ?fields= can select
+var orderResponseFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(CreateOrderResponse{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// fieldSelection is the set of order fields a client asked for with ?fields=, nil selects every field
+type fieldSelection map[string]bool
+
+// wants reports whether the field goes into the response, so the handlers can skip the work for the
+// fields nobody asked for
+func (f fieldSelection) wants(field string) bool {
+	return f == nil || f[field]
+}
+
+// orderHeaderFields selects every field of the order but its items
+func orderHeaderFields() fieldSelection {
+	fields := make(fieldSelection, len(orderResponseFields))
+	for field := range orderResponseFields {
+		if field != "items" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// parseFieldSelection reads the comma separated ?fields= of the request
+func parseFieldSelection(r *http.Request) (fieldSelection, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+	fields := make(fieldSelection)
+	for _, field := range strings.Split(v, ",") {
+		field = strings.TrimSpace(field)
+		if !orderResponseFields[field] {
+			return nil, fmt.Errorf("unknown order field: %q", field)
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// MarshalJSON leaves out the fields the client didn't select
+func (o CreateOrderResponse) MarshalJSON() ([]byte, error) {
+	// the conversion drops the method, so the marshaling doesn't recurse
+	type plainOrderResponse CreateOrderResponse
+	data, err := json.Marshal(plainOrderResponse(o))
+	if err != nil || o.fields == nil {
+		return data, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(o.fields))
+	for field := range o.fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return json.Marshal(selected)
+}
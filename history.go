@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// who changed the status of an order. The service has no user identities, the callers are told apart by
+// the admin token.
+const (
+	ActorClient = "client"
+	ActorAdmin  = "admin"
+	// the service itself, placing an order in the background
+	ActorSystem = "system"
+)
+
+// StatusChange is an entry of the status history of an order, From is empty for the status the order
+// was created in
+type StatusChange struct {
+	From  OrderStatus `json:"from,omitempty"`
+	To    OrderStatus `json:"to"`
+	At    Timestamp   `json:"at"`
+	Actor string      `json:"actor"`
+	// reason code of a cancellation or a return
+	Reason string `json:"reason,omitempty"`
+}
+
+type OrderHistoryResponse struct {
+	ID      string         `json:"id"`
+	Status  OrderStatus    `json:"status"`
+	History []StatusChange `json:"history"`
+}
+
+// requestActor returns who is making the request
+func requestActor(r *http.Request) string {
+	if isAdmin(r) {
+		return ActorAdmin
+	}
+	return ActorClient
+}
+
+// appendStatusHistory records the move of the order from the status to its current one, with the reason
+// of the order if the move cancels or returns it
+func appendStatusHistory(o *Order, from OrderStatus, at time.Time, actor string) {
+	change := StatusChange{From: from, To: o.Status, At: Timestamp{at}, Actor: actor}
+	if _, needsReason := reasonCodes[o.Status]; needsReason && o.StatusReason != nil {
+		change.Reason = o.StatusReason.Code
+	}
+	// clipped so the append never writes into a backing array shared with another copy of the order
+	o.History = append(slices.Clip(o.History), change)
+}
+
+// statusTimestamps returns when the order last entered each of the statuses it went through. The orders
+// placed before the history was recorded only have the time they were dispatched.
+func statusTimestamps(o Order) map[OrderStatus]Timestamp {
+	if len(o.History) == 0 && o.DispatchedAt.IsZero() {
+		return nil
+	}
+	timestamps := make(map[OrderStatus]Timestamp, len(o.History)+1)
+	if !o.DispatchedAt.IsZero() {
+		timestamps[OrderDispatched] = Timestamp{o.DispatchedAt}
+	}
+	for _, change := range o.History {
+		timestamps[change.To] = change.At
+	}
+	return timestamps
+}
+
+// failedStatusChange is the history entry of a pending order failing to be placed in the background
+func failedStatusChange(now time.Time) StatusChange {
+	return StatusChange{From: OrderPending, To: OrderFailed, At: Timestamp{now}, Actor: ActorSystem}
+}
+
+// GetOrderHistoryHandler returns the status changes of the order, oldest first. The orders placed before
+// the history was recorded only have the changes made since.
+func GetOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	o, _, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// Verify if the order is present in the database
+	if !ok || (isDeleted(o) && !includeDeleted) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+
+	history := o.History
+	if history == nil {
+		history = []StatusChange{}
+	}
+	writeJSON(w, http.StatusOK, OrderHistoryResponse{ID: o.ID, Status: o.Status, History: history})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendStatusHistoryConcurrentUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		history int
+		writers int
+	}{
+		{"no history", 0, 8},
+		{"with spare capacity", 3, 8},
+		{"many writers", 1, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryRepository()
+			o := Order{ID: "o1", Status: OrderPlaced, Version: 1, History: make([]StatusChange, 0, tt.history+tt.writers)}
+			for i := 0; i < tt.history; i++ {
+				o.History = append(o.History, StatusChange{To: OrderPlaced, Actor: ActorSystem})
+			}
+			if _, err := store.SaveOrder(o, nil); err != nil {
+				t.Fatalf("saving the order failed: %v", err)
+			}
+
+			// every writer reads the order before any of them updates it, so they all race on the same version
+			var read, wg sync.WaitGroup
+			var mu sync.Mutex
+			winners := []string{}
+			read.Add(tt.writers)
+			for i := 0; i < tt.writers; i++ {
+				wg.Add(1)
+				go func(actor string) {
+					defer wg.Done()
+					o, _, _, _ := store.GetOrder("o1")
+					read.Done()
+					read.Wait()
+					from, version := o.Status, o.Version
+					o.Status = OrderCancelled
+					o.Version++
+					appendStatusHistory(&o, from, time.Now(), actor)
+					if err := store.UpdateOrder(o, version); err == nil {
+						mu.Lock()
+						winners = append(winners, actor)
+						mu.Unlock()
+					}
+				}(fmt.Sprintf("writer-%v", i))
+			}
+			wg.Wait()
+
+			stored, _, _, _ := store.GetOrder("o1")
+			if len(winners) != 1 {
+				t.Fatalf("%v updates won the version check, want 1", len(winners))
+			}
+			if len(stored.History) != tt.history+1 {
+				t.Fatalf("history has %v entries, want %v", len(stored.History), tt.history+1)
+			}
+			if last := stored.History[len(stored.History)-1]; last.Actor != winners[0] {
+				t.Errorf("last history entry is by %q, want the winner %q", last.Actor, winners[0])
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReturnsDeepCopies(t *testing.T) {
+	store := NewInMemoryRepository()
+	o := Order{
+		ID:           "o1",
+		Status:       OrderPlaced,
+		Metadata:     map[string]string{"k": "v"},
+		History:      []StatusChange{{To: OrderPlaced}},
+		Discounts:    []AppliedDiscount{{Type: "coupon", Percent: 10}},
+		StatusReason: &StatusReason{Code: "other"},
+	}
+	if _, err := store.SaveOrder(o, []OrderItem{{ProductId: "p1", ProductQuantity: 1}}); err != nil {
+		t.Fatalf("saving the order failed: %v", err)
+	}
+	// changing the saved value must not reach the store
+	o.Metadata["k"] = "changed"
+	o.History[0].Actor = "changed"
+
+	tests := []struct {
+		name   string
+		mutate func(o *Order, items []OrderItem)
+	}{
+		{"metadata", func(o *Order, items []OrderItem) { o.Metadata["k"] = "changed" }},
+		{"history", func(o *Order, items []OrderItem) { o.History[0].Actor = "changed" }},
+		{"discounts", func(o *Order, items []OrderItem) { o.Discounts[0].Percent = 50 }},
+		{"status reason", func(o *Order, items []OrderItem) { o.StatusReason.Code = "changed" }},
+		{"items", func(o *Order, items []OrderItem) { items[0].ProductQuantity = 9 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, items, _, _ := store.GetOrder("o1")
+			tt.mutate(&got, items)
+
+			stored, storedItems, _, _ := store.GetOrder("o1")
+			if stored.Metadata["k"] != "v" || stored.History[0].Actor != "" || stored.Discounts[0].Percent != 10 ||
+				stored.StatusReason.Code != "other" || storedItems[0].ProductQuantity != 1 {
+				t.Errorf("changing the returned %v changed the stored order", tt.name)
+			}
+		})
+	}
+}
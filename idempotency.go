@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header, clients usually send a UUID
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyRecord is what an Idempotency-Key is bound to, the request that first used the key and
+// the order it created
+type IdempotencyRecord struct {
+	RequestHash string
+	OrderId     string
+}
+
+// hashOrderRequest fingerprints the decoded request, so a retry only has to carry the same fields and
+// not the same bytes
+func hashOrderRequest(oReq CreateOrderRequest) string {
+	data, _ := json.Marshal(oReq)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayIdempotentRequest answers a request whose Idempotency-Key is already bound with the order of the
+// first request, a different request reusing the key is rejected
+func replayIdempotentRequest(w http.ResponseWriter, r *http.Request, store OrderRepository, claimed IdempotencyRecord, requestHash string) {
+	if claimed.RequestHash != requestHash {
+		logger.InfoContext(r.Context(), "idempotency key reused for a different request", "order_id", claimed.OrderId)
+		writeJSONError(w, http.StatusUnprocessableEntity, "idempotency key was already used for a different request")
+		return
+	}
+
+	o, oItems, ok, err := store.GetOrder(claimed.OrderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		logger.InfoContext(r.Context(), "order of the idempotency key is still being placed", "order_id", claimed.OrderId)
+		writeJSONError(w, http.StatusConflict, "a request with the idempotency key is still being processed")
+		return
+	}
+	logger.InfoContext(r.Context(), "replaying the order for a retried request", "order_id", o.ID)
+
+	// Create the response
+	oResp := newOrderResponse(o)
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	oResp.Items = orderItemsDetailsList
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeJSON(w, http.StatusOK, oResp)
+}
+
+// releaseUnusedIdempotencyKey unbinds the key when the request failed before its order was stored, so
+// the client can retry with the same key
+func releaseUnusedIdempotencyKey(store OrderRepository, key, orderId string) {
+	if _, _, ok, err := store.GetOrder(orderId); err != nil || ok {
+		return
+	}
+	if err := store.ReleaseIdempotencyKey(key); err != nil {
+		logger.Error("error releasing the idempotency key", "order_id", orderId, "err", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+
+	"github.com/pborman/uuid"
+)
+
+// OrderIDGenerator generates the ids of new orders
+type OrderIDGenerator interface {
+	NewID() string
+}
+
+// uuidOrderIDGenerator generates random UUIDs, the default
+type uuidOrderIDGenerator struct{}
+
+func (uuidOrderIDGenerator) NewID() string {
+	return uuid.New()
+}
+
+// prefixedOrderIDGenerator generates human friendlier ids made of a prefix, the UTC date and a random
+// suffix, e.g. ORD-20240102-K3F9QZ2M
+type prefixedOrderIDGenerator struct {
+	prefix string
+}
+
+// 40 random bits encode to 8 characters without padding
+var orderIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (g prefixedOrderIDGenerator) NewID() string {
+	random := make([]byte, 5)
+	if _, err := rand.Read(random); err != nil {
+		// crypto/rand doesn't fail on the supported platforms, fall back to a uuid just in case
+		logger.Error("error reading random bytes for the order id", "err", err)
+		return uuid.New()
+	}
+	return fmt.Sprintf("%v-%v-%v", g.prefix, clock.Now().UTC().Format("20060102"), orderIDEncoding.EncodeToString(random))
+}
+
+// orderIDGenerator is used to generate the order ids, wired in main
+var orderIDGenerator OrderIDGenerator = uuidOrderIDGenerator{}
+
+// loadOrderIDConfig reads ORDER_ID_FORMAT (uuid/prefixed) and ORDER_ID_PREFIX used by the prefixed format
+func loadOrderIDConfig() {
+	switch format := getEnv("ORDER_ID_FORMAT", "uuid"); format {
+	case "prefixed":
+		prefix := strings.ToUpper(getEnv("ORDER_ID_PREFIX", "ORD"))
+		orderIDGenerator = prefixedOrderIDGenerator{prefix: prefix}
+		logger.Info("order id format: prefixed", "prefix", prefix)
+	case "uuid":
+		orderIDGenerator = uuidOrderIDGenerator{}
+		logger.Info("order id format: uuid")
+	default:
+		logger.Warn("invalid ORDER_ID_FORMAT, using uuid", "value", format)
+		orderIDGenerator = uuidOrderIDGenerator{}
+	}
+}
+
+// newOrderID generates an id that isn't used by any order of the tenant yet
+func newOrderID(store OrderRepository) (string, error) {
+	for {
+		id := orderIDGenerator.NewID()
+		_, _, ok, err := store.GetOrder(id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return id, nil
+		}
+		logger.Warn("generated order id is taken, generating another one", "order_id", id)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+func TestOrderIDGenerators(t *testing.T) {
+	tests := []struct {
+		format string
+		prefix string
+		valid  func(id string) bool
+	}{
+		{"uuid", "", func(id string) bool { return uuid.Parse(id) != nil }},
+		{"prefixed", "shop", regexp.MustCompile(`^SHOP-20240301-[A-Z2-7]{8}$`).MatchString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			useFakeClock(t, time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC))
+			useFakeProductClient(t, fakeSampleProducts()...)
+			prev := orderIDGenerator
+			t.Cleanup(func() { orderIDGenerator = prev })
+			t.Setenv("ORDER_ID_FORMAT", tt.format)
+			t.Setenv("ORDER_ID_PREFIX", tt.prefix)
+			loadOrderIDConfig()
+
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id := orderIDGenerator.NewID()
+				if !tt.valid(id) || seen[id] {
+					t.Fatalf("generated id %q is invalid or repeated", id)
+				}
+				seen[id] = true
+			}
+
+			// a placed order is found by its generated id
+			rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`)
+			var placed CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &placed); rec.Code != http.StatusOK || err != nil {
+				t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+			}
+			if !tt.valid(placed.ID) {
+				t.Errorf("order id %q isn't in the %v format", placed.ID, tt.format)
+			}
+			rec = httptest.NewRecorder()
+			GetOrderDetailsHandler(rec, newTenantRequest(http.MethodGet, "/orders/"+placed.ID, "", map[string]string{"order_id": placed.ID}))
+			if rec.Code != http.StatusOK {
+				t.Errorf("reading order %v answered %v: %s", placed.ID, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// sequenceOrderIDGenerator hands out the ids in order
+type sequenceOrderIDGenerator struct {
+	ids []string
+}
+
+func (g *sequenceOrderIDGenerator) NewID() string {
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+	return id
+}
+
+func TestNewOrderIDSkipsTakenIds(t *testing.T) {
+	useMemoryStores(t)
+	prev := orderIDGenerator
+	orderIDGenerator = &sequenceOrderIDGenerator{ids: []string{"o1", "o1", "o2"}}
+	t.Cleanup(func() { orderIDGenerator = prev })
+	saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+
+	id, err := newOrderID(tenantStore("t1"))
+	if err != nil || id != "o2" {
+		t.Errorf("newOrderID = %v, %v, want o2", id, err)
+	}
+}
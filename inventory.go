@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// restockRetryAttempts is the number of background retries of a restock that failed with a transient
+	// error, set by RESTOCK_RETRY_ATTEMPTS
+	restockRetryAttempts = 5
+	// restockRetryDelay is the delay before the first background retry, doubled for every following one,
+	// set by RESTOCK_RETRY_DELAY
+	restockRetryDelay = 30 * time.Second
+)
+
+// restoreInventory gives the quantities of the order items back to the inventory once the order is
+// cancelled or returned. The current quantity is re-read right before each update, and a product that
+// no longer exists or can't be updated is logged and skipped so the other items are still restored. An
+// item failing while the product service is down is retried in the background.
+func restoreInventory(ctx context.Context, orderId string, items []OrderItem) {
+	// the restock outlives the request, a client hanging up must not leave the stock taken
+	ctx = context.WithoutCancel(ctx)
+	for _, item := range items {
+		err := restoreItemInventory(ctx, orderId, item)
+		if err == nil {
+			continue
+		}
+		if isTransientProductError(err) && restockRetryAttempts > 0 {
+			logger.WarnContext(ctx, "inventory could not be restored, retrying in the background", "order_id", orderId, "product_id", item.ProductId, "err", err)
+			go retryRestoreInventory(ctx, orderId, item)
+			continue
+		}
+		logger.ErrorContext(ctx, "inventory could not be restored", "order_id", orderId, "product_id", item.ProductId, "err", err)
+	}
+}
+
+func restoreItemInventory(ctx context.Context, orderId string, item OrderItem) error {
+	writes := lockInventoryWrites(item.ProductId)
+	defer writes.mu.Unlock()
+
+	current, err := productClient.GetProductDetails(withFreshLookup(ctx), item.ProductId)
+	if err != nil {
+		return err
+	}
+	if err := productClient.UpdateProductQuantity(ctx, item.ProductId, current.Quantity+item.ProductQuantity); err != nil {
+		return err
+	}
+	writes.record(current.Quantity + item.ProductQuantity)
+	logger.InfoContext(ctx, "restored inventory", "order_id", orderId, "product_id", item.ProductId, "quantity", item.ProductQuantity)
+	return nil
+}
+
+// retryRestoreInventory retries the restock of the item up to restockRetryAttempts times, backing off
+// from restockRetryDelay, until it succeeds or fails with an error that isn't transient
+func retryRestoreInventory(ctx context.Context, orderId string, item OrderItem) {
+	delay := restockRetryDelay
+	for attempt := 1; attempt <= restockRetryAttempts; attempt++ {
+		time.Sleep(delay)
+		err := restoreItemInventory(ctx, orderId, item)
+		if err == nil {
+			return
+		}
+		if !isTransientProductError(err) {
+			logger.ErrorContext(ctx, "inventory could not be restored", "order_id", orderId, "product_id", item.ProductId, "err", err)
+			return
+		}
+		logger.WarnContext(ctx, "inventory restock retry failed", "order_id", orderId, "product_id", item.ProductId, "attempt", attempt, "err", err)
+		delay *= 2
+	}
+	logger.ErrorContext(ctx, "inventory could not be restored, giving up", "order_id", orderId, "product_id", item.ProductId, "quantity", item.ProductQuantity)
+}
+
+// inventoryWrites serializes the quantity updates this process makes of a product and remembers the
+// last of them, so a placement can tell the stock taken or given back by concurrent placements of this
+// service from a change made outside of it
+var (
+	inventoryWritesMu sync.Mutex
+	inventoryWrites   = make(map[string]*productInventoryWrites)
+)
+
+type productInventoryWrites struct {
+	mu sync.Mutex
+	// number of quantity updates of the product made by this process
+	count uint64
+	// quantity set by the last of them
+	quantity int64
+}
+
+// lockInventoryWrites locks the quantity updates of the product, the caller unlocks writes.mu
+func lockInventoryWrites(productId string) *productInventoryWrites {
+	inventoryWritesMu.Lock()
+	writes, ok := inventoryWrites[productId]
+	if !ok {
+		writes = &productInventoryWrites{}
+		inventoryWrites[productId] = writes
+	}
+	inventoryWritesMu.Unlock()
+
+	writes.mu.Lock()
+	return writes
+}
+
+func (w *productInventoryWrites) record(quantity int64) {
+	w.count++
+	w.quantity = quantity
+}
+
+// inventoryWriteCounts returns the number of quantity updates this process made so far of the products
+// of the items. It is taken before the products are looked up and handed to decrementInventory.
+func inventoryWriteCounts(items []CreateOrderItemsRequest) map[string]uint64 {
+	counts := make(map[string]uint64, len(items))
+	for _, item := range items {
+		inventoryWritesMu.Lock()
+		writes, ok := inventoryWrites[item.ProductId]
+		inventoryWritesMu.Unlock()
+		// a product this process never updated has no updates to count
+		if !ok {
+			continue
+		}
+		writes.mu.Lock()
+		counts[item.ProductId] = writes.count
+		writes.mu.Unlock()
+	}
+	return counts
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// externallyChangedProductClient changes the quantity of a product outside of the service right after
+// the placement looked it up
+type externallyChangedProductClient struct {
+	*fakeProductClient
+	productId string
+	quantity  int64
+	once      sync.Once
+}
+
+func (c *externallyChangedProductClient) ListProductDetails(ctx context.Context, productIds []string) ([]*ProductDetails, error) {
+	details, err := c.fakeProductClient.ListProductDetails(ctx, productIds)
+	c.once.Do(func() {
+		p, _ := c.fakeProductClient.GetProductDetails(ctx, c.productId)
+		p.Quantity = c.quantity
+		c.SetProduct(p)
+	})
+	return details, err
+}
+
+func TestPlacementInventoryConflicts(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       bool
+		wantStatus   int
+		wantQuantity int64
+	}{
+		{"external change aborts the placement", true, http.StatusConflict, 40},
+		{"external change overwritten without the check", false, http.StatusOK, 97},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			productClient = &externallyChangedProductClient{fakeProductClient: fake, productId: "p1", quantity: 40}
+			prev := checkInventoryConflicts
+			checkInventoryConflicts = tt.checks
+			t.Cleanup(func() { checkInventoryConflicts = prev })
+
+			rec := httptest.NewRecorder()
+			PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+				`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":3}]}`, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("placing the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			p1, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if p1.Quantity != tt.wantQuantity {
+				t.Errorf("product quantity = %v, want %v", p1.Quantity, tt.wantQuantity)
+			}
+		})
+	}
+}
+
+func TestConcurrentPlacementsOfOneProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		coalescing bool
+		orders     int
+	}{
+		{"product client", false, 30},
+		{"coalescing product client", true, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			if tt.coalescing {
+				productClient = newCoalescingProductClient(fake)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.orders; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+						`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":2}]}`, nil))
+					if rec.Code != http.StatusOK {
+						t.Errorf("placing an order answered %v: %s", rec.Code, rec.Body)
+					}
+				}()
+			}
+			wg.Wait()
+
+			p1, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if want := int64(100 - 2*tt.orders); p1.Quantity != want {
+				t.Errorf("product quantity = %v, want %v", p1.Quantity, want)
+			}
+		})
+	}
+}
+
+func TestPlacementRollbackOnInventoryFailure(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	fake.FailUpdate("p3", errors.New("product service unavailable"))
+
+	rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[`+
+		`{"product_id":"p1","quantity":2},{"product_id":"p2","quantity":3},{"product_id":"p3","quantity":4},{"product_id":"p4","quantity":5}]}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("placing the order answered %v, want 500: %s", rec.Code, rec.Body)
+	}
+
+	// the items decremented before the failing one got their stock back, the ones after it were never touched
+	for _, productId := range []string{"p1", "p2", "p3", "p4"} {
+		p, err := fake.GetProductDetails(context.Background(), productId)
+		if err != nil {
+			t.Fatalf("reading the product failed: %v", err)
+		}
+		if p.Quantity != 100 {
+			t.Errorf("%v quantity = %v, want 100", productId, p.Quantity)
+		}
+	}
+	// no ghost order is left behind
+	orders, err := tenantStore("t1").ListOrders()
+	if err != nil || len(orders) != 0 {
+		t.Errorf("the store holds %v orders, %v, want none", len(orders), err)
+	}
+}
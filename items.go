@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// priceOrder prices the order from the prices and categories its items were placed at. It applies the
+// discounts the order qualifies for, the coupon given at placement if any, attributes the discount to
+// the items and sets the amount.
+func priceOrder(o *Order, items []OrderItem, coupon *AppliedDiscount) {
+	// the amounts are computed in the minor units of the currency
+	var subtotal int64
+	var numberOfPremiumProducts int64
+	lineTotals := make([]int64, len(items))
+	for i, item := range items {
+		lineTotals[i] = lineTotalMinorUnits(item)
+		subtotal += lineTotals[i]
+		if strings.ToLower(item.Category) == "premium" {
+			numberOfPremiumProducts++
+		}
+	}
+
+	// collect the discounts the order qualifies for, the stacking policy decides which apply
+	var qualifiedDiscounts []AppliedDiscount
+
+	// Provide the premium discount, 10% for 3 premium products unless configured otherwise, 0% disables it
+	if pricingConfig.PremiumDiscountPercent > 0 && numberOfPremiumProducts >= pricingConfig.PremiumProductThreshold {
+		qualifiedDiscounts = append(qualifiedDiscounts, AppliedDiscount{Type: DiscountPremium, Percent: pricingConfig.PremiumDiscountPercent})
+	}
+	if coupon != nil {
+		qualifiedDiscounts = append(qualifiedDiscounts, AppliedDiscount{Type: DiscountCoupon, Code: coupon.Code, Percent: coupon.Percent})
+	}
+
+	discounts, discountInPercentage, discount := applyDiscountPolicy(subtotal, qualifiedDiscounts)
+	o.Discount = discountInPercentage
+	o.Discounts = discounts
+
+	// attribute the discount to the items, for invoices and proportional refunds
+	for i, share := range allocateDiscount(lineTotals, discount) {
+		items[i].DiscountMinor = share
+	}
+	o.AmountMinor = subtotal - discount
+}
+
+// orderCoupon returns the coupon applied to the order, nil if none was
+func orderCoupon(o Order) *AppliedDiscount {
+	for _, d := range o.Discounts {
+		if d.Type == DiscountCoupon {
+			return &d
+		}
+	}
+	return nil
+}
+
+// canChangeItems reports whether the items of the order can still change, only until it is dispatched
+func canChangeItems(o Order) bool {
+	return o.Status == OrderPlaced || o.Status == OrderOnHold
+}
+
+// AmendOrderItemsRequest sets the quantities of the products of an order, the products the order doesn't
+// have yet are added to it
+type AmendOrderItemsRequest struct {
+	Items []CreateOrderItemsRequest `json:"items"`
+}
+
+func (a *AmendOrderItemsRequest) Validate() (err error) {
+	if len(a.Items) == 0 {
+		return errors.New("items not provided")
+	}
+
+	seen := make(map[string]bool, len(a.Items))
+	for _, item := range a.Items {
+		// Validate the product id
+		if item.ProductId == "" {
+			return errors.New("invalid product id")
+		}
+		if seen[strings.ToLower(item.ProductId)] {
+			return errors.New("product id is repeated")
+		}
+		seen[strings.ToLower(item.ProductId)] = true
+
+		// Validate max product quantity, items are removed with DELETE /orders/{order_id}/items/{product_id}
+		if !(item.Quantity > 0 && item.Quantity <= pricingConfig.MaxItemQuantity) {
+			return fmt.Errorf("product quantiy must be greater than 0 and less than equal to %v", pricingConfig.MaxItemQuantity)
+		}
+	}
+	return nil
+}
+
+// AmendOrderItemsHandler changes the quantities of the items of an order that isn't dispatched yet and
+// adds new ones. The added quantities are checked against the inventory and taken out of it, the
+// removed ones go back to it, and the order is priced again. The items already in the order keep the
+// price they were placed at, the new ones get the current price of their product.
+func AmendOrderItemsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	var amendReq AmendOrderItemsRequest
+	err := decodeJSONBody(w, r, &amendReq)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if err = amendReq.Validate(); err != nil {
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+	if !canChangeItems(o) {
+		logger.InfoContext(r.Context(), "order items can't be changed in its status", "order_id", o.ID, "status", o.Status)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is %v, only the items of placed orders can be changed", o.ID, o.Status))
+		return
+	}
+	if status, message := ifMatchFailure(r, o); status != 0 {
+		writeJSONError(w, status, message)
+		return
+	}
+
+	// the quantities to take out of the inventory, of the changed and the new items, and the ones to
+	// give back to it
+	amended := append([]OrderItem(nil), oItems...)
+	var grown []CreateOrderItemsRequest
+	var shrunk []OrderItem
+	var added []CreateOrderItemsRequest
+	for _, item := range amendReq.Items {
+		i := orderItemIndex(amended, item.ProductId)
+		if i < 0 {
+			grown = append(grown, item)
+			added = append(added, item)
+			continue
+		}
+		change := item.Quantity - amended[i].ProductQuantity
+		amended[i].ProductQuantity = item.Quantity
+		if change > 0 {
+			grown = append(grown, CreateOrderItemsRequest{ProductId: item.ProductId, Quantity: change})
+		}
+		if change < 0 {
+			shrunk = append(shrunk, OrderItem{ProductId: item.ProductId, ProductQuantity: -change, OrderId: o.ID})
+		}
+	}
+
+	// Blocked products can't be ordered even if they are in stock
+	if blocked := blockedItems(grown); len(blocked) > 0 {
+		logger.InfoContext(r.Context(), "amendment adds blocked products", "order_id", o.ID, "product_ids", blocked)
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("products with ids: %v are blocked and can't be ordered", strings.Join(blocked, ", ")))
+		return
+	}
+
+	// hold the checked quantities until the inventory is decremented, or the amendment fails
+	var reservations []reservation
+	defer func() {
+		releaseReservations(reservations)
+	}()
+	writeCounts := inventoryWriteCounts(grown)
+	products, lookupErrs := fetchProductDetails(r.Context(), grown)
+	for _, item := range grown {
+		productDetails, ok := products[item.ProductId]
+		if !ok {
+			// a product service that timed out or is down isn't a missing product
+			if err := lookupErrs[item.ProductId]; err != nil {
+				if status, message := productErrorStatus(err); status != http.StatusInternalServerError {
+					writeJSONError(w, status, message)
+					return
+				}
+			}
+			logger.InfoContext(r.Context(), "product does not exist", "order_id", o.ID, "product_id", item.ProductId)
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("product with id: %v does not exist", item.ProductId))
+			return
+		}
+		if !tryReserveQuantity(item.ProductId, availableQuantity(item.ProductId, productDetails.Quantity), item.Quantity) {
+			logger.InfoContext(r.Context(), "product does not have enough inventory", "order_id", o.ID, "product_id", item.ProductId)
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("product with id: %v does not have enough inventory", item.ProductId))
+			return
+		}
+		reservations = append(reservations, reservation{productId: item.ProductId, quantity: item.Quantity})
+	}
+	for _, item := range added {
+		productDetails := products[item.ProductId]
+		// a zero priced product added to a placed order isn't reviewed, only the allow policy lets it in
+		if productDetails.Price == 0 && zeroPricePolicy != ZeroPriceAllow {
+			logger.InfoContext(r.Context(), "product has a zero price", "order_id", o.ID, "product_id", item.ProductId)
+			writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("product with id: %v has a zero price and can't be added to the order", item.ProductId))
+			return
+		}
+		amended = append(amended, OrderItem{
+			ProductId:       item.ProductId,
+			ProductQuantity: item.Quantity,
+			OrderId:         o.ID,
+			UnitPriceMinor:  toMinorUnits(productDetails.Price, amountCurrency(o)),
+			Category:        productDetails.Category,
+			Name:            productDetails.Name,
+		})
+	}
+
+	if len(grown) > 0 || len(shrunk) > 0 {
+		previous := o
+		now := clock.Now()
+		priceOrder(&o, amended, orderCoupon(o))
+		o.UpdatedAt = now
+		o.Version++
+
+		// Update the database
+		err = store.UpdateOrderItems(o, amended, previous.Version)
+		if errors.Is(err, errVersionConflict) {
+			logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID))
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		logger.InfoContext(r.Context(), "amended order items", "order_id", o.ID, "amount_minor", o.AmountMinor)
+
+		// take the added quantities out of the inventory, a failed update undoes the ones before it and
+		// puts the order back the way it was
+		grownItems := make([]OrderItem, 0, len(grown))
+		for _, item := range grown {
+			grownItems = append(grownItems, OrderItem{ProductId: item.ProductId, ProductQuantity: item.Quantity, OrderId: o.ID})
+		}
+		if decremented, pErr := decrementInventory(r.Context(), grownItems, products, writeCounts); pErr != nil {
+			restoreInventory(r.Context(), o.ID, decremented)
+			previous.UpdatedAt = now
+			previous.Version = o.Version + 1
+			if err := store.UpdateOrderItems(previous, oItems, o.Version); err != nil {
+				logger.ErrorContext(r.Context(), "order amendment could not be undone", "order_id", o.ID, "err", err)
+			}
+			writeJSONError(w, pErr.status, pErr.message)
+			return
+		}
+		if len(shrunk) > 0 {
+			restoreInventory(r.Context(), o.ID, shrunk)
+		}
+	}
+
+	// Prepare the response
+	orderDetails := newOrderResponse(o)
+
+	// Get the item details
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), amended, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	orderDetails.Items = orderItemsDetailsList
+
+	setOrderETag(w, o)
+	writeJSON(w, http.StatusOK, orderDetails)
+}
+
+// orderItemIndex returns the index of the item of the product, -1 if the order has none
+func orderItemIndex(items []OrderItem, productId string) int {
+	for i, item := range items {
+		if item.ProductId == productId {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveOrderItemHandler takes the item of the product out of an order that isn't dispatched yet. The
+// quantity goes back to the inventory and the order is priced again, it may no longer qualify for the
+// premium discount.
+func RemoveOrderItemHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	productId := vars["product_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+	if !canChangeItems(o) {
+		logger.InfoContext(r.Context(), "order items can't be changed in its status", "order_id", o.ID, "status", o.Status)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is %v, only the items of placed orders can be changed", o.ID, o.Status))
+		return
+	}
+	if status, message := ifMatchFailure(r, o); status != 0 {
+		writeJSONError(w, status, message)
+		return
+	}
+
+	var removed OrderItem
+	var remaining []OrderItem
+	found := false
+	for _, item := range oItems {
+		if item.ProductId == productId && !found {
+			removed, found = item, true
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+	if !found {
+		logger.InfoContext(r.Context(), "order has no item of the product", "order_id", o.ID, "product_id", productId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v has no item of product with id: %v", o.ID, productId))
+		return
+	}
+	if len(remaining) == 0 {
+		logger.InfoContext(r.Context(), "the last item of the order can't be removed", "order_id", o.ID, "product_id", productId)
+		writeJSONError(w, http.StatusUnprocessableEntity, "the last item of the order can't be removed, cancel the order instead")
+		return
+	}
+
+	readVersion := o.Version
+	priceOrder(&o, remaining, orderCoupon(o))
+	o.UpdatedAt = clock.Now()
+	o.Version++
+
+	// Update the database
+	err = store.UpdateOrderItems(o, remaining, readVersion)
+	if errors.Is(err, errVersionConflict) {
+		logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.InfoContext(r.Context(), "removed order item", "order_id", o.ID, "product_id", productId, "amount_minor", o.AmountMinor)
+
+	// the versioned update makes sure the stock of the item is only given back once
+	restoreInventory(r.Context(), o.ID, []OrderItem{removed})
+
+	// Prepare the response
+	orderDetails := newOrderResponse(o)
+
+	// Get the item details
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), remaining, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	orderDetails.Items = orderItemsDetailsList
+
+	setOrderETag(w, o)
+	writeJSON(w, http.StatusOK, orderDetails)
+}
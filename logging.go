@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevel is the minimum level of the logger, set by LOG_LEVEL (debug/info/warn/error)
+var logLevel = new(slog.LevelVar)
+
+// logger writes JSON lines to stdout, the records logged with a request context carry its request id
+var logger = slog.New(contextHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	Level: logLevel,
+	// durations as 1.5s rather than nanoseconds
+	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindDuration {
+			return slog.String(a.Key, a.Value.Duration().String())
+		}
+		return a
+	},
+})})
+
+// loadLogConfig reads LOG_LEVEL, info by default
+func loadLogConfig() error {
+	level := getEnv("LOG_LEVEL", "info")
+	if err := logLevel.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL: %v", level)
+	}
+	return nil
+}
+
+type requestIDContextKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request id of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// statusRecorder remembers the status the handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// requestLogMiddleware puts the request id in the context and the X-Request-ID response header, and logs
+// every request once it's served. A request whose handler panicked is logged with the 500
+// recoverMiddleware answers it with.
+func requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		panicked := true
+		defer func() {
+			status := sr.status
+			if panicked {
+				status = http.StatusInternalServerError
+			}
+			logger.InfoContext(ctx, "request served",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"duration", time.Since(start),
+			)
+		}()
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		panicked = false
+	})
+}
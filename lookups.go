@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// debugMode enables debugging aids such as the X-Product-Lookups response header. Set by DEBUG.
+var debugMode = false
+
+var productLookupsPerRequest = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "orders_product_lookups_per_request",
+	Help:    "Number of product service calls made while serving a single request.",
+	Buckets: []float64{0, 1, 2, 5, 10, 20, 30, 50, 100},
+})
+
+func init() {
+	prometheus.MustRegister(productLookupsPerRequest)
+}
+
+type lookupCounterKey struct{}
+
+// countProductLookup adds one product service call to the request's counter, if the context carries one
+func countProductLookup(ctx context.Context) {
+	if counter, ok := ctx.Value(lookupCounterKey{}).(*int64); ok {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+// productLookups returns the number of product service calls counted for the request so far
+func productLookups(ctx context.Context) int64 {
+	if counter, ok := ctx.Value(lookupCounterKey{}).(*int64); ok {
+		return atomic.LoadInt64(counter)
+	}
+	return 0
+}
+
+// productLookupMiddleware counts the product service calls each request makes, records them in the
+// histogram and, in debug mode, reports them in the X-Product-Lookups header
+func productLookupMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), lookupCounterKey{}, new(int64))
+		if debugMode {
+			w = &lookupHeaderWriter{ResponseWriter: w, ctx: ctx}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+		productLookupsPerRequest.Observe(float64(productLookups(ctx)))
+	})
+}
+
+// lookupHeaderWriter sets the X-Product-Lookups header right before the response header is written
+type lookupHeaderWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (w *lookupHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("X-Product-Lookups", strconv.FormatInt(productLookups(w.ctx), 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *lookupHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
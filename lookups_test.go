@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProductLookupsHeader(t *testing.T) {
+	placement := `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[` +
+		`{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p4","quantity":1}]}`
+	tests := []struct {
+		name           string
+		debug          bool
+		checkConflicts bool
+		handler        http.HandlerFunc
+		method, body   string
+		want           string
+	}{
+		// one batched lookup, then a fresh read and an update per item
+		{"placement", true, true, PlaceOrderHandler, http.MethodPost, placement, "7"},
+		{"placement without the conflict checks", true, false, PlaceOrderHandler, http.MethodPost, placement, "4"},
+		// one lookup per item without a snapshot
+		{"order details", true, true, GetOrderDetailsHandler, http.MethodGet, "", "1"},
+		{"no header outside of debug mode", false, true, PlaceOrderHandler, http.MethodPost, placement, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			productClient = &countingProductClient{fake}
+			prevDebug, prevChecks := debugMode, checkInventoryConflicts
+			debugMode, checkInventoryConflicts = tt.debug, tt.checkConflicts
+			t.Cleanup(func() { debugMode, checkInventoryConflicts = prevDebug, prevChecks })
+			saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+
+			rec := httptest.NewRecorder()
+			req := newTenantRequest(tt.method, "/orders", tt.body, map[string]string{"order_id": "o1"})
+			productLookupMiddleware(tt.handler).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("the request answered %v: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("X-Product-Lookups"); got != tt.want {
+				t.Errorf("X-Product-Lookups = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pborman/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type OrderStatus string
@@ -20,16 +30,145 @@ const (
 	OrderCompleted  OrderStatus = "completed"
 	OrderReturned   OrderStatus = "returned"
 	OrderCancelled  OrderStatus = "cancelled"
+	// fulfilment steps between placement and completion, see orderTransitions for the allowed order
+	OrderConfirmed      OrderStatus = "confirmed"
+	OrderPacked         OrderStatus = "packed"
+	OrderShipped        OrderStatus = "shipped"
+	OrderOutForDelivery OrderStatus = "out_for_delivery"
+	// returned orders whose refund was paid out
+	OrderRefunded OrderStatus = "refunded"
+	// placed orders paused for manual review, admins put orders on hold and release them back to placed
+	OrderOnHold OrderStatus = "on_hold"
+	// orders accepted for asynchronous placement, they become placed or failed once the placement completes
+	OrderPending OrderStatus = "pending"
+	OrderFailed  OrderStatus = "failed"
 )
 
 type Order struct {
-	ID           string
-	Discount     int64
-	Amount       float64
-	Status       OrderStatus
-	DispatchedAt string
-	CreatedAt    string
-	UpdatedAt    string
+	ID       string
+	Discount int64
+	// total in the minor units of the currency, e.g. cents
+	AmountMinor int64
+	Currency    string
+	Status      OrderStatus
+	// zero until the order is dispatched
+	DispatchedAt time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	// time the order moved into its current status, used for the SLA checks
+	StatusChangedAt time.Time
+	SlaBreached     bool
+	// id of the cart in the cart service the order was placed from, if any
+	CartId   string
+	TenantId string
+	// id of the customer who placed the order
+	CustomerId string
+	// discounts applied to the order, Discount holds their total percent
+	Discounts []AppliedDiscount
+	// incremented on every change to the order
+	Version int64
+	// human facing sequence number of the order within the tenant, assigned when the order is placed
+	OrderNumber int64
+	// client managed fields, updated via PATCH /orders/{order_id}
+	Notes       string
+	Metadata    map[string]string
+	Priority    OrderPriority
+	CallbackURL string
+	// why the asynchronous placement of the order failed
+	FailureReason string
+	// refund owed to the customer once the order is returned
+	Refund *Refund
+	// when the order was deleted, zero unless it is
+	DeletedAt time.Time
+	// every status the order went through, oldest first
+	History []StatusChange
+	// why the order was cancelled or returned
+	StatusReason *StatusReason
+}
+
+// UnmarshalJSON reads the orders of the bolt store and the memory snapshots, including the ones written
+// when the timestamps were strings, empty or in the legacy layout, and the ones written before the amount
+// was in minor units
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type order Order
+	stored := struct {
+		*order
+		DispatchedAt string
+		CreatedAt    string
+		UpdatedAt    string
+		DeletedAt    string
+		Amount       float64
+	}{order: (*order)(o)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	var err error
+	if o.DispatchedAt, err = parseStoredTimestamp(stored.DispatchedAt); err != nil {
+		return fmt.Errorf("invalid dispatched at of order: %v, err: %w", o.ID, err)
+	}
+	if o.CreatedAt, err = parseStoredTimestamp(stored.CreatedAt); err != nil {
+		return fmt.Errorf("invalid created at of order: %v, err: %w", o.ID, err)
+	}
+	if o.UpdatedAt, err = parseStoredTimestamp(stored.UpdatedAt); err != nil {
+		return fmt.Errorf("invalid updated at of order: %v, err: %w", o.ID, err)
+	}
+	if o.DeletedAt, err = parseStoredTimestamp(stored.DeletedAt); err != nil {
+		return fmt.Errorf("invalid deleted at of order: %v, err: %w", o.ID, err)
+	}
+	o.AmountMinor = storedMinorUnits(o.AmountMinor, stored.Amount, amountCurrency(*o))
+	return nil
+}
+
+// refund of a returned order, the full amount the customer paid in the minor units of the currency
+type Refund struct {
+	AmountMinor int64     `json:"amount_minor"`
+	Currency    string    `json:"currency,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	RefundedAt  Timestamp `json:"refunded_at"`
+}
+
+// UnmarshalJSON reads the refunds stored before the amount was in minor units
+func (rf *Refund) UnmarshalJSON(data []byte) error {
+	type refund Refund
+	stored := struct {
+		*refund
+		Amount float64 `json:"amount"`
+	}{refund: (*refund)(rf)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	rf.AmountMinor = storedMinorUnits(rf.AmountMinor, stored.Amount, refundCurrency(rf))
+	return nil
+}
+
+// refundCurrency is the currency of the refund, the refunds made before they had one are in the
+// configured currency
+func refundCurrency(rf *Refund) string {
+	if rf.Currency == "" {
+		return orderCurrency
+	}
+	return rf.Currency
+}
+
+// RefundResponse is the refund of an order in the responses
+type RefundResponse struct {
+	Amount     float64   `json:"amount"`
+	Currency   string    `json:"currency,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	RefundedAt Timestamp `json:"refunded_at"`
+}
+
+// newRefundResponse prepares the refund of the order, nil if it has none
+func newRefundResponse(rf *Refund) *RefundResponse {
+	if rf == nil {
+		return nil
+	}
+	return &RefundResponse{
+		Amount:     fromMinorUnits(rf.AmountMinor, refundCurrency(rf)),
+		Currency:   rf.Currency,
+		Reason:     rf.Reason,
+		RefundedAt: rf.RefundedAt,
+	}
 }
 
 // struct describing the items in the order
@@ -37,55 +176,156 @@ type OrderItem struct {
 	ProductId       string
 	ProductQuantity int64
 	OrderId         string
+	// product attributes at placement time, keeping historical orders stable against catalog changes. The
+	// name is empty on the items placed before it was snapshotted. The price is in the minor units of the
+	// order's currency.
+	UnitPriceMinor int64
+	Category       string
+	Name           string
+	// the item's share of the order discount, proportional to its line total, in minor units
+	DiscountMinor int64
 }
 
-var (
-	orders     = make(map[string]Order)
-	orderItems = make(map[string][]OrderItem)
-)
+// UnmarshalJSON reads the items of the bolt store and the memory snapshots written before the amounts were
+// in minor units, their amounts are in the units of the configured currency the orders were priced in
+func (item *OrderItem) UnmarshalJSON(data []byte) error {
+	type orderItem OrderItem
+	stored := struct {
+		*orderItem
+		UnitPrice float64
+		Discount  float64
+	}{orderItem: (*orderItem)(item)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	item.UnitPriceMinor = storedMinorUnits(item.UnitPriceMinor, stored.UnitPrice, orderCurrency)
+	item.DiscountMinor = storedMinorUnits(item.DiscountMinor, stored.Discount, orderCurrency)
+	return nil
+}
+
+// itemDetailsMode decides whether order reads use the price and category snapshotted on the order items
+// ("snapshot") or the product's current ones ("live"). Set by ORDER_ITEM_DETAILS, defaults to snapshot.
+var itemDetailsMode = "snapshot"
+
+// useLiveItemDetails reports whether the request should price the items with the live product details,
+// either because of the configured mode or because the client asked to ?refresh=true
+func useLiveItemDetails(r *http.Request) bool {
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	return refresh || itemDetailsMode == "live"
+}
+
+// includeItemDiscounts adds each item's share of the order discount to the item details in the responses.
+// Set by INCLUDE_ITEM_DISCOUNTS, defaults to false.
+var includeItemDiscounts = false
+
+// checkInventoryConflicts aborts a placement with a retriable conflict when the quantity of a product
+// changed between the read and the update of its inventory. Set by INVENTORY_CHECK_CONFLICTS.
+var checkInventoryConflicts = true
+
+// listIncludeCancelled controls whether GET /orders returns cancelled and returned orders when the
+// request doesn't say otherwise via ?include_cancelled=. Set by ORDERS_LIST_INCLUDE_CANCELLED, defaults to true.
+var listIncludeCancelled = true
 
 func PingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("pong"))
 }
 
-func GetOrderItemsDetailsList(orderId string) ([]CreateOrderItemsResponse, error) {
+// GetOrderItemsDetailsList prepares the details of the items of an order in the currency
+func GetOrderItemsDetailsList(ctx context.Context, items []OrderItem, currency string, live bool) ([]CreateOrderItemsResponse, error) {
 	var orderItemsDetailsList []CreateOrderItemsResponse
 
-	for _, item := range orderItems[orderId] {
+	for _, item := range items {
+		if !live && hasItemSnapshot(item) {
+			orderItemsDetailsList = append(orderItemsDetailsList, newOrderItemResponse(item, currency, nil, false))
+			continue
+		}
+
 		// call gRPC function to get the product details
-		productDetails, err := GetProductDetails(item.ProductId)
+		productDetails, err := productClient.GetProductDetails(ctx, item.ProductId)
 		if err != nil {
-			err := fmt.Errorf("product with id: %v, does not exist", item.ProductId)
-			fmt.Println(err)
-			return orderItemsDetailsList, err
+			return orderItemsDetailsList, fmt.Errorf("product with id: %v could not be fetched: %w", item.ProductId, err)
 		}
 
 		// add the product details to the list
-		orderItemsDetailsList = append(orderItemsDetailsList, CreateOrderItemsResponse{
-			ID:          item.ProductId,
-			Name:        productDetails.Name,
-			Description: productDetails.Description,
-			Category:    productDetails.Category,
-			Price:       productDetails.Price,
-			Quantity:    item.ProductQuantity,
-		})
+		orderItemsDetailsList = append(orderItemsDetailsList, newOrderItemResponse(item, currency, productDetails, live))
 	}
 	return orderItemsDetailsList, nil
 }
 
+// hasItemSnapshot reports whether the details of the item can be rendered from its snapshot alone, without
+// looking the product up
+func hasItemSnapshot(item OrderItem) bool {
+	return item.Name != ""
+}
+
+// newOrderItemResponse prepares the details of the order item in the currency of the order, priced with
+// the snapshot on the item unless live is set. Without the product details, the item is rendered from its
+// snapshot only.
+func newOrderItemResponse(item OrderItem, currency string, productDetails *ProductDetails, live bool) CreateOrderItemsResponse {
+	if productDetails == nil {
+		itemDetails := CreateOrderItemsResponse{
+			ID:       item.ProductId,
+			Name:     item.Name,
+			Category: item.Category,
+			Price:    fromMinorUnits(item.UnitPriceMinor, currency),
+			Quantity: item.ProductQuantity,
+		}
+		if includeItemDiscounts {
+			itemDetails.Discount = fromMinorUnits(item.DiscountMinor, currency)
+		}
+		return itemDetails
+	}
+
+	itemDetails := CreateOrderItemsResponse{
+		ID:          item.ProductId,
+		Name:        productDetails.Name,
+		Description: sanitizeDescription(productDetails.Description),
+		Category:    productDetails.Category,
+		Price:       productDetails.Price,
+		Quantity:    item.ProductQuantity,
+	}
+	if !live {
+		itemDetails.Category = item.Category
+		itemDetails.Price = fromMinorUnits(item.UnitPriceMinor, currency)
+	}
+	if includeItemDiscounts {
+		itemDetails.Discount = fromMinorUnits(item.DiscountMinor, currency)
+	}
+	return itemDetails
+}
+
 type CreateOrderItemsRequest struct {
 	ProductId string `json:"product_id"`
 	Quantity  int64  `json:"quantity"`
 }
 
 type CreateOrderRequest struct {
-	Items []CreateOrderItemsRequest `json:"items"`
+	// id (UUID) of the customer placing the order
+	CustomerId string                    `json:"customer_id"`
+	Items      []CreateOrderItemsRequest `json:"items"`
+	// optional ceiling on the order total, the order is rejected if the computed amount exceeds it
+	MaxTotal *float64 `json:"max_total,omitempty"`
+	// optional original placement time (RFC3339), admin-only, used to backfill historical orders
+	CreatedAt string `json:"created_at,omitempty"`
+	// optional id of the originating cart in the cart service
+	CartId string `json:"cart_id,omitempty"`
+	// place the order with the items that could be priced and reserved instead of failing the whole request
+	PartialOk bool `json:"partial_ok,omitempty"`
+	// optional coupon code, combined with the automatic discounts according to the stacking policy
+	CouponCode string `json:"coupon_code,omitempty"`
 }
 
 func (coReq *CreateOrderRequest) Validate() (err error) {
+	// Validate the customer id
+	if coReq.CustomerId == "" {
+		return errors.New("customer id not provided")
+	}
+	if uuid.Parse(coReq.CustomerId) == nil {
+		return errors.New("customer id must be a valid UUID")
+	}
+
 	if len(coReq.Items) == 0 {
-		fmt.Println("items not provided")
 		return errors.New("items not provided")
 	}
 
@@ -94,7 +334,6 @@ func (coReq *CreateOrderRequest) Validate() (err error) {
 	for _, item := range coReq.Items {
 		for _, product_id := range uniqueItems {
 			if strings.ToLower(item.ProductId) == product_id {
-				fmt.Println("product id is repeated")
 				return errors.New("product id is repeated")
 			}
 		}
@@ -104,14 +343,32 @@ func (coReq *CreateOrderRequest) Validate() (err error) {
 	for _, item := range coReq.Items {
 		// Validate the product id
 		if item.ProductId == "" {
-			fmt.Println("invalid product id")
 			return errors.New("invalid product id")
 		}
 
-		// Validate max product quantity is 10
-		if !(item.Quantity > 0 && item.Quantity <= 10) {
-			fmt.Println("product quantiy must be greater than 0 and less than eqaul to 10")
-			return errors.New("product quantiy must be greater than 0 and less than equal to 10")
+		// Validate max product quantity, 10 unless configured otherwise
+		if !(item.Quantity > 0 && item.Quantity <= pricingConfig.MaxItemQuantity) {
+			return fmt.Errorf("product quantiy must be greater than 0 and less than equal to %v", pricingConfig.MaxItemQuantity)
+		}
+	}
+
+	if coReq.MaxTotal != nil && *coReq.MaxTotal <= 0 {
+		return errors.New("max total must be greater than 0")
+	}
+
+	// Validate the cart id
+	if coReq.CartId != "" && (len(coReq.CartId) > 64 || strings.ContainsAny(coReq.CartId, " \t\r\n")) {
+		return errors.New("cart id must be at most 64 characters without whitespace")
+	}
+
+	// Validate the created at is a RFC3339 time in the past
+	if coReq.CreatedAt != "" {
+		createdAt, err := time.Parse(time.RFC3339, coReq.CreatedAt)
+		if err != nil {
+			return errors.New("created at must be a valid RFC3339 time")
+		}
+		if createdAt.After(clock.Now()) {
+			return errors.New("created at cannot be in the future")
 		}
 	}
 
@@ -121,90 +378,293 @@ func (coReq *CreateOrderRequest) Validate() (err error) {
 type CreateOrderItemsResponse struct {
 	ID          string  `json:"id"`
 	Name        string  `json:"name"`
-	Description string  `json:"description"`
+	Description string  `json:"description,omitempty"`
 	Category    string  `json:"category"`
 	Price       float64 `json:"price"`
 	Quantity    int64   `json:"quantity"`
+	Discount    float64 `json:"discount,omitempty"`
 }
 
 type CreateOrderResponse struct {
-	ID           string                     `json:"id"`
-	Items        []CreateOrderItemsResponse `json:"items"`
-	Discount     int64                      `json:"discount,omitempty"`
-	Amount       float64                    `json:"amount"`
-	Status       OrderStatus                `json:"status"`
-	DispatchedAt string                     `json:"dispatched_at,omitempty"`
-	CreatedAt    string                     `json:"created_at"`
-	UpdatedAt    string                     `json:"updated_at"`
+	ID        string                     `json:"id"`
+	Items     []CreateOrderItemsResponse `json:"items"`
+	Discount  int64                      `json:"discount,omitempty"`
+	Discounts []DiscountResponse         `json:"discounts,omitempty"`
+	Amount    float64                    `json:"amount"`
+	// the amount with the decimals of the currency and its code, e.g. "12.50 USD"
+	AmountFormatted string             `json:"amount_formatted"`
+	Currency        string             `json:"currency,omitempty"`
+	Status          OrderStatus        `json:"status"`
+	DispatchedAt    *Timestamp         `json:"dispatched_at,omitempty"`
+	CreatedAt       Timestamp          `json:"created_at"`
+	UpdatedAt       Timestamp          `json:"updated_at"`
+	SlaBreached     bool               `json:"sla_breached,omitempty"`
+	CartId          string             `json:"cart_id,omitempty"`
+	CustomerId      string             `json:"customer_id,omitempty"`
+	OrderNumber     int64              `json:"order_number,omitempty"`
+	SkippedItems    []SkippedOrderItem `json:"skipped_items,omitempty"`
+	Version         int64              `json:"version"`
+	Notes           string             `json:"notes,omitempty"`
+	Metadata        map[string]string  `json:"metadata,omitempty"`
+	Priority        OrderPriority      `json:"priority,omitempty"`
+	CallbackURL     string             `json:"callback_url,omitempty"`
+	FailureReason   string             `json:"failure_reason,omitempty"`
+	Refund          *RefundResponse    `json:"refund,omitempty"`
+	DeletedAt       *Timestamp         `json:"deleted_at,omitempty"`
+	StatusReason    *StatusReason      `json:"status_reason,omitempty"`
+	// status -> when the order entered it, for the SLAs and the analytics downstream
+	StatusTimestamps map[OrderStatus]Timestamp `json:"status_timestamps,omitempty"`
+	// fields selected by the client with ?fields=, nil for all of them
+	fields fieldSelection
+}
+
+// newOrderResponse prepares the response for the order, without its items
+func newOrderResponse(o Order) CreateOrderResponse {
+	return CreateOrderResponse{
+		ID:               o.ID,
+		Discount:         o.Discount,
+		Discounts:        newDiscountResponses(o.Discounts, amountCurrency(o)),
+		Amount:           fromMinorUnits(o.AmountMinor, amountCurrency(o)),
+		AmountFormatted:  formatAmount(o.AmountMinor, amountCurrency(o)),
+		Currency:         o.Currency,
+		Status:           o.Status,
+		DispatchedAt:     optionalTimestamp(o.DispatchedAt),
+		CreatedAt:        Timestamp{o.CreatedAt},
+		UpdatedAt:        Timestamp{o.UpdatedAt},
+		SlaBreached:      slaBreached(o, clock.Now()),
+		CartId:           o.CartId,
+		CustomerId:       o.CustomerId,
+		OrderNumber:      o.OrderNumber,
+		Version:          o.Version,
+		Notes:            o.Notes,
+		Metadata:         o.Metadata,
+		Priority:         o.Priority,
+		CallbackURL:      o.CallbackURL,
+		FailureReason:    o.FailureReason,
+		Refund:           newRefundResponse(o.Refund),
+		DeletedAt:        optionalTimestamp(o.DeletedAt),
+		StatusReason:     o.StatusReason,
+		StatusTimestamps: statusTimestamps(o),
+	}
+}
+
+// item left out of a partially placed order
+type SkippedOrderItem struct {
+	ProductId string `json:"product_id"`
+	Reason    string `json:"reason"`
 }
 
 func PlaceOrderHandler(w http.ResponseWriter, r *http.Request) {
 	var oReq CreateOrderRequest
+	tenantId := tenantFromContext(r.Context())
+	store := tenantStore(tenantId)
 
-	err := json.NewDecoder(r.Body).Decode(&oReq)
+	err := decodeJSONBody(w, r, &oReq)
 	if err != nil {
-		fmt.Println("error unmashiling the request body, err:", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid Request Body"))
+		writeDecodeError(w, r, err)
 		return
 	}
 
 	if err = oReq.Validate(); err != nil {
-		fmt.Println("error validating the request body, err:", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	for _, item := range oReq.Items {
-		// todo: use gRPC apis, get product details
-		// todo: Validate if the product exists
-		productDetails, err := GetProductDetails(item.ProductId)
-		if err != nil {
-			fmt.Println("product with id:", item.ProductId, "does not exist")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(fmt.Sprintf("product with id: %v does not exist", item.ProductId)))
+	// Blocked products can't be ordered even if they are in stock
+	if blocked := blockedItems(oReq.Items); len(blocked) > 0 {
+		logger.InfoContext(r.Context(), "order contains blocked products", "product_ids", blocked)
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("products with ids: %v are blocked and can't be ordered", strings.Join(blocked, ", ")))
+		return
+	}
+
+	// Only admins may backfill orders with their original creation time
+	if oReq.CreatedAt != "" && !isAdmin(r) {
+		logger.InfoContext(r.Context(), "created at provided by a non admin caller")
+		writeJSONError(w, http.StatusForbidden, "created at can only be provided by an admin")
+		return
+	}
+
+	// Validate the coupon code against the configured coupons
+	var couponPercent int64
+	if oReq.CouponCode != "" {
+		oReq.CouponCode = strings.ToUpper(strings.TrimSpace(oReq.CouponCode))
+		percent, ok := coupons[oReq.CouponCode]
+		if !ok {
+			logger.InfoContext(r.Context(), "invalid coupon code", "coupon_code", oReq.CouponCode)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid coupon code: %v", oReq.CouponCode))
 			return
 		}
+		couponPercent = percent
+	}
 
-		// todo: Validate if the inventory contains the required quantity
-		if productDetails.Quantity < item.Quantity {
-			fmt.Println("product with id:", item.ProductId, "does not have enough inventory")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(fmt.Sprintf("product with id: %v does not have enough inventory", item.ProductId)))
+	id, err := newOrderID(store)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// A retried request with the same Idempotency-Key gets the order of the first request
+	if idempotencyKey := r.Header.Get("Idempotency-Key"); idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			logger.InfoContext(r.Context(), "idempotency key too long", "length", len(idempotencyKey))
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("idempotency key must be at most %v characters", maxIdempotencyKeyLength))
+			return
+		}
+		record := IdempotencyRecord{RequestHash: hashOrderRequest(oReq), OrderId: id}
+		claimed, ok, err := store.ClaimIdempotencyKey(idempotencyKey, record)
+		if err != nil {
+			writeStoreError(w, err)
 			return
 		}
+		if !ok {
+			replayIdempotentRequest(w, r, store, claimed, record.RequestHash)
+			return
+		}
+		defer releaseUnusedIdempotencyKey(store, idempotencyKey, id)
+	}
+
+	// A cart can only be turned into a single order
+	orderId, ok, err := store.FindByCartId(oReq.CartId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if oReq.CartId != "" && ok {
+		logger.InfoContext(r.Context(), "cart already has an order", "cart_id", oReq.CartId, "order_id", orderId)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("cart with id: %v already has an order with id: %v", oReq.CartId, orderId))
+		return
 	}
 
 	// create an order
-	currentTime := time.Now().UTC().String()
+	now := clock.Now()
+	if oReq.CreatedAt != "" {
+		// already validated
+		createdAt, _ := time.Parse(time.RFC3339, oReq.CreatedAt)
+		now = createdAt.UTC()
+	}
 	o := Order{
-		ID:        uuid.New(),
-		Status:    OrderPlaced,
-		CreatedAt: currentTime,
-		UpdatedAt: currentTime,
+		ID:              id,
+		Status:          OrderPlaced,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		StatusChangedAt: now,
+		CartId:          oReq.CartId,
+		TenantId:        tenantId,
+		CustomerId:      oReq.CustomerId,
+		Version:         1,
 	}
 
-	var orderAmount float64
-	var numberOfPremiumProducts int64
-	var oItems []OrderItem
+	// Large orders can be placed in the background, the client polls the order for the outcome
+	if wantsAsyncPlacement(r) {
+		acceptOrder(w, r, store, o, oReq, couponPercent)
+		return
+	}
+
+	o, oItems, skippedItems, pErr := placeOrder(r.Context(), store, o, oReq, couponPercent, requestActor(r))
+	if pErr != nil {
+		writeJSONError(w, pErr.status, pErr.message)
+		return
+	}
+
+	// Create the response
+	oResp := newOrderResponse(o)
+	oResp.SkippedItems = skippedItems
+	// Get the product details
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	oResp.Items = orderItemsDetailsList
+
+	setOrderETag(w, o)
+	writeJSON(w, http.StatusOK, oResp)
+}
+
+// placementError is a failed placement and the response status it maps to
+type placementError struct {
+	status  int
+	message string
+}
+
+// placeOrder checks the items against the inventory, prices them, stores the order as placed and
+// decrements the inventory of its items. It returns the placed order with its items and the items
+// skipped with partial_ok.
+func placeOrder(ctx context.Context, store OrderRepository, o Order, oReq CreateOrderRequest, couponPercent int64, actor string) (Order, []OrderItem, []SkippedOrderItem, *placementError) {
+	// items that will be part of the order, with partial_ok the ones that can't be placed are skipped
+	var items []CreateOrderItemsRequest
+	var skippedItems []SkippedOrderItem
+
+	// hold the checked quantities until the inventory is decremented, or the placement fails
+	var reservations []reservation
+	defer func() {
+		releaseReservations(reservations)
+	}()
+
+	// an order placed in the background is already stored as pending
+	wasPending := o.Status == OrderPending
+
+	// the product details are fetched once and reused for the inventory checks, the pricing and the
+	// inventory updates
+	writeCounts := inventoryWriteCounts(oReq.Items)
+	products, lookupErrs := fetchProductDetails(ctx, oReq.Items)
 
 	for _, item := range oReq.Items {
-		// todo use gRPC apis, get product details
-		productDetails, err := GetProductDetails(item.ProductId)
-		if err != nil {
-			fmt.Println("product with id:", item.ProductId, "does not exist while preparing order")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(fmt.Sprintf("product with id: %v does not exist while preparing order", item.ProductId)))
-			return
+		// Validate if the product exists
+		productDetails, ok := products[item.ProductId]
+		if !ok && oReq.PartialOk {
+			logger.InfoContext(ctx, "skipping a product that could not be fetched", "order_id", o.ID, "product_id", item.ProductId)
+			skippedItems = append(skippedItems, SkippedOrderItem{ProductId: item.ProductId, Reason: "product details could not be fetched"})
+			continue
+		}
+		if !ok {
+			// a product service that timed out or is down isn't a missing product
+			if err := lookupErrs[item.ProductId]; err != nil {
+				if status, message := productErrorStatus(err); status != http.StatusInternalServerError {
+					return o, nil, nil, &placementError{status: status, message: message}
+				}
+			}
+			logger.InfoContext(ctx, "product does not exist", "order_id", o.ID, "product_id", item.ProductId)
+			return o, nil, nil, &placementError{status: http.StatusNotFound, message: fmt.Sprintf("product with id: %v does not exist", item.ProductId)}
 		}
 
-		// update the order amount
-		orderAmount += productDetails.Price * float64(item.Quantity)
+		// todo: Validate if the inventory contains the required quantity
+		// quantities reserved by other in-flight placements are not available
+		// the safety stock of the product isn't available to orders
+		reserved := tryReserveQuantity(item.ProductId, availableQuantity(item.ProductId, productDetails.Quantity), item.Quantity)
+		if !reserved && oReq.PartialOk {
+			logger.InfoContext(ctx, "skipping a product without enough inventory", "order_id", o.ID, "product_id", item.ProductId)
+			skippedItems = append(skippedItems, SkippedOrderItem{ProductId: item.ProductId, Reason: "not enough inventory"})
+			continue
+		}
+		if !reserved {
+			logger.InfoContext(ctx, "product does not have enough inventory", "order_id", o.ID, "product_id", item.ProductId)
+			return o, nil, nil, &placementError{status: http.StatusNotFound, message: fmt.Sprintf("product with id: %v does not have enough inventory", item.ProductId)}
+		}
+		reservations = append(reservations, reservation{productId: item.ProductId, quantity: item.Quantity})
+		items = append(items, item)
+	}
+
+	var oItems []OrderItem
+	// set when the order has zero priced items and the policy asks for a review
+	needsReview := false
+
+	for _, item := range items {
+		productDetails := products[item.ProductId]
 
-		// updated the counter if item is premium product
-		if strings.ToLower(productDetails.Category) == "premium" {
-			numberOfPremiumProducts += 1
+		if productDetails.Price == 0 && zeroPricePolicy == ZeroPriceReject {
+			if oReq.PartialOk {
+				logger.InfoContext(ctx, "skipping a product with a zero price", "order_id", o.ID, "product_id", item.ProductId)
+				skippedItems = append(skippedItems, SkippedOrderItem{ProductId: item.ProductId, Reason: "product has a zero price"})
+				continue
+			}
+			logger.InfoContext(ctx, "product has a zero price", "order_id", o.ID, "product_id", item.ProductId)
+			return o, nil, nil, &placementError{status: http.StatusUnprocessableEntity, message: fmt.Sprintf("product with id: %v has a zero price and can't be ordered", item.ProductId)}
+		}
+		if productDetails.Price == 0 && zeroPricePolicy == ZeroPriceReview {
+			logger.InfoContext(ctx, "product has a zero price, the order will be held for review", "order_id", o.ID, "product_id", item.ProductId)
+			needsReview = true
 		}
 
 		// create order items
@@ -212,286 +672,862 @@ func PlaceOrderHandler(w http.ResponseWriter, r *http.Request) {
 			ProductId:       item.ProductId,
 			ProductQuantity: item.Quantity,
 			OrderId:         o.ID,
+			UnitPriceMinor:  toMinorUnits(productDetails.Price, orderCurrency),
+			Category:        productDetails.Category,
+			Name:            productDetails.Name,
 		})
 	}
 
-	// todo: Provide a discount of 10% if order contains 3 premium product
-	if numberOfPremiumProducts >= 3 {
-		var discountInPercentage int64 = 10
-		o.Discount = discountInPercentage
+	if len(oItems) == 0 {
+		logger.InfoContext(ctx, "none of the items could be placed", "order_id", o.ID)
+		return o, nil, nil, &placementError{status: http.StatusUnprocessableEntity, message: "none of the items could be placed"}
+	}
+
+	var coupon *AppliedDiscount
+	if oReq.CouponCode != "" {
+		coupon = &AppliedDiscount{Type: DiscountCoupon, Code: oReq.CouponCode, Percent: couponPercent}
+	}
+	o.Currency = orderCurrency
+	priceOrder(&o, oItems, coupon)
 
-		orderAmount -= orderAmount * float64(discountInPercentage) / 100
-		fmt.Println(orderAmount)
+	// Reject the order if the total crossed the client's ceiling, before the inventory is touched
+	if oReq.MaxTotal != nil && o.AmountMinor > toMinorUnits(*oReq.MaxTotal, o.Currency) {
+		logger.InfoContext(ctx, "order total exceeds the max total", "order_id", o.ID, "amount_minor", o.AmountMinor, "max_total", *oReq.MaxTotal)
+		return o, nil, nil, &placementError{status: http.StatusUnprocessableEntity, message: fmt.Sprintf("order total: %v exceeds the max total: %v", formatAmount(o.AmountMinor, o.Currency), formatAmount(toMinorUnits(*oReq.MaxTotal, o.Currency), o.Currency))}
 	}
-	o.Amount = orderAmount
 
 	// update the database
-	orders[o.ID] = o
-	orderItems[o.ID] = oItems
-	fmt.Println("success creating the order:", o, "with items:", oItems)
+	o.Status = OrderPlaced
+	if needsReview {
+		o.Status = OrderOnHold
+	}
+	if wasPending {
+		appendStatusHistory(&o, OrderPending, o.StatusChangedAt, actor)
+	} else {
+		appendStatusHistory(&o, "", o.StatusChangedAt, actor)
+	}
+	o, err := store.SaveOrder(o, oItems)
+	if err != nil {
+		logger.ErrorContext(ctx, "order store call failed", "order_id", o.ID, "err", err)
+		return o, nil, nil, &placementError{status: http.StatusInternalServerError, message: "the order store is unavailable"}
+	}
+	evictOrders()
+	logger.InfoContext(ctx, "created order", "order_id", o.ID, "order_number", o.OrderNumber, "status", o.Status, "items", len(oItems))
+
+	// update the product quantity in the inventory, only for the items that made it into the order. The
+	// placement is all or nothing, a failed update undoes the ones before it and removes the order.
+	if decremented, pErr := decrementInventory(ctx, oItems, products, writeCounts); pErr != nil {
+		rollbackPlacement(ctx, store, o, decremented, wasPending)
+		return o, nil, nil, pErr
+	}
+	logger.DebugContext(ctx, "updated the product inventory", "order_id", o.ID)
+	ordersPlacedTotal.Inc()
+	// only the discounts of placed orders count, not the ones of rejected or rolled back placements
+	for _, d := range o.Discounts {
+		recordDiscount(d.Type, fromMinorUnits(d.AmountMinor, o.Currency))
+	}
 
-	// update the product quantity in the inventory
-	for _, item := range oReq.Items {
-		// todo call gRPC service to get the product details
-		productDetails, err := GetProductDetails(item.ProductId)
+	publishOrderCreated(ctx, o, oItems)
+	return o, oItems, skippedItems, nil
+}
+
+// decrementInventory takes the quantities of the items out of the inventory. writeCounts are the
+// inventoryWriteCounts taken before the products were looked up. It returns the items whose inventory
+// was decremented, on a failure the ones before the failing item.
+func decrementInventory(ctx context.Context, oItems []OrderItem, products map[string]*ProductDetails, writeCounts map[string]uint64) ([]OrderItem, *placementError) {
+	var decremented []OrderItem
+	for _, item := range oItems {
+		if pErr := decrementItemInventory(ctx, item, products[item.ProductId], writeCounts[item.ProductId]); pErr != nil {
+			return decremented, pErr
+		}
+		decremented = append(decremented, item)
+	}
+	return decremented, nil
+}
+
+// decrementItemInventory takes the quantity of the item out of the inventory. The updates of a product
+// are serialized within the process, so concurrent placements of the same product each start from the
+// quantity the one before them set.
+func decrementItemInventory(ctx context.Context, item OrderItem, productDetails *ProductDetails, writeCount uint64) *placementError {
+	writes := lockInventoryWrites(item.ProductId)
+	defer writes.mu.Unlock()
+
+	// the quantity looked up, unless this process updated the product since then
+	expected := productDetails.Quantity
+	if writes.count != writeCount {
+		expected = writes.quantity
+	}
+	// the product service can't compare and set, re-read the quantity right before the update so a
+	// change made outside of this service since the lookup isn't overwritten
+	if checkInventoryConflicts {
+		current, err := productClient.GetProductDetails(withFreshLookup(ctx), item.ProductId)
+		if err == nil && current.Quantity != expected {
+			logger.WarnContext(ctx, "inventory changed while placing the order", "product_id", item.ProductId, "from", expected, "to", current.Quantity)
+			return &placementError{status: http.StatusConflict, message: fmt.Sprintf("inventory for product with id: %v changed while placing the order, retry the request", item.ProductId)}
+		}
+	}
+	// never sell into the safety stock, even if the stock dropped since the inventory check
+	if availableQuantity(item.ProductId, expected) < item.ProductQuantity {
+		logger.WarnContext(ctx, "updating the inventory would breach the safety stock", "product_id", item.ProductId)
+		return &placementError{status: http.StatusConflict, message: fmt.Sprintf("product with id: %v does not have enough inventory above its safety stock", item.ProductId)}
+	}
+	if err := productClient.UpdateProductQuantity(ctx, item.ProductId, expected-item.ProductQuantity); err != nil {
+		logger.ErrorContext(ctx, "inventory could not be updated", "product_id", item.ProductId, "err", err)
+		if status, message := productErrorStatus(err); status != http.StatusInternalServerError {
+			return &placementError{status: status, message: message}
+		}
+		return &placementError{status: http.StatusInternalServerError, message: fmt.Sprintf("inventory for product with id: %v could not be updated", item.ProductId)}
+	}
+	writes.record(expected - item.ProductQuantity)
+	return nil
+}
+
+// rollbackPlacement gives the decremented quantities back to the inventory and removes the order. An
+// order placed in the background stays, it is marked as failed by the caller.
+func rollbackPlacement(ctx context.Context, store OrderRepository, o Order, decremented []OrderItem, wasPending bool) {
+	logger.WarnContext(ctx, "rolling back the placement", "order_id", o.ID)
+	restoreInventory(ctx, o.ID, decremented)
+	if wasPending {
+		return
+	}
+	if err := store.DeleteOrder(o.ID, o.Version); err != nil {
+		logger.ErrorContext(ctx, "order could not be removed while rolling back its placement", "order_id", o.ID, "err", err)
+	}
+}
+
+// fetchProductDetails looks up the products of the items with a single ListProductDetails call. When the
+// batch fails the products are looked up one by one, so one bad product doesn't fail the others. The
+// products that couldn't be fetched are missing from the returned map, with the error of their lookup
+// in the second map.
+func fetchProductDetails(ctx context.Context, items []CreateOrderItemsRequest) (map[string]*ProductDetails, map[string]error) {
+	productIds := make([]string, 0, len(items))
+	for _, item := range items {
+		productIds = append(productIds, item.ProductId)
+	}
+
+	products := make(map[string]*ProductDetails, len(items))
+	lookupErrs := make(map[string]error)
+	details, err := productClient.ListProductDetails(ctx, productIds)
+	if err == nil {
+		for _, p := range details {
+			if p != nil {
+				products[p.ID] = p
+			}
+		}
+		return products, lookupErrs
+	}
+
+	logger.WarnContext(ctx, "error fetching the product details in a batch, looking them up one by one", "err", err)
+	for _, productId := range productIds {
+		p, err := productClient.GetProductDetails(ctx, productId)
 		if err != nil {
-			fmt.Println("product with id:", item.ProductId, "does not exist while updating product quantity in the order inventory")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(fmt.Sprintf("product with id: %v does not exist while updating product quantity in the order inventory", item.ProductId)))
-			return
+			logger.WarnContext(ctx, "error fetching the product details", "product_id", productId, "err", err)
+			lookupErrs[productId] = err
+			continue
 		}
-		if err := UpdateProductQuantity(item.ProductId, productDetails.Quantity-item.Quantity); err != nil {
-			fmt.Println("inventory for product with id:", item.ProductId, "could not be updated")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("inventory for product with id: %v could not be updated", item.ProductId)))
-			return
+		products[productId] = p
+	}
+	return products, lookupErrs
+}
+
+// page of orders returned by GET /orders, total counts the matching orders across all the pages
+type OrderListResponse struct {
+	Orders []CreateOrderResponse `json:"orders"`
+	Total  int                   `json:"total"`
+	Limit  int                   `json:"limit"`
+	Offset int                   `json:"offset"`
+	// passed as ?cursor= to get the next page, empty on the last page
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Links      PageLinks `json:"links"`
+}
+
+const (
+	defaultOrdersPageLimit = 20
+	maxOrdersPageLimit     = 100
+)
+
+// compareOrders compares the orders by the sort field, negative if a sorts before b in ascending order
+func compareOrders(a, b Order, sortField string) int {
+	switch sortField {
+	case "amount":
+		switch {
+		case a.AmountMinor < b.AmountMinor:
+			return -1
+		case a.AmountMinor > b.AmountMinor:
+			return 1
 		}
+		return 0
+	case "status":
+		return strings.Compare(string(a.Status), string(b.Status))
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
 	}
-	fmt.Println("success updating the product inventory")
+}
 
-	// Create the response
-	oResp := CreateOrderResponse{
-		ID:        o.ID,
-		Discount:  o.Discount,
-		Amount:    o.Amount,
-		Status:    o.Status,
-		CreatedAt: o.CreatedAt,
-		UpdatedAt: o.UpdatedAt,
+// parsePageParams reads the ?limit= and ?offset= of a listing, the limit is capped at maxOrdersPageLimit
+func parsePageParams(query url.Values) (limit, offset int, err error) {
+	limit = defaultOrdersPageLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		limit = n
 	}
-	// Get the product details
-	orderItemsDetailsList, err := GetOrderItemsDetailsList(o.ID)
+	if limit > maxOrdersPageLimit {
+		limit = maxOrdersPageLimit
+	}
+
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// orderSortsBefore reports whether a is listed before b, ties are broken by id in either direction so
+// the pages are stable
+func orderSortsBefore(a, b Order, sortField string, descending bool) bool {
+	if c := compareOrders(a, b, sortField); c != 0 {
+		return (c > 0) == descending
+	}
+	return a.ID < b.ID
+}
+
+// sortOrders sorts the orders in the order they are listed
+func sortOrders(orders []Order, sortField string, descending bool) {
+	sort.Slice(orders, func(i, j int) bool {
+		return orderSortsBefore(orders[i], orders[j], sortField, descending)
+	})
+}
+
+func GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	store := tenantStore(tenantFromContext(r.Context()))
+	query := r.URL.Query()
+
+	limit, offset, err := parsePageParams(query)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		logger.InfoContext(r.Context(), "invalid paging parameters", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	oResp.Items = orderItemsDetailsList
 
-	resp, err := json.Marshal(oResp)
-	if err != nil {
-		fmt.Println("error mashiling the response, err:", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	status := OrderStatus(query.Get("status"))
+	if status != "" && !validOrderStatus(status) {
+		logger.InfoContext(r.Context(), "invalid status value", "value", status)
+		writeJSONError(w, http.StatusBadRequest, "invalid order status")
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
-}
 
-func GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	var orderList []CreateOrderResponse
+	includeCancelled := listIncludeCancelled
+	if v := query.Get("include_cancelled"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.InfoContext(r.Context(), "invalid include_cancelled value", "value", v)
+			writeJSONError(w, http.StatusBadRequest, "include_cancelled must be true or false")
+			return
+		}
+		includeCancelled = b
+	}
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
+	}
 
-	for _, o := range orders {
-		orderDetails := CreateOrderResponse{
-			ID:           o.ID,
-			Discount:     o.Discount,
-			Amount:       o.Amount,
-			Status:       o.Status,
-			DispatchedAt: o.DispatchedAt,
-			CreatedAt:    o.CreatedAt,
-			UpdatedAt:    o.UpdatedAt,
+	var orderNumber int64
+	if v := query.Get("order_number"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			logger.InfoContext(r.Context(), "invalid order_number value", "value", v)
+			writeJSONError(w, http.StatusBadRequest, "order_number must be a positive integer")
+			return
 		}
+		orderNumber = n
+	}
 
-		// Get the item details
-		orderItemsDetailsList, err := GetOrderItemsDetailsList(o.ID)
+	// the creation time window is half open, created_after is inclusive and created_before exclusive, so
+	// consecutive windows don't overlap
+	var createdAfter, createdBefore time.Time
+	if v := query.Get("created_after"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			logger.InfoContext(r.Context(), "invalid created_after value", "value", v)
+			writeJSONError(w, http.StatusBadRequest, "created_after must be an RFC 3339 timestamp")
 			return
 		}
-		orderDetails.Items = orderItemsDetailsList
+		createdAfter = t
+	}
+	if v := query.Get("created_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			logger.InfoContext(r.Context(), "invalid created_before value", "value", v)
+			writeJSONError(w, http.StatusBadRequest, "created_before must be an RFC 3339 timestamp")
+			return
+		}
+		createdBefore = t
+	}
+	if !createdAfter.IsZero() && !createdBefore.IsZero() && !createdAfter.Before(createdBefore) {
+		logger.InfoContext(r.Context(), "empty creation time window", "created_after", createdAfter, "created_before", createdBefore)
+		writeJSONError(w, http.StatusBadRequest, "created_after must be before created_before")
+		return
+	}
 
-		orderList = append(orderList, orderDetails)
+	// newest first by default, sorting by status is alphabetical
+	sortField := query.Get("sort")
+	switch sortField {
+	case "":
+		sortField = "created_at"
+	case "created_at", "amount", "status":
+	default:
+		logger.InfoContext(r.Context(), "invalid sort value", "value", sortField)
+		writeJSONError(w, http.StatusBadRequest, "sort must be one of created_at, amount or status")
+		return
+	}
+	descending := true
+	switch v := query.Get("direction"); v {
+	case "", "desc":
+	case "asc":
+		descending = false
+	default:
+		logger.InfoContext(r.Context(), "invalid direction value", "value", v)
+		writeJSONError(w, http.StatusBadRequest, "direction must be asc or desc")
+		return
 	}
 
-	resp, err := json.Marshal(orderList)
+	fields, err := parseFieldSelection(r)
 	if err != nil {
-		fmt.Println("error mashiling the response, err:", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		logger.InfoContext(r.Context(), "invalid fields value", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+
+	// resolving the items takes product service calls, the listing only returns them with ?include=items
+	// or when ?fields= selects them
+	includeItems := false
+	if v := query.Get("include"); v != "" {
+		for _, include := range strings.Split(v, ",") {
+			if strings.TrimSpace(include) != "items" {
+				logger.InfoContext(r.Context(), "invalid include value", "value", v)
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown include: %q", strings.TrimSpace(include)))
+				return
+			}
+			includeItems = true
+		}
+	}
+	switch {
+	case fields == nil && !includeItems:
+		fields = orderHeaderFields()
+	case fields != nil && includeItems:
+		fields["items"] = true
+	}
+
+	// a cursor continues the listing after the last order of the previous page, for the same sort
+	var cursor *orderCursor
+	if v := query.Get("cursor"); v != "" {
+		if query.Get("offset") != "" {
+			logger.InfoContext(r.Context(), "cursor combined with an offset")
+			writeJSONError(w, http.StatusBadRequest, "cursor and offset can't be combined")
+			return
+		}
+		c, err := decodeOrderCursor(v)
+		if err != nil {
+			logger.InfoContext(r.Context(), "invalid cursor value", "value", v)
+			writeJSONError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if c.Sort != sortField || c.Descending != descending {
+			logger.InfoContext(r.Context(), "cursor issued for another sort", "cursor_sort", c.Sort, "sort", sortField)
+			writeJSONError(w, http.StatusBadRequest, "cursor was issued for a different sort or direction")
+			return
+		}
+		cursor = &c
+	}
+
+	// Narrow down to the order placed from the cart or with the order number via their indexes
+	var candidates []Order
+	if cartId := query.Get("cart_id"); cartId != "" || orderNumber > 0 {
+		var orderId string
+		var ok bool
+		if cartId != "" {
+			orderId, ok, err = store.FindByCartId(cartId)
+		} else {
+			orderId, ok, err = store.FindByNumber(orderNumber)
+		}
+		if err == nil && ok {
+			var o Order
+			if o, _, ok, err = store.GetOrder(orderId); err == nil && ok {
+				candidates = append(candidates, o)
+			}
+		}
+	} else {
+		candidates, err = store.ListOrders()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// an explicit status filter also returns the cancelled and returned orders
+	customerId := query.Get("customer_id")
+	var matching []Order
+	for _, o := range candidates {
+		if isDeleted(o) && !includeDeleted {
+			continue
+		}
+		if customerId != "" && o.CustomerId != customerId {
+			continue
+		}
+		if status != "" && o.Status != status {
+			continue
+		}
+		if status == "" && !includeCancelled && (o.Status == OrderCancelled || o.Status == OrderReturned || o.Status == OrderRefunded) {
+			continue
+		}
+		if (!createdAfter.IsZero() && o.CreatedAt.Before(createdAfter)) ||
+			(!createdBefore.IsZero() && !o.CreatedAt.Before(createdBefore)) {
+			continue
+		}
+		matching = append(matching, o)
+	}
+
+	sortOrders(matching, sortField, descending)
+	if cursor != nil {
+		last := cursor.order()
+		offset = sort.Search(len(matching), func(i int) bool {
+			return orderSortsBefore(last, matching[i], sortField, descending)
+		})
+	}
+
+	resp := OrderListResponse{Orders: []CreateOrderResponse{}, Total: len(matching), Limit: limit, Offset: offset}
+	start, end := offset, offset+limit
+	if start > len(matching) {
+		start = len(matching)
+	}
+	if end > len(matching) {
+		end = len(matching)
+	}
+	page := matching[start:end]
+	if end < len(matching) {
+		resp.NextCursor = newOrderCursor(page[len(page)-1], sortField, descending).encode()
+	}
+	resp.Links = pageLinks(r, offset, limit, len(matching), resp.NextCursor)
+	for _, o := range page {
+		orderDetails := newOrderResponse(o)
+		orderDetails.fields = fields
+
+		// Get the item details
+		if fields.wants("items") {
+			_, oItems, _, err := store.GetOrder(o.ID)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+			if err != nil {
+				writeProductError(w, err)
+				return
+			}
+			orderDetails.Items = orderItemsDetailsList
+		}
+
+		resp.Orders = append(resp.Orders, orderDetails)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func GetOrderDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
 
-	o, ok := orders[orderId]
-
-	// Verify if the order is present in the database
-	if !ok {
-		fmt.Println("order with id:", orderId, "does not exist")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(fmt.Sprintf("order with id: %v does not exist", orderId)))
+	fields, err := parseFieldSelection(r)
+	if err != nil {
+		logger.InfoContext(r.Context(), "invalid fields value", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	// Prepare the response
-	orderDetails := CreateOrderResponse{
-		ID:           o.ID,
-		Discount:     o.Discount,
-		Amount:       o.Amount,
-		Status:       o.Status,
-		DispatchedAt: o.DispatchedAt,
-		CreatedAt:    o.CreatedAt,
-		UpdatedAt:    o.UpdatedAt,
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
 	}
 
-	// Get the item details
-	orderItemsDetailsList, err := GetOrderItemsDetailsList(o.ID)
+	o, oItems, ok, err := store.GetOrder(orderId)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		writeStoreError(w, err)
 		return
 	}
-	orderDetails.Items = orderItemsDetailsList
 
-	resp, err := json.Marshal(orderDetails)
-	if err != nil {
-		fmt.Println("error mashiling the response, err:", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// Verify if the order is present in the database
+	if !ok || (isDeleted(o) && !includeDeleted) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+	setOrderETag(w, o)
+
+	// Prepare the response
+	orderDetails := newOrderResponse(o)
+	orderDetails.fields = fields
+
+	// Get the item details
+	if fields.wants("items") {
+		orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+		if err != nil {
+			writeProductError(w, err)
+			return
+		}
+		orderDetails.Items = orderItemsDetailsList
+	}
+
+	writeJSON(w, http.StatusOK, orderDetails)
 }
 
 type UpdateOrderStatusRequest struct {
 	Status OrderStatus `json:"status"`
+	// why the order is cancelled or returned, required with those statuses and rejected with the others
+	ReasonCode string `json:"reason_code,omitempty"`
+	Note       string `json:"note,omitempty"`
+	// the update only applies if the order is still in this status, so two callers moving the same order
+	// don't both succeed
+	ExpectedStatus OrderStatus `json:"expected_status,omitempty"`
 }
 
 func (u *UpdateOrderStatusRequest) Validate() (err error) {
-	switch u.Status {
-	case OrderPlaced, OrderDispatched, OrderCompleted, OrderReturned, OrderCancelled:
-	default:
-		fmt.Println("invalid order status")
+	if !updatableStatus(u.Status) {
 		return errors.New("invalid order status")
 	}
-	return nil
+	if u.ExpectedStatus != "" && !validOrderStatus(u.ExpectedStatus) {
+		return errors.New("invalid expected order status")
+	}
+	u.ReasonCode = strings.ToLower(strings.TrimSpace(u.ReasonCode))
+	return validateStatusReason(u.Status, u.ReasonCode, u.Note)
 }
 
-func UpdateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	orderId := vars["order_id"]
+// minimal response of a status update, carrying only the fields the update can change
+type UpdateOrderStatusResponse struct {
+	ID               string                    `json:"id"`
+	Status           OrderStatus               `json:"status"`
+	DispatchedAt     *Timestamp                `json:"dispatched_at,omitempty"`
+	UpdatedAt        Timestamp                 `json:"updated_at"`
+	Refund           *RefundResponse           `json:"refund,omitempty"`
+	StatusReason     *StatusReason             `json:"status_reason,omitempty"`
+	StatusTimestamps map[OrderStatus]Timestamp `json:"status_timestamps,omitempty"`
+}
 
-	var updateStatusReq UpdateOrderStatusRequest
-	err := json.NewDecoder(r.Body).Decode(&updateStatusReq)
+// wantsMinimalResponse reports whether the client asked for a minimal response,
+// either via the "Prefer: return=minimal" header or the ?minimal=true query param
+func wantsMinimalResponse(r *http.Request) bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.TrimSpace(pref) == "return=minimal" {
+			return true
+		}
+	}
+	minimal, _ := strconv.ParseBool(r.URL.Query().Get("minimal"))
+	return minimal
+}
+
+// statusUpdateError is the response to a status update that failed
+type statusUpdateError struct {
+	status  int
+	message string
+}
+
+// storeStatusUpdateError logs the failed store call, the raw error only goes to the logs
+func storeStatusUpdateError(err error) *statusUpdateError {
+	logger.Error("order store call failed", "err", err)
+	return &statusUpdateError{status: http.StatusInternalServerError, message: "the order store is unavailable"}
+}
+
+// updateOrderStatus moves the order to the status of the request if the transition is allowed, shared by
+// the single and the bulk status updates. The If-Match of the request is checked when checkIfMatch is
+// set, the bulk update has no single ETag to match. It returns the updated order with its items.
+func updateOrderStatus(r *http.Request, store OrderRepository, orderId string, req UpdateOrderStatusRequest, checkIfMatch bool) (Order, []OrderItem, *statusUpdateError) {
+	o, oItems, ok, err := store.GetOrder(orderId)
 	if err != nil {
-		fmt.Println("error unmashiling the request body, err:", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid Request Body"))
-		return
+		return Order{}, nil, storeStatusUpdateError(err)
 	}
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		return Order{}, nil, &statusUpdateError{status: http.StatusNotFound, message: fmt.Sprintf("order with id: %v does not exist", orderId)}
+	}
+	if checkIfMatch {
+		if status, message := ifMatchFailure(r, o); status != 0 {
+			return Order{}, nil, &statusUpdateError{status: status, message: message}
+		}
+	}
+	readVersion := o.Version
 
-	if err = updateStatusReq.Validate(); err != nil {
-		fmt.Println("error validating the request body, err:", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
+	if req.ExpectedStatus != "" && o.Status != req.ExpectedStatus {
+		logger.InfoContext(r.Context(), "order is not in the expected status", "order_id", o.ID, "status", o.Status, "expected_status", req.ExpectedStatus)
+		return Order{}, nil, &statusUpdateError{status: http.StatusConflict, message: fmt.Sprintf("order with id: %v is %v, not %v", o.ID, o.Status, req.ExpectedStatus)}
 	}
 
-	o, ok := orders[orderId]
-	// Verify if the order is present in the database
-	if !ok {
-		fmt.Println("order with id:", orderId, "does not exist")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(fmt.Sprintf("order with id: %v does not exist", orderId)))
-		return
+	// pending orders are still being placed and failed ones never were
+	if o.Status == OrderPending || o.Status == OrderFailed {
+		logger.InfoContext(r.Context(), "order status can't be updated", "order_id", o.ID, "status", o.Status)
+		return Order{}, nil, &statusUpdateError{status: http.StatusConflict, message: fmt.Sprintf("order with id: %v is %v and its status can't be updated", o.ID, o.Status)}
 	}
 
-	// todo validate if the status can be updated to the required status
-	orderStatusMap := map[OrderStatus]int64{
-		OrderPlaced:     1,
-		OrderDispatched: 2,
-		OrderCompleted:  3,
-		OrderReturned:   4,
-		OrderCancelled:  5,
+	if !validOrderStatus(o.Status) {
+		// the stored status is unknown, the order is inconsistent and must not be transitioned
+		logger.ErrorContext(r.Context(), "order has an unknown stored status", "order_id", o.ID, "status", o.Status)
+		return Order{}, nil, &statusUpdateError{status: http.StatusInternalServerError, message: fmt.Sprintf("order with id: %v has an inconsistent status", o.ID)}
 	}
-	currentOrderStatusRank := orderStatusMap[o.Status]
-	newOrderStatusRank := orderStatusMap[updateStatusReq.Status]
+	holdChange := req.Status == OrderOnHold || o.Status == OrderOnHold
 	switch {
-	case newOrderStatusRank <= currentOrderStatusRank:
-		fmt.Println("order status can be updated to a lower or the same status")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("order status can be updated to a lower or the same status"))
-		return
+	case orderStateMachine.IsFinal(o.Status):
+		logger.InfoContext(r.Context(), "order status is final", "order_id", o.ID, "status", o.Status, "requested_status", req.Status)
+		return Order{}, nil, &statusUpdateError{status: http.StatusBadRequest, message: fmt.Sprintf("order status can't be updated once the order is %v", o.Status)}
+
+	case !orderStateMachine.CanTransition(o.Status, req.Status):
+		logger.InfoContext(r.Context(), "order status transition not allowed", "order_id", o.ID, "status", o.Status, "requested_status", req.Status)
+		return Order{}, nil, &statusUpdateError{status: http.StatusBadRequest, message: fmt.Sprintf("order status can't be updated from %v to %v, it can be updated to: %v", o.Status, req.Status, nextStatuses(o.Status))}
+
+	// putting an order on hold or releasing it is up to the admins, the stock stays reserved
+	case holdChange && !isAdmin(r):
+		logger.InfoContext(r.Context(), "order hold changed by a non admin caller", "order_id", o.ID, "status", o.Status, "requested_status", req.Status)
+		return Order{}, nil, &statusUpdateError{status: http.StatusForbidden, message: "only an admin can put an order on hold or release it"}
+	}
 
-	case newOrderStatusRank == 3 && currentOrderStatusRank != 2:
-		fmt.Println("order cannot be completed until it is dispatched")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("order cannot be completed until it is dispatched"))
-		return
+	// update the order status
+	now := clock.Now()
+	previousStatus, previousStatusChangedAt := o.Status, o.StatusChangedAt
+	o.Status = req.Status
+	o.StatusChangedAt = now
+	o.UpdatedAt = now
+	o.SlaBreached = false
+	o.Version++
+	if req.Status == OrderDispatched {
+		o.DispatchedAt = now
+	}
+	if req.ReasonCode != "" {
+		o.StatusReason = &StatusReason{Code: req.ReasonCode, Note: req.Note}
+	}
+	appendStatusHistory(&o, previousStatus, now, requestActor(r))
+	// a return refunds the customer what they paid for the order
+	if req.Status == OrderReturned {
+		o.Refund = &Refund{
+			AmountMinor: o.AmountMinor,
+			Currency:    o.Currency,
+			Reason:      req.ReasonCode,
+			RefundedAt:  Timestamp{now},
+		}
+	}
 
-	case newOrderStatusRank == 4 && currentOrderStatusRank != 3:
-		fmt.Println("order cannot be returned until it is completed")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("order cannot be returned until it is completed"))
-		return
+	// Update the database
+	logger.InfoContext(r.Context(), "updating the order status", "order_id", o.ID, "from", previousStatus, "to", o.Status)
+	err = store.UpdateOrder(o, readVersion)
+	if errors.Is(err, errVersionConflict) {
+		logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+		return Order{}, nil, &statusUpdateError{status: http.StatusConflict, message: fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID)}
+	}
+	if err != nil {
+		return Order{}, nil, storeStatusUpdateError(err)
+	}
+	err = orderStateMachine.Transition(r.Context(), previousStatus, o.Status, orderTransition{order: o, items: oItems, since: previousStatusChangedAt})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "error completing the order status transition", "order_id", o.ID, "err", err)
+	}
+	return o, oItems, nil
+}
+
+func UpdateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
 
-	case newOrderStatusRank == 5 && currentOrderStatusRank > 2:
-		fmt.Println("order cannot be cancelled once it is completed or returned")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("order cannot be cancelled once it is completed or returned"))
+	var updateStatusReq UpdateOrderStatusRequest
+	err := decodeJSONBody(w, r, &updateStatusReq)
+	if err != nil {
+		writeDecodeError(w, r, err)
 		return
 	}
 
-	// update the order status
-	o.Status = updateStatusReq.Status
-	if updateStatusReq.Status == OrderDispatched {
-		o.DispatchedAt = time.Now().UTC().String()
+	if err = updateStatusReq.Validate(); err != nil {
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	// Update the database
-	fmt.Println("updating order:", o.ID, "status from:", o.Status, "to: ", updateStatusReq.Status)
-	orders[o.ID] = o
+	o, oItems, sErr := updateOrderStatus(r, store, orderId, updateStatusReq, true)
+	if sErr != nil {
+		writeJSONError(w, sErr.status, sErr.message)
+		return
+	}
+	setOrderETag(w, o)
+
+	// Skip the item lookups when the client only asked for the changed fields
+	if wantsMinimalResponse(r) {
+		writeJSON(w, http.StatusOK, UpdateOrderStatusResponse{
+			ID:               o.ID,
+			Status:           o.Status,
+			DispatchedAt:     optionalTimestamp(o.DispatchedAt),
+			UpdatedAt:        Timestamp{o.UpdatedAt},
+			Refund:           newRefundResponse(o.Refund),
+			StatusReason:     o.StatusReason,
+			StatusTimestamps: statusTimestamps(o),
+		})
+		return
+	}
 
 	// Prepare the response
-	orderDetails := CreateOrderResponse{
-		ID:           o.ID,
-		Amount:       o.Amount,
-		Status:       o.Status,
-		DispatchedAt: o.DispatchedAt,
-		CreatedAt:    o.CreatedAt,
-		UpdatedAt:    o.UpdatedAt,
-	}
+	orderDetails := newOrderResponse(o)
 
 	// Get the product details
-	orderItemsDetailsList, err := GetOrderItemsDetailsList(o.ID)
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		writeProductError(w, err)
 		return
 	}
 	orderDetails.Items = orderItemsDetailsList
 
-	resp, err := json.Marshal(orderDetails)
-	if err != nil {
-		fmt.Println("error mashiling the response, err:", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+	writeJSON(w, http.StatusOK, orderDetails)
 }
 
 func main() {
-	createProductGRPCClientConnection()
-
-	fmt.Println("Staring rest api server")
+	if err := loadLogConfig(); err != nil {
+		log.Fatalf("invalid logging configuration: %v", err)
+	}
+	migrate := flag.Bool("migrate", false, "apply the schema migrations of the order store and exit")
+	flag.Parse()
+	autoMigrate = getEnvBool("ORDER_STORE_MIGRATE", true)
+	if *migrate {
+		autoMigrate = true
+		if err := loadStoreConfig(); err != nil {
+			log.Fatalf("error migrating the order store: %v", err)
+		}
+		logger.Info("order store migrated")
+		return
+	}
+	if err := createProductGRPCClientConnection(); err != nil {
+		log.Fatalf("product service client could not be created: %v", err)
+	}
+	go monitorProductConnection(productGRPCClient)
+	loadSLAConfig()
+	loadDiscountConfig()
+	if err := loadTransitionConfig(); err != nil {
+		log.Fatalf("invalid order transitions configuration: %v", err)
+	}
+	if err := loadReasonCodeConfig(); err != nil {
+		log.Fatalf("invalid reason code configuration: %v", err)
+	}
+	if err := loadPricingConfig(); err != nil {
+		log.Fatalf("invalid pricing configuration: %v", err)
+	}
+	loadBlockedProducts()
+	loadSafetyStockConfig()
+	loadOrderIDConfig()
+	checkInventoryConflicts = getEnvBool("INVENTORY_CHECK_CONFLICTS", true)
+	productCallTimeout = getEnvDuration("PRODUCT_CALL_TIMEOUT", 3*time.Second)
+	productCallMaxAttempts = getEnvInt("PRODUCT_CALL_MAX_ATTEMPTS", 3)
+	restockRetryAttempts = getEnvInt("RESTOCK_RETRY_ATTEMPTS", restockRetryAttempts)
+	restockRetryDelay = getEnvDuration("RESTOCK_RETRY_DELAY", restockRetryDelay)
+	maxItemDescriptionLength = getEnvInt("ITEM_DESCRIPTION_MAX_LENGTH", 0)
+	maxRequestBodyBytes = int64(getEnvInt("MAX_REQUEST_BODY_BYTES", int(maxRequestBodyBytes)))
+	if err := loadCurrencyConfig(); err != nil {
+		log.Fatalf("invalid currency configuration: %v", err)
+	}
+	if err := loadSearchIndexConfig(); err != nil {
+		log.Fatalf("invalid order search index configuration: %v", err)
+	}
+	if err := loadStoreConfig(); err != nil {
+		log.Fatalf("invalid order store configuration: %v", err)
+	}
+	if err := loadSnapshotConfig(); err != nil {
+		log.Fatalf("invalid memory store snapshot configuration: %v", err)
+	}
+	if err := loadWebhookConfig(); err != nil {
+		log.Fatalf("invalid order events webhook configuration: %v", err)
+	}
+	if err := loadEventPublisherConfig(); err != nil {
+		log.Fatalf("invalid order events publisher configuration: %v", err)
+	}
+	debugMode = getEnvBool("DEBUG", false)
+	itemDetailsMode = getEnv("ORDER_ITEM_DETAILS", "snapshot")
+	includeItemDiscounts = getEnvBool("INCLUDE_ITEM_DISCOUNTS", false)
+	maintenanceMode.Store(getEnvBool("MAINTENANCE_MODE", false))
+	maintenanceRetryAfter = getEnvInt("MAINTENANCE_RETRY_AFTER", 300)
+	listIncludeCancelled = getEnvBool("ORDERS_LIST_INCLUDE_CANCELLED", true)
+	requireIfMatch = getEnvBool("REQUIRE_IF_MATCH", true)
+	maxStoredOrders = getEnvInt("MEMORY_STORE_MAX_ORDERS", 0)
+	go runAmountVerifier()
+	go runSnapshots()
+	go runOrderIndexer()
+	go runSLAScanner()
+
+	logger.Info("starting the rest api server", "addr", ":8081")
 
 	r := mux.NewRouter()
 	r.HandleFunc("/ping", PingHandler).Methods(http.MethodGet)
+	r.HandleFunc("/ready", ReadyHandler).Methods(http.MethodGet)
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/admin/maintenance", adminOnly(GetMaintenanceModeHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/maintenance", adminOnly(UpdateMaintenanceModeHandler)).Methods(http.MethodPut)
+	r.HandleFunc("/admin/blocked-products", adminOnly(GetBlockedProductsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/blocked-products", adminOnly(UpdateBlockedProductsHandler)).Methods(http.MethodPut)
 
 	s := r.PathPrefix("/orders").Subrouter()
-	s.HandleFunc("", PlaceOrderHandler).Methods(http.MethodPost)
+	s.Use(tenantMiddleware, productLookupMiddleware)
+	s.HandleFunc("", maintenanceGuard(PlaceOrderHandler)).Methods(http.MethodPost)
 	s.HandleFunc("", GetOrdersHandler).Methods(http.MethodGet)
+	s.HandleFunc("/sla-breaches", adminOnly(GetSLABreachesHandler)).Methods(http.MethodGet)
+	s.HandleFunc("/batch-get", BatchGetOrdersHandler).Methods(http.MethodPost)
+	s.HandleFunc("/status", maintenanceGuard(BulkUpdateOrderStatusHandler)).Methods(http.MethodPut)
+	s.HandleFunc("/search", SearchOrdersHandler).Methods(http.MethodGet)
 	s.HandleFunc("/{order_id}", GetOrderDetailsHandler).Methods(http.MethodGet)
-	s.HandleFunc("/{order_id}/status", UpdateOrderStatusHandler).Methods(http.MethodPut)
-
-	http.ListenAndServe(":8081", r)
+	s.HandleFunc("/{order_id}", maintenanceGuard(PatchOrderHandler)).Methods(http.MethodPatch)
+	s.HandleFunc("/{order_id}", maintenanceGuard(DeleteOrderHandler)).Methods(http.MethodDelete)
+	s.HandleFunc("/{order_id}/restore", maintenanceGuard(RestoreOrderHandler)).Methods(http.MethodPost)
+	s.HandleFunc("/{order_id}/status", maintenanceGuard(UpdateOrderStatusHandler)).Methods(http.MethodPut)
+	s.HandleFunc("/{order_id}/items", maintenanceGuard(AmendOrderItemsHandler)).Methods(http.MethodPatch)
+	s.HandleFunc("/{order_id}/items/{product_id}", maintenanceGuard(RemoveOrderItemHandler)).Methods(http.MethodDelete)
+	s.HandleFunc("/{order_id}/history", GetOrderHistoryHandler).Methods(http.MethodGet)
+	s.HandleFunc("/{order_id}/receipt", GetOrderReceiptHandler).Methods(http.MethodGet)
+
+	// the recovery wraps the router so a panic anywhere, middlewares included, gets a response, and the
+	// request log wraps the recovery so the panicking requests are logged with their 500
+	srv := &http.Server{Addr: ":8081", Handler: recoverMiddleware(requestLogMiddleware(r))}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("rest api server failed: %v", err)
+		}
+	}()
+
+	// stop taking new requests on SIGINT/SIGTERM and let the in-flight ones finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	logger.Info("shutting down the rest api server", "signal", sig.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("error shutting down the rest api server", "err", err)
+	}
+	// the accepted orders are placed or failed before the stores go away
+	if !waitForAsyncPlacements(ctx) {
+		logger.Error("asynchronous placements still running at shutdown, their orders stay pending")
+	}
+	// the last snapshot keeps the orders placed since the previous one
+	if snapshotPath != "" {
+		if err := writeSnapshot(snapshotPath); err != nil {
+			logger.Error("error writing the memory store snapshot", "path", snapshotPath, "err", err)
+		}
+	}
+	if orderSearchIndex != nil {
+		if err := orderSearchIndex.Close(); err != nil {
+			logger.Error("error closing the order search index", "err", err)
+		}
+	}
+	if productGRPCClient != nil {
+		if err := productGRPCClient.Close(); err != nil {
+			logger.Error("error closing the gRPC connection", "err", err)
+		}
+	}
+	logger.Info("rest api server stopped")
 }
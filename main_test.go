@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// useMemoryStores gives the test its own in-memory stores of the tenants
+func useMemoryStores(t *testing.T) {
+	t.Helper()
+	prev := tenants
+	tenants = make(map[string]OrderRepository)
+	t.Cleanup(func() { tenants = prev })
+}
+
+// useFakeProductClient replaces the product client with a fake one holding the products for the duration
+// of the test
+func useFakeProductClient(t *testing.T, products ...*ProductDetails) *fakeProductClient {
+	t.Helper()
+	fake := newFakeProductClient(products...)
+	prev := productClient
+	productClient = fake
+	t.Cleanup(func() { productClient = prev })
+	return fake
+}
+
+// newTenantRequest returns a request of the tenant t1 with the body and the route variables
+func newTenantRequest(method, target, body string, vars map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, "t1"))
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	return req
+}
+
+func TestConcurrentPlacementAndStatusUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		orders  int
+		writers int
+	}{
+		{"few orders, many writers", 2, 16},
+		{"many orders, few writers", 16, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+
+			var wg sync.WaitGroup
+			placed := make(chan CreateOrderResponse, tt.orders)
+			for i := 0; i < tt.orders; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+						`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1}]}`, nil))
+					if rec.Code != http.StatusOK {
+						t.Errorf("placing an order answered %v: %s", rec.Code, rec.Body)
+						return
+					}
+					var o CreateOrderResponse
+					if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+						t.Errorf("decoding the placed order failed: %v", err)
+						return
+					}
+					placed <- o
+				}()
+			}
+			wg.Wait()
+			close(placed)
+
+			numbers := make(map[int64]bool)
+			var orderIds []string
+			etags := make(map[string]string)
+			for o := range placed {
+				if numbers[o.OrderNumber] {
+					t.Errorf("order number %v was given to two orders", o.OrderNumber)
+				}
+				numbers[o.OrderNumber] = true
+				orderIds = append(orderIds, o.ID)
+				etags[o.ID] = orderETag(Order{Version: o.Version})
+			}
+			if len(orderIds) != tt.orders {
+				t.Fatalf("%v orders were placed, want %v", len(orderIds), tt.orders)
+			}
+
+			// the writers of an order race to confirm or to cancel it from the version it was placed at, only
+			// the first of them wins
+			type result struct {
+				orderId string
+				status  OrderStatus
+				code    int
+			}
+			results := make(chan result, tt.orders*tt.writers)
+			for _, orderId := range orderIds {
+				for i := 0; i < tt.writers; i++ {
+					status, body := OrderConfirmed, `{"status":"confirmed"}`
+					if i%2 == 1 {
+						status, body = OrderCancelled, `{"status":"cancelled","reason_code":"customer_request"}`
+					}
+					wg.Add(1)
+					go func(orderId string) {
+						defer wg.Done()
+						req := newTenantRequest(http.MethodPut, "/orders/"+orderId, body, map[string]string{"order_id": orderId})
+						req.Header.Set("If-Match", etags[orderId])
+						rec := httptest.NewRecorder()
+						UpdateOrderStatusHandler(rec, req)
+						results <- result{orderId, status, rec.Code}
+					}(orderId)
+				}
+			}
+			wg.Wait()
+			close(results)
+
+			winners := make(map[string]OrderStatus)
+			for res := range results {
+				switch {
+				case res.code == http.StatusOK:
+					if _, ok := winners[res.orderId]; ok {
+						t.Errorf("order %v was updated by more than one writer", res.orderId)
+					}
+					winners[res.orderId] = res.status
+				case res.code != http.StatusPreconditionFailed && res.code != http.StatusConflict:
+					t.Errorf("a losing status update of order %v answered %v", res.orderId, res.code)
+				}
+			}
+
+			store := tenantStore("t1")
+			var cancelled int64
+			for _, orderId := range orderIds {
+				o, _, _, err := store.GetOrder(orderId)
+				if err != nil {
+					t.Fatalf("reading order %v failed: %v", orderId, err)
+				}
+				if o.Status != winners[orderId] {
+					t.Errorf("order %v is %v, want %v set by the winning writer", orderId, o.Status, winners[orderId])
+				}
+				if len(o.History) != 2 {
+					t.Errorf("order %v has %v history entries, want 2", orderId, len(o.History))
+				}
+				if o.Status == OrderCancelled {
+					cancelled++
+				}
+			}
+			// the cancelled orders gave their stock back
+			p1, err := fake.GetProductDetails(context.Background(), "p1")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if want := int64(100-tt.orders) + cancelled; p1.Quantity != want {
+				t.Errorf("product quantity = %v, want %v", p1.Quantity, want)
+			}
+		})
+	}
+}
+
+func TestConcurrentPlacementAndListing(t *testing.T) {
+	tests := []struct {
+		name    string
+		placers int
+		listers int
+	}{
+		{"mostly placements", 40, 10},
+		{"even", 25, 25},
+		{"mostly listings", 10, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+
+			// every placement takes the same product, none of them may fail on the inventory taken by the
+			// others or overwrite it
+			var wg sync.WaitGroup
+			for i := 0; i < tt.placers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+						`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`, nil))
+					if rec.Code != http.StatusOK {
+						t.Errorf("placing an order answered %v: %s", rec.Code, rec.Body)
+					}
+				}()
+			}
+			for i := 0; i < tt.listers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					GetOrdersHandler(rec, newTenantRequest(http.MethodGet, "/orders", "", nil))
+					if rec.Code != http.StatusOK {
+						t.Errorf("listing the orders answered %v: %s", rec.Code, rec.Body)
+					}
+				}()
+			}
+			wg.Wait()
+
+			rec := httptest.NewRecorder()
+			GetOrdersHandler(rec, newTenantRequest(http.MethodGet, "/orders?limit=100", "", nil))
+			var list OrderListResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Fatalf("decoding the order list failed: %v: %s", err, rec.Body)
+			}
+			ids := make(map[string]bool)
+			for _, o := range list.Orders {
+				ids[o.ID] = true
+			}
+			if list.Total != tt.placers || len(ids) != tt.placers {
+				t.Errorf("listed %v distinct orders of a total of %v, want %v", len(ids), list.Total, tt.placers)
+			}
+			p4, err := fake.GetProductDetails(context.Background(), "p4")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if want := int64(100 - tt.placers); p4.Quantity != want {
+				t.Errorf("product quantity = %v, want %v", p4.Quantity, want)
+			}
+		})
+	}
+}
+
+func TestConcurrentPlacementOrderNumbers(t *testing.T) {
+	const placers, ordersEach = 8, 5
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+
+	// every placer places its orders one after the other, the numbers it gets must increase while the
+	// other placers race it
+	var wg sync.WaitGroup
+	numbers := make([][]int64, placers)
+	for i := 0; i < placers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < ordersEach; j++ {
+				rec := httptest.NewRecorder()
+				PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders",
+					`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`, nil))
+				var o CreateOrderResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+					t.Errorf("placing an order answered %v: %s", rec.Code, rec.Body)
+					return
+				}
+				numbers[i] = append(numbers[i], o.OrderNumber)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var all []int64
+	for i, nums := range numbers {
+		for j := 1; j < len(nums); j++ {
+			if nums[j] <= nums[j-1] {
+				t.Errorf("placer %v got order numbers %v, want them strictly increasing", i, nums)
+				break
+			}
+		}
+		all = append(all, nums...)
+	}
+	// no number is skipped or handed out twice
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	for i, n := range all {
+		if n != int64(i+1) {
+			t.Fatalf("order numbers %v, want 1 to %v", all, placers*ordersEach)
+		}
+	}
+}
+
+// saveTestOrders saves the orders of tenant t1 in its store, each with one p1 item
+func saveTestOrders(t *testing.T, orders ...Order) {
+	t.Helper()
+	store := tenantStore("t1")
+	for _, o := range orders {
+		o.TenantId = "t1"
+		if o.Version == 0 {
+			o.Version = 1
+		}
+		if _, err := store.SaveOrder(o, []OrderItem{{ProductId: "p1", ProductQuantity: 1, OrderId: o.ID}}); err != nil {
+			t.Fatalf("saving order %v failed: %v", o.ID, err)
+		}
+	}
+}
+
+// listTestOrders lists the orders of tenant t1 and returns their ids, sorted
+func listTestOrders(t *testing.T, target string) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetOrdersHandler(rec, newTenantRequest(http.MethodGet, target, "", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing %v answered %v: %s", target, rec.Code, rec.Body)
+	}
+	var list OrderListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decoding the order list failed: %v", err)
+	}
+	var ids []string
+	for _, o := range list.Orders {
+		ids = append(ids, o.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestListingCancelledOrders(t *testing.T) {
+	tests := []struct {
+		name    string
+		include bool
+		target  string
+		want    string
+	}{
+		{"included by default", true, "/orders", "o1,o2,o3,o4"},
+		{"excluded by default", false, "/orders", "o1"},
+		{"included on request", false, "/orders?include_cancelled=true", "o1,o2,o3,o4"},
+		{"excluded on request", true, "/orders?include_cancelled=false", "o1"},
+		{"status filter overrides the default", false, "/orders?status=cancelled", "o2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			prev := listIncludeCancelled
+			listIncludeCancelled = tt.include
+			t.Cleanup(func() { listIncludeCancelled = prev })
+			saveTestOrders(t,
+				Order{ID: "o1", Status: OrderPlaced},
+				Order{ID: "o2", Status: OrderCancelled},
+				Order{ID: "o3", Status: OrderReturned},
+				Order{ID: "o4", Status: OrderRefunded},
+			)
+
+			if got := strings.Join(listTestOrders(t, tt.target), ","); got != tt.want {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// placeTestOrder places an order of tenant t1 with the request body
+func placeTestOrder(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders", body, nil))
+	return rec
+}
+
+func TestPlacementWithCartId(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	body := `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","cart_id":"cart-1","items":[{"product_id":"p4","quantity":1}]}`
+
+	rec := placeTestOrder(t, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+	}
+	var o CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+		t.Fatalf("decoding the order failed: %v", err)
+	}
+	if o.CartId != "cart-1" {
+		t.Errorf("cart id = %q, want cart-1", o.CartId)
+	}
+	saveTestOrders(t, Order{ID: "o2", CartId: "cart-2", Status: OrderPlaced})
+
+	if got := strings.Join(listTestOrders(t, "/orders?cart_id=cart-1"), ","); got != o.ID {
+		t.Errorf("looking up cart-1 listed %v, want %v", got, o.ID)
+	}
+	if got := listTestOrders(t, "/orders?cart_id=cart-404"); len(got) != 0 {
+		t.Errorf("looking up an unknown cart listed %v", got)
+	}
+
+	// a cart is only ordered once
+	if rec := placeTestOrder(t, body); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), o.ID) {
+		t.Errorf("placing the cart again answered %v: %s, want a 409 naming %v", rec.Code, rec.Body, o.ID)
+	}
+	if rec := placeTestOrder(t, strings.Replace(body, "cart-1", "cart 1", 1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("placing with an invalid cart id answered %v, want 400", rec.Code)
+	}
+}
+
+func TestMinimalStatusUpdateResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		prefer      string
+		wantMinimal bool
+	}{
+		{"full by default", "/orders/o1", "", false},
+		{"prefer header", "/orders/o1", "return=minimal", true},
+		{"query param", "/orders/o1?minimal=true", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			productClient = &countingProductClient{fake}
+			saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+
+			req := newTenantRequest(http.MethodPut, tt.target, `{"status":"confirmed"}`, map[string]string{"order_id": "o1"})
+			req = req.WithContext(context.WithValue(req.Context(), lookupCounterKey{}, new(int64)))
+			req.Header.Set("If-Match", orderETag(Order{Version: 1}))
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rec := httptest.NewRecorder()
+			UpdateOrderStatusHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("updating the status answered %v: %s", rec.Code, rec.Body)
+			}
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding the response failed: %v", err)
+			}
+			_, hasItems := body["items"]
+			lookups := productLookups(req.Context())
+			if tt.wantMinimal && (hasItems || lookups != 0) {
+				t.Errorf("minimal response made %v product lookups and has items: %v", lookups, hasItems)
+			}
+			if !tt.wantMinimal && (!hasItems || lookups == 0) {
+				t.Errorf("full response made %v product lookups and has items: %v", lookups, hasItems)
+			}
+			if string(body["id"]) != `"o1"` || string(body["status"]) != `"confirmed"` || body["updated_at"] == nil {
+				t.Errorf("response = %s, want the id, the new status and the update time", rec.Body)
+			}
+		})
+	}
+}
+
+func TestPartialPlacement(t *testing.T) {
+	tests := []struct {
+		name       string
+		partialOk  bool
+		wantStatus int
+		wantP1     int64
+	}{
+		{"all or nothing", false, http.StatusNotFound, 100},
+		{"partial ok", true, http.StatusOK, 98},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			fake.FailGet("p2", errors.New("product service unavailable"))
+			products := fakeSampleProducts()
+			products[2].Quantity = 1
+			fake.SetProduct(products[2])
+
+			rec := placeTestOrder(t, fmt.Sprintf(`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","partial_ok":%v,"items":[`+
+				`{"product_id":"p1","quantity":2},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":5}]}`, tt.partialOk))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("placing the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.partialOk {
+				var o CreateOrderResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+					t.Fatalf("decoding the order failed: %v", err)
+				}
+				want := []SkippedOrderItem{
+					{ProductId: "p2", Reason: "product details could not be fetched"},
+					{ProductId: "p3", Reason: "not enough inventory"},
+				}
+				if len(o.Items) != 1 || o.Items[0].ID != "p1" || !reflect.DeepEqual(o.SkippedItems, want) {
+					t.Errorf("items = %+v skipped %+v, want p1 with p2 and p3 skipped", o.Items, o.SkippedItems)
+				}
+			}
+
+			// only the placed items take stock
+			for productId, want := range map[string]int64{"p1": tt.wantP1, "p3": 1} {
+				p, err := fake.GetProductDetails(context.Background(), productId)
+				if err != nil {
+					t.Fatalf("reading the product failed: %v", err)
+				}
+				if p.Quantity != want {
+					t.Errorf("%v quantity = %v, want %v", productId, p.Quantity, want)
+				}
+			}
+		})
+	}
+}
+
+func TestItemSnapshotsAfterCatalogChanges(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		target       string
+		wantCategory string
+		wantPrice    float64
+	}{
+		{"snapshot", "snapshot", "/orders/o", "premium", 250},
+		{"refresh", "snapshot", "/orders/o?refresh=true", "regular", 999},
+		{"live mode", "live", "/orders/o", "regular", 999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			prev := itemDetailsMode
+			itemDetailsMode = tt.mode
+			t.Cleanup(func() { itemDetailsMode = prev })
+
+			rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[`+
+				`{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}]}`)
+			var placed CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &placed); rec.Code != http.StatusOK || err != nil {
+				t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+			}
+			// the watch leaves the premium range after the order was placed
+			p1, _ := fake.GetProductDetails(context.Background(), "p1")
+			p1.Category, p1.Price = "regular", 999
+			fake.SetProduct(p1)
+
+			rec = httptest.NewRecorder()
+			GetOrderDetailsHandler(rec, newTenantRequest(http.MethodGet, tt.target, "", map[string]string{"order_id": placed.ID}))
+			var o CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+				t.Fatalf("reading the order answered %v: %s", rec.Code, rec.Body)
+			}
+			if o.Items[0].ID != "p1" || o.Items[0].Category != tt.wantCategory || o.Items[0].Price != tt.wantPrice {
+				t.Errorf("p1 = %+v, want %v at %v", o.Items[0], tt.wantCategory, tt.wantPrice)
+			}
+			// the order keeps the discount and the amount it was placed with
+			if o.Discount != 10 || o.Amount != 405 {
+				t.Errorf("discount %v%% for %v, want 10%% for 405", o.Discount, o.Amount)
+			}
+
+			// amending the order prices the items already in it with their snapshots
+			req := newTenantRequest(http.MethodPut, "/orders/"+placed.ID+"/items", `{"items":[{"product_id":"p2","quantity":2}]}`, map[string]string{"order_id": placed.ID})
+			req.Header.Set("If-Match", orderETag(Order{Version: placed.Version}))
+			rec = httptest.NewRecorder()
+			AmendOrderItemsHandler(rec, req)
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+				t.Fatalf("amending the order answered %v: %s", rec.Code, rec.Body)
+			}
+			if o.Discount != 10 || o.Amount != 513 {
+				t.Errorf("amended discount %v%% for %v, want 10%% for 513", o.Discount, o.Amount)
+			}
+		})
+	}
+}
+
+func TestStatusUpdateOfAnUnknownStoredStatus(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	saveTestOrders(t, Order{ID: "o1", Status: OrderStatus("bogus")})
+
+	req := newTenantRequest(http.MethodPut, "/orders/o1", `{"status":"confirmed"}`, map[string]string{"order_id": "o1"})
+	req.Header.Set("If-Match", orderETag(Order{Version: 1}))
+	rec := httptest.NewRecorder()
+	UpdateOrderStatusHandler(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "inconsistent status") {
+		t.Errorf("updating the order answered %v: %s, want a 500 for its inconsistent status", rec.Code, rec.Body)
+	}
+	if o, _, _, _ := tenantStore("t1").GetOrder("o1"); o.Status != "bogus" || o.Version != 1 {
+		t.Errorf("the order was changed to %v at version %v", o.Status, o.Version)
+	}
+}
+
+func TestStatusUpdateRefreshesUpdatedAt(t *testing.T) {
+	placedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := useFakeClock(t, placedAt)
+	useFakeProductClient(t, fakeSampleProducts()...)
+
+	rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`)
+	var o CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+	}
+	if !o.CreatedAt.Equal(placedAt) || !o.UpdatedAt.Equal(placedAt) {
+		t.Errorf("placed order created at %v and updated at %v, want both %v", o.CreatedAt, o.UpdatedAt, placedAt)
+	}
+
+	dispatchedAt := placedAt.Add(time.Minute)
+	fake.now = dispatchedAt
+	req := newTenantRequest(http.MethodPut, "/orders/"+o.ID, `{"status":"dispatched"}`, map[string]string{"order_id": o.ID})
+	req.Header.Set("If-Match", orderETag(Order{Version: o.Version}))
+	rec = httptest.NewRecorder()
+	UpdateOrderStatusHandler(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("dispatching the order answered %v: %s", rec.Code, rec.Body)
+	}
+	if !o.UpdatedAt.Equal(dispatchedAt) || !o.CreatedAt.Equal(placedAt) || o.DispatchedAt == nil || !o.DispatchedAt.Equal(dispatchedAt) {
+		t.Errorf("dispatched order created at %v, updated at %v and dispatched at %v, want the update and the dispatch at %v",
+			o.CreatedAt, o.UpdatedAt, o.DispatchedAt, dispatchedAt)
+	}
+}
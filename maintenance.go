@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// maintenanceMode freezes order writes while keeping reads available. Set at startup by MAINTENANCE_MODE
+// and toggled at runtime via PUT /admin/maintenance.
+var maintenanceMode atomic.Bool
+
+// seconds clients are told to wait before retrying a write rejected during maintenance, set by MAINTENANCE_RETRY_AFTER
+var maintenanceRetryAfter = 300
+
+// maintenanceGuard rejects the write handler with a 503 while the service is in maintenance mode
+func maintenanceGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceMode.Load() {
+			logger.InfoContext(r.Context(), "rejecting the write during maintenance", "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+			writeJSONError(w, http.StatusServiceUnavailable, "service is under maintenance, order changes are temporarily disabled")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type MaintenanceModeRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type MaintenanceModeResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+func GetMaintenanceModeHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, MaintenanceModeResponse{Enabled: maintenanceMode.Load()})
+}
+
+func UpdateMaintenanceModeHandler(w http.ResponseWriter, r *http.Request) {
+	var mReq MaintenanceModeRequest
+	if err := decodeJSONBody(w, r, &mReq); err != nil || mReq.Enabled == nil {
+		logger.InfoContext(r.Context(), "error unmarshaling the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid Request Body")
+		return
+	}
+
+	maintenanceMode.Store(*mReq.Enabled)
+	logger.InfoContext(r.Context(), "maintenance mode set", "enabled", *mReq.Enabled)
+	writeJSON(w, http.StatusOK, MaintenanceModeResponse{Enabled: *mReq.Enabled})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	t.Cleanup(func() { maintenanceMode.Store(false) })
+	saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+	vars := map[string]string{"order_id": "o1"}
+
+	setMaintenance := func(body string) {
+		rec := httptest.NewRecorder()
+		UpdateMaintenanceModeHandler(rec, newTenantRequest(http.MethodPut, "/admin/maintenance", body, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("toggling the maintenance mode answered %v: %s", rec.Code, rec.Body)
+		}
+	}
+	writes := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     func() *http.Request
+	}{
+		{"place order", maintenanceGuard(PlaceOrderHandler), func() *http.Request {
+			return newTenantRequest(http.MethodPost, "/orders", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`, nil)
+		}},
+		{"update status", maintenanceGuard(UpdateOrderStatusHandler), func() *http.Request {
+			req := newTenantRequest(http.MethodPut, "/orders/o1/status", `{"status":"confirmed"}`, vars)
+			req.Header.Set("If-Match", "*")
+			return req
+		}},
+	}
+	reads := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     func() *http.Request
+	}{
+		{"list orders", GetOrdersHandler, func() *http.Request { return newTenantRequest(http.MethodGet, "/orders", "", nil) }},
+		{"order details", GetOrderDetailsHandler, func() *http.Request { return newTenantRequest(http.MethodGet, "/orders/o1", "", vars) }},
+	}
+
+	setMaintenance(`{"enabled":true}`)
+	for _, w := range writes {
+		rec := httptest.NewRecorder()
+		w.handler(rec, w.req())
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != strconv.Itoa(maintenanceRetryAfter) {
+			t.Errorf("%v during maintenance answered %v with Retry-After %q, want 503", w.name, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	for _, r := range reads {
+		rec := httptest.NewRecorder()
+		r.handler(rec, r.req())
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v during maintenance answered %v, want 200", r.name, rec.Code)
+		}
+	}
+	if o, _, _, _ := tenantStore("t1").GetOrder("o1"); o.Status != OrderPlaced {
+		t.Errorf("the order is %v, the status update went through", o.Status)
+	}
+
+	setMaintenance(`{"enabled":false}`)
+	for _, w := range writes {
+		rec := httptest.NewRecorder()
+		w.handler(rec, w.req())
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v after maintenance answered %v: %s", w.name, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// discount types used to label the discount metrics
+const (
+	DiscountPremium = "premium"
+	DiscountCoupon  = "coupon"
+	DiscountBulk    = "bulk"
+)
+
+var (
+	discountedOrdersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_discount_applied_total",
+		Help: "Number of placed orders that received a discount, by discount type.",
+	}, []string{"type"})
+
+	discountAmount = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orders_discount_amount",
+		Help:    "Distribution of the discount amount given on placed orders, by discount type.",
+		Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+	}, []string{"type"})
+
+	statusDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "orders_status_duration_seconds",
+		Help: "Time orders spent in a status before moving to the next one, by the status left and the status entered.",
+		// 1 minute up to about 6 months
+		Buckets: prometheus.ExponentialBuckets(60, 4, 10),
+	}, []string{"from", "to"})
+
+	ordersPlacedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orders_placed_total",
+		Help: "Number of orders placed, including the ones placed on hold.",
+	})
+
+	ordersFinalStatusTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_final_status_total",
+		Help: "Number of orders that reached a final status, by status.",
+	}, []string{"status"})
+
+	productCallFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_product_grpc_call_failures_total",
+		Help: "Number of failed gRPC calls to the product service, by method.",
+	}, []string{"method"})
+
+	productCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orders_product_grpc_call_duration_seconds",
+		Help:    "Latency of the gRPC calls to the product service, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(discountedOrdersTotal, discountAmount, statusDuration, ordersPlacedTotal, ordersFinalStatusTotal,
+		productCallFailures, productCallDuration)
+}
+
+// recordDiscount is called for every discount of an order once the order is placed
+func recordDiscount(discountType string, amount float64) {
+	discountedOrdersTotal.WithLabelValues(discountType).Inc()
+	discountAmount.WithLabelValues(discountType).Observe(amount)
+}
+
+// recordStatusTransition is called when an order moves from one status to the next, with the time
+// it entered the status it leaves
+func recordStatusTransition(from, to OrderStatus, since, now time.Time) {
+	statusDuration.WithLabelValues(string(from), string(to)).Observe(now.Sub(since).Seconds())
+	recordFinalStatus(to)
+}
+
+// recordFinalStatus counts the orders entering a status they don't leave
+func recordFinalStatus(status OrderStatus) {
+	switch status {
+	case OrderCompleted, OrderReturned, OrderRefunded, OrderCancelled, OrderFailed:
+		ordersFinalStatusTotal.WithLabelValues(string(status)).Inc()
+	}
+}
+
+// recordProductCall is called after every gRPC call to the product service with the time it started
+func recordProductCall(method string, start time.Time, err error) {
+	productCallDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		productCallFailures.WithLabelValues(method).Inc()
+	}
+}
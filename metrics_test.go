@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDiscountMetricsOfPlacedOrdersOnly(t *testing.T) {
+	// three premium products qualify the order for the premium discount
+	tests := []struct {
+		name       string
+		body       string
+		failUpdate bool
+		wantStatus int
+		wantCount  float64
+	}{
+		{"placed", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}]}`, false, http.StatusOK, 1},
+		{"rejected over the max total", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}],"max_total":1}`, false, http.StatusUnprocessableEntity, 0},
+		{"rolled back", `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":1},{"product_id":"p2","quantity":1},{"product_id":"p3","quantity":1}]}`, true, http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			if tt.failUpdate {
+				fake.FailUpdate("p3", errors.New("inventory update failed"))
+			}
+			counted := testutil.ToFloat64(discountedOrdersTotal.WithLabelValues(DiscountPremium))
+
+			rec := httptest.NewRecorder()
+			PlaceOrderHandler(rec, newTenantRequest(http.MethodPost, "/orders", tt.body, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("placing the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := testutil.ToFloat64(discountedOrdersTotal.WithLabelValues(DiscountPremium)) - counted; got != tt.wantCount {
+				t.Errorf("premium discounts counted = %v, want %v", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+// statusDurationSamples returns the number of durations recorded between the statuses and their sum
+func statusDurationSamples(t *testing.T, from, to OrderStatus) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := statusDuration.WithLabelValues(string(from), string(to)).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("reading the status durations failed: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestStatusDurationMetrics(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := useFakeClock(t, now)
+	useFakeProductClient(t, fakeSampleProducts()...)
+
+	rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`)
+	var o CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+	}
+
+	// the time spent in a status is recorded when the order leaves it
+	steps := []struct {
+		after      time.Duration
+		from, to   OrderStatus
+		wantStatus int
+		want       time.Duration
+	}{
+		{2 * time.Hour, OrderPlaced, OrderConfirmed, http.StatusOK, 2 * time.Hour},
+		{30 * time.Minute, OrderConfirmed, OrderDispatched, http.StatusOK, 30 * time.Minute},
+		// a rejected update doesn't move the order, nothing is recorded
+		{time.Hour, OrderDispatched, OrderPlaced, http.StatusBadRequest, 0},
+		{23 * time.Hour, OrderDispatched, OrderCompleted, http.StatusOK, 24 * time.Hour},
+	}
+	for _, step := range steps {
+		count, sum := statusDurationSamples(t, step.from, step.to)
+		fake.now = fake.now.Add(step.after)
+
+		req := newTenantRequest(http.MethodPut, "/orders/"+o.ID, `{"status":"`+string(step.to)+`"}`, map[string]string{"order_id": o.ID})
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		UpdateOrderStatusHandler(rec, req)
+		if rec.Code != step.wantStatus {
+			t.Fatalf("moving the order to %v answered %v, want %v: %s", step.to, rec.Code, step.wantStatus, rec.Body)
+		}
+
+		gotCount, gotSum := statusDurationSamples(t, step.from, step.to)
+		wantCount := uint64(0)
+		if step.wantStatus == http.StatusOK {
+			wantCount = 1
+		}
+		if gotCount-count != wantCount || gotSum-sum != step.want.Seconds() {
+			t.Errorf("%v -> %v recorded %v durations of %vs, want %v of %vs", step.from, step.to, gotCount-count, gotSum-sum, wantCount, step.want.Seconds())
+		}
+	}
+}
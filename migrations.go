@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// autoMigrate applies the pending schema migrations of the SQL stores on startup, set by
+// ORDER_STORE_MIGRATE. Without it the service refuses to start on a database that isn't migrated, the
+// migrations are then applied with the -migrate flag.
+var autoMigrate = true
+
+// migration is a versioned change to the schema of a SQL store. The migrations of a store are applied
+// in version order, each in its own transaction, and recorded in the schema_migrations table. A released
+// migration must never change, a schema change is always a new migration.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// execStatements returns a migration step running the statements in order
+func execStatements(stmts ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrationSteps returns a migration step running the steps in order
+func migrationSteps(steps ...func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, step := range steps {
+			if err := step(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// convertToMinorUnits returns a migration step filling the minor units columns of the orders and the
+// items, and the discounts and the refunds stored as JSON, from the amounts in units of the currency of
+// the order. The rows are read before they are updated, the drivers don't allow updating while a query
+// is open.
+func convertToMinorUnits(dialect migrationDialect) func(tx *sql.Tx) error {
+	p := dialect.placeholder
+	return func(tx *sql.Tx) error {
+		type storedOrder struct {
+			tenantId, id, currency, discounts, refund string
+			amount                                    float64
+		}
+		var orders []storedOrder
+		rows, err := tx.Query(`SELECT tenant_id, id, currency, amount, discounts, refund FROM orders`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var o storedOrder
+			if err := rows.Scan(&o.tenantId, &o.id, &o.currency, &o.amount, &o.discounts, &o.refund); err != nil {
+				rows.Close()
+				return err
+			}
+			orders = append(orders, o)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, o := range orders {
+			currency := amountCurrency(Order{Currency: o.currency})
+			var legacyDiscounts []struct {
+				Type    string  `json:"type"`
+				Code    string  `json:"code"`
+				Percent int64   `json:"percent"`
+				Amount  float64 `json:"amount"`
+			}
+			if err := json.Unmarshal([]byte(o.discounts), &legacyDiscounts); err != nil {
+				return fmt.Errorf("invalid discounts of order: %v, err: %w", o.id, err)
+			}
+			discounts := []AppliedDiscount{}
+			for _, d := range legacyDiscounts {
+				discounts = append(discounts, AppliedDiscount{Type: d.Type, Code: d.Code, Percent: d.Percent, AmountMinor: toMinorUnits(d.Amount, currency)})
+			}
+			discountsJSON, err := json.Marshal(discounts)
+			if err != nil {
+				return err
+			}
+			// the refund reads its legacy amount itself, in its own currency
+			refund := o.refund
+			if refund != "" {
+				var rf Refund
+				if err := json.Unmarshal([]byte(refund), &rf); err != nil {
+					return fmt.Errorf("invalid refund of order: %v, err: %w", o.id, err)
+				}
+				data, err := json.Marshal(rf)
+				if err != nil {
+					return err
+				}
+				refund = string(data)
+			}
+			_, err = tx.Exec(fmt.Sprintf(`UPDATE orders SET amount_minor = %v, discounts = %v, refund = %v WHERE tenant_id = %v AND id = %v`,
+				p(1), p(2), p(3), p(4), p(5)),
+				toMinorUnits(o.amount, currency), string(discountsJSON), refund, o.tenantId, o.id)
+			if err != nil {
+				return err
+			}
+		}
+
+		type storedItem struct {
+			tenantId, orderId   string
+			position            int
+			unitPrice, discount float64
+			currency            sql.NullString
+		}
+		var items []storedItem
+		rows, err = tx.Query(`SELECT i.tenant_id, i.order_id, i.position, i.unit_price, i.discount, o.currency FROM order_items i
+			LEFT JOIN orders o ON o.tenant_id = i.tenant_id AND o.id = i.order_id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var item storedItem
+			if err := rows.Scan(&item.tenantId, &item.orderId, &item.position, &item.unitPrice, &item.discount, &item.currency); err != nil {
+				rows.Close()
+				return err
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			currency := amountCurrency(Order{Currency: item.currency.String})
+			_, err := tx.Exec(fmt.Sprintf(`UPDATE order_items SET unit_price_minor = %v, discount_minor = %v WHERE tenant_id = %v AND order_id = %v AND position = %v`,
+				p(1), p(2), p(3), p(4), p(5)),
+				toMinorUnits(item.unitPrice, currency), toMinorUnits(item.discount, currency), item.tenantId, item.orderId, item.position)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrationDialect holds what differs between the databases the migrations run on
+type migrationDialect struct {
+	name string
+	// lock is run at the start of every migration transaction to keep instances starting together from
+	// applying the same migration, empty if the database serializes the writers itself
+	lock string
+	// placeholder returns the n-th query placeholder, counted from 1
+	placeholder func(n int) string
+}
+
+var sqliteDialect = migrationDialect{
+	name:        "sqlite",
+	placeholder: func(int) string { return "?" },
+}
+
+var postgresDialect = migrationDialect{
+	name:        "postgres",
+	lock:        fmt.Sprintf(`SELECT pg_advisory_xact_lock(%d)`, postgresSchemaLock),
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+}
+
+// migrateSchema brings the schema of the database up to the last migration, or with autoMigrate off
+// only checks that it is. A database migrated by a newer version of the service is rejected.
+func migrateSchema(db *sql.DB, dialect migrationDialect, migrations []migration) error {
+	if err := createMigrationsTable(db, dialect); err != nil {
+		return fmt.Errorf("error creating the schema_migrations table, err: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("the %v schema is at version: %v, newer than the latest known version: %v", dialect.name, current, latest)
+	}
+	if current == latest {
+		logger.Info("order store schema is up to date", "dialect", dialect.name, "version", current)
+		return nil
+	}
+	if !autoMigrate {
+		return fmt.Errorf("the %v schema is at version: %v, version: %v is required, run the service with -migrate", dialect.name, current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		applied, err := applyMigration(db, dialect, m)
+		if err != nil {
+			return fmt.Errorf("error applying the %v migration: %v (%v), err: %w", dialect.name, m.version, m.description, err)
+		}
+		if applied {
+			logger.Info("applied the order store migration", "dialect", dialect.name, "version", m.version, "description", m.description)
+		}
+	}
+	return nil
+}
+
+// createMigrationsTable creates the table recording the applied migrations, under the lock as well
+func createMigrationsTable(db *sql.DB, dialect migrationDialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if dialect.lock != "" {
+		if _, err := tx.Exec(dialect.lock); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER NOT NULL PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the version of the last applied migration, 0 for a new database
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading the schema version, err: %w", err)
+	}
+	return version, nil
+}
+
+// applyMigration applies the migration and records it, false if another instance applied it first
+func applyMigration(db *sql.DB, dialect migrationDialect, m migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if dialect.lock != "" {
+		if _, err := tx.Exec(dialect.lock); err != nil {
+			return false, err
+		}
+	}
+	var applied bool
+	err = tx.QueryRow(`SELECT COUNT(*) > 0 FROM schema_migrations WHERE version = `+dialect.placeholder(1), m.version).Scan(&applied)
+	if err != nil || applied {
+		return false, err
+	}
+
+	if err := m.up(tx); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (%v, %v, %v)`,
+		dialect.placeholder(1), dialect.placeholder(2), dialect.placeholder(3)),
+		m.version, m.description, formatTimestamp(clock.Now()))
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
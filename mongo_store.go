@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoTimeout bounds every call to MongoDB, set by ORDER_STORE_TIMEOUT
+var mongoTimeout = 5 * time.Second
+
+// mongoOrder is the document of an order, the items are embedded in it
+type mongoOrder struct {
+	TenantId    string `bson:"tenant_id"`
+	ID          string `bson:"id"`
+	Discount    int64  `bson:"discount"`
+	AmountMinor int64  `bson:"amount_minor"`
+	// the amount of the orders stored before the amounts were in minor units, unset by the updates
+	Amount          float64           `bson:"amount,omitempty"`
+	Currency        string            `bson:"currency"`
+	Status          string            `bson:"status"`
+	DispatchedAt    string            `bson:"dispatched_at"`
+	CreatedAt       string            `bson:"created_at"`
+	UpdatedAt       string            `bson:"updated_at"`
+	StatusChangedAt time.Time         `bson:"status_changed_at"`
+	SlaBreached     bool              `bson:"sla_breached"`
+	CartId          string            `bson:"cart_id"`
+	Discounts       []mongoDiscount   `bson:"discounts"`
+	Version         int64             `bson:"version"`
+	OrderNumber     int64             `bson:"order_number"`
+	Notes           string            `bson:"notes"`
+	Metadata        map[string]string `bson:"metadata"`
+	Priority        string            `bson:"priority"`
+	CallbackURL     string            `bson:"callback_url"`
+	FailureReason   string            `bson:"failure_reason"`
+	CustomerId      string            `bson:"customer_id"`
+	Refund          *mongoRefund      `bson:"refund,omitempty"`
+	DeletedAt       string            `bson:"deleted_at"`
+	History         []StatusChange    `bson:"status_history,omitempty"`
+	StatusReason    *StatusReason     `bson:"status_reason,omitempty"`
+	// left out of the updates but UpdateOrderItems, an order always has items
+	Items []mongoOrderItem `bson:"items,omitempty"`
+}
+
+type mongoOrderItem struct {
+	ProductId      string `bson:"product_id"`
+	Quantity       int64  `bson:"quantity"`
+	UnitPriceMinor int64  `bson:"unit_price_minor"`
+	Category       string `bson:"category"`
+	DiscountMinor  int64  `bson:"discount_minor"`
+	Name           string `bson:"name"`
+	// the prices of the items stored before the amounts were in minor units
+	UnitPrice float64 `bson:"unit_price,omitempty"`
+	Discount  float64 `bson:"discount,omitempty"`
+}
+
+// mongoDiscount is an applied discount in the order document, under the keys the discounts were stored
+// with before the amounts were in minor units
+type mongoDiscount struct {
+	Type        string  `bson:"type"`
+	Code        string  `bson:"code"`
+	Percent     int64   `bson:"percent"`
+	AmountMinor int64   `bson:"amount_minor"`
+	Amount      float64 `bson:"amount,omitempty"`
+}
+
+// mongoRefund is the refund in the order document, under the keys the refunds were stored with before
+// the amounts were in minor units
+type mongoRefund struct {
+	AmountMinor int64     `bson:"amount_minor"`
+	Amount      float64   `bson:"amount,omitempty"`
+	Currency    string    `bson:"currency"`
+	Reason      string    `bson:"reason"`
+	RefundedAt  Timestamp `bson:"refundedat"`
+}
+
+func newMongoOrder(o Order, items []OrderItem) mongoOrder {
+	doc := mongoOrder{
+		TenantId:        o.TenantId,
+		ID:              o.ID,
+		Discount:        o.Discount,
+		AmountMinor:     o.AmountMinor,
+		Currency:        o.Currency,
+		Status:          string(o.Status),
+		DispatchedAt:    formatOptionalTimestamp(o.DispatchedAt),
+		CreatedAt:       formatTimestamp(o.CreatedAt),
+		UpdatedAt:       formatTimestamp(o.UpdatedAt),
+		StatusChangedAt: o.StatusChangedAt,
+		SlaBreached:     o.SlaBreached,
+		CartId:          o.CartId,
+		Version:         o.Version,
+		OrderNumber:     o.OrderNumber,
+		Notes:           o.Notes,
+		Metadata:        o.Metadata,
+		Priority:        string(o.Priority),
+		CallbackURL:     o.CallbackURL,
+		FailureReason:   o.FailureReason,
+		CustomerId:      o.CustomerId,
+		DeletedAt:       formatOptionalTimestamp(o.DeletedAt),
+		History:         o.History,
+		StatusReason:    o.StatusReason,
+	}
+	for _, d := range o.Discounts {
+		doc.Discounts = append(doc.Discounts, mongoDiscount{Type: d.Type, Code: d.Code, Percent: d.Percent, AmountMinor: d.AmountMinor})
+	}
+	if o.Refund != nil {
+		doc.Refund = &mongoRefund{
+			AmountMinor: o.Refund.AmountMinor,
+			Currency:    o.Refund.Currency,
+			Reason:      o.Refund.Reason,
+			RefundedAt:  o.Refund.RefundedAt,
+		}
+	}
+	for _, item := range items {
+		doc.Items = append(doc.Items, mongoOrderItem{
+			ProductId:      item.ProductId,
+			Quantity:       item.ProductQuantity,
+			UnitPriceMinor: item.UnitPriceMinor,
+			Category:       item.Category,
+			DiscountMinor:  item.DiscountMinor,
+			Name:           item.Name,
+		})
+	}
+	return doc
+}
+
+// order returns the order with its items
+func (doc mongoOrder) order() (Order, []OrderItem, error) {
+	o := Order{
+		ID:              doc.ID,
+		TenantId:        doc.TenantId,
+		Discount:        doc.Discount,
+		Currency:        doc.Currency,
+		Status:          OrderStatus(doc.Status),
+		StatusChangedAt: doc.StatusChangedAt,
+		SlaBreached:     doc.SlaBreached,
+		CartId:          doc.CartId,
+		Version:         doc.Version,
+		OrderNumber:     doc.OrderNumber,
+		Notes:           doc.Notes,
+		Metadata:        doc.Metadata,
+		Priority:        OrderPriority(doc.Priority),
+		CallbackURL:     doc.CallbackURL,
+		FailureReason:   doc.FailureReason,
+		CustomerId:      doc.CustomerId,
+		History:         doc.History,
+		StatusReason:    doc.StatusReason,
+	}
+	// the documents stored before the amounts were in minor units have them in units of the currency
+	currency := amountCurrency(o)
+	o.AmountMinor = storedMinorUnits(doc.AmountMinor, doc.Amount, currency)
+	for _, d := range doc.Discounts {
+		o.Discounts = append(o.Discounts, AppliedDiscount{
+			Type:        d.Type,
+			Code:        d.Code,
+			Percent:     d.Percent,
+			AmountMinor: storedMinorUnits(d.AmountMinor, d.Amount, currency),
+		})
+	}
+	if doc.Refund != nil {
+		o.Refund = &Refund{
+			Currency:   doc.Refund.Currency,
+			Reason:     doc.Refund.Reason,
+			RefundedAt: doc.Refund.RefundedAt,
+		}
+		o.Refund.AmountMinor = storedMinorUnits(doc.Refund.AmountMinor, doc.Refund.Amount, refundCurrency(o.Refund))
+	}
+	var items []OrderItem
+	for _, item := range doc.Items {
+		items = append(items, OrderItem{
+			ProductId:       item.ProductId,
+			ProductQuantity: item.Quantity,
+			OrderId:         doc.ID,
+			UnitPriceMinor:  storedMinorUnits(item.UnitPriceMinor, item.UnitPrice, currency),
+			Category:        item.Category,
+			DiscountMinor:   storedMinorUnits(item.DiscountMinor, item.Discount, currency),
+			Name:            item.Name,
+		})
+	}
+	var err error
+	if o.DispatchedAt, err = parseStoredTimestamp(doc.DispatchedAt); err != nil {
+		return o, nil, fmt.Errorf("invalid dispatched at of order: %v, err: %w", o.ID, err)
+	}
+	if o.CreatedAt, err = parseStoredTimestamp(doc.CreatedAt); err != nil {
+		return o, nil, fmt.Errorf("invalid created at of order: %v, err: %w", o.ID, err)
+	}
+	if o.UpdatedAt, err = parseStoredTimestamp(doc.UpdatedAt); err != nil {
+		return o, nil, fmt.Errorf("invalid updated at of order: %v, err: %w", o.ID, err)
+	}
+	if o.DeletedAt, err = parseStoredTimestamp(doc.DeletedAt); err != nil {
+		return o, nil, fmt.Errorf("invalid deleted at of order: %v, err: %w", o.ID, err)
+	}
+	return o, items, nil
+}
+
+// MarshalBSONValue stores the timestamps of the status history and the refund as strings, like the other
+// timestamps of the order document
+func (t Timestamp) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(formatOptionalTimestamp(t.Time))
+}
+
+func (t *Timestamp) UnmarshalBSONValue(typ bsontype.Type, data []byte) error {
+	if typ == bsontype.Null {
+		t.Time = time.Time{}
+		return nil
+	}
+	s, ok := bson.RawValue{Type: typ, Value: data}.StringValueOK()
+	if !ok {
+		return fmt.Errorf("timestamp must be a string, got a bson %v", typ)
+	}
+	parsed, err := parseStoredTimestamp(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// openMongoDB connects to the database and creates the indexes if they don't exist yet
+func openMongoDB(uri, database string) (*mongo.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to mongodb, err: %w", err)
+	}
+	db := client.Database(database)
+	indexes := map[string][]mongo.IndexModel{
+		"orders": {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "cart_id", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "order_number", Value: 1}}},
+		},
+		"idempotency_keys": {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("error creating the indexes of collection: %v, err: %w", collection, err)
+		}
+	}
+	return db, nil
+}
+
+// mongoTenants returns the tenants with orders in the database
+func mongoTenants(db *mongo.Database) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	values, err := db.Collection("orders").Distinct(ctx, "tenant_id", bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	tenantIds := make([]string, 0, len(values))
+	for _, v := range values {
+		if tenantId, ok := v.(string); ok {
+			tenantIds = append(tenantIds, tenantId)
+		}
+	}
+	return tenantIds, nil
+}
+
+// MongoStore keeps the orders of a single tenant in MongoDB as documents with their items embedded, every
+// tenant shares the collections and its documents are scoped by the tenant id
+type MongoStore struct {
+	orders          *mongo.Collection
+	counters        *mongo.Collection
+	idempotencyKeys *mongo.Collection
+	tenantId        string
+	// the session context of the transaction of WithTx the calls run in, nil outside of one
+	txCtx mongo.SessionContext
+}
+
+func NewMongoStore(db *mongo.Database, tenantId string) *MongoStore {
+	return &MongoStore{
+		orders:          db.Collection("orders"),
+		counters:        db.Collection("order_counters"),
+		idempotencyKeys: db.Collection("idempotency_keys"),
+		tenantId:        tenantId,
+	}
+}
+
+// callContext bounds a call by mongoTimeout, within WithTx the call runs in the session of the transaction
+func (s *MongoStore) callContext() (context.Context, context.CancelFunc) {
+	if s.txCtx != nil {
+		return context.WithTimeout(s.txCtx, mongoTimeout)
+	}
+	return context.WithTimeout(context.Background(), mongoTimeout)
+}
+
+// WithTx runs fn in a multi-document transaction of a session, which MongoDB only offers on a replica set
+// or a sharded cluster. The driver runs fn again when the transaction fails with a transient error, a
+// WithTx within fn joins the transaction.
+func (s *MongoStore) WithTx(fn func(tx OrderRepository) error) error {
+	if s.txCtx != nil {
+		return fn(s)
+	}
+	session, err := s.orders.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		tx := *s
+		tx.txCtx = sc
+		return nil, fn(&tx)
+	})
+	return err
+}
+
+// filter scopes the query to the tenant
+func (s *MongoStore) filter(conditions ...bson.E) bson.D {
+	return append(bson.D{{Key: "tenant_id", Value: s.tenantId}}, conditions...)
+}
+
+// nextOrderNumber increments the order counter of the tenant, the increment is atomic so the instances
+// sharing the database never hand out the same number
+func (s *MongoStore) nextOrderNumber(ctx context.Context) (int64, error) {
+	var counter struct {
+		Last int64 `bson:"last"`
+	}
+	err := s.counters.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: s.tenantId}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "last", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Last, err
+}
+
+// SaveOrder replaces the stored order with its items. A number taken by an order that then fails to
+// save is not reused.
+func (s *MongoStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	if o.Status != OrderPending && o.OrderNumber == 0 {
+		orderNumber, err := s.nextOrderNumber(ctx)
+		if err != nil {
+			return o, err
+		}
+		o.OrderNumber = orderNumber
+	}
+	_, err := s.orders.ReplaceOne(ctx, s.filter(bson.E{Key: "id", Value: o.ID}), newMongoOrder(o, items),
+		options.Replace().SetUpsert(true))
+	return o, err
+}
+
+func (s *MongoStore) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	var doc mongoOrder
+	err := s.orders.FindOne(ctx, s.filter(bson.E{Key: "id", Value: orderId})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Order{}, nil, false, nil
+	}
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+	o, items, err := doc.order()
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+	return o, items, true, nil
+}
+
+func (s *MongoStore) ListOrders() ([]Order, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	cursor, err := s.orders.Find(ctx, s.filter(), options.Find().SetProjection(bson.D{{Key: "items", Value: 0}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orders := []Order{}
+	for cursor.Next(ctx) {
+		var doc mongoOrder
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		o, _, err := doc.order()
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, cursor.Err()
+}
+
+// mongoLegacyAmount unsets the amount of the orders stored before the amounts were in minor units once
+// they are updated, so it can't outlive the amount it was converted to
+var mongoLegacyAmount = bson.D{{Key: "amount", Value: ""}}
+
+// UpdateOrder leaves the items of the order untouched, they don't change once the order is placed
+func (s *MongoStore) UpdateOrder(o Order, version int64) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	res, err := s.orders.UpdateOne(ctx,
+		s.filter(bson.E{Key: "id", Value: o.ID}, bson.E{Key: "version", Value: version}),
+		bson.D{{Key: "$set", Value: newMongoOrder(o, nil)}, {Key: "$unset", Value: mongoLegacyAmount}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// UpdateOrderItems sets the items along with the order, the order and its items are a single document
+func (s *MongoStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	res, err := s.orders.UpdateOne(ctx,
+		s.filter(bson.E{Key: "id", Value: o.ID}, bson.E{Key: "version", Value: version}),
+		bson.D{{Key: "$set", Value: newMongoOrder(o, items)}, {Key: "$unset", Value: mongoLegacyAmount}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// FindByCartId skips the failed orders, their carts are free to be ordered again
+func (s *MongoStore) FindByCartId(cartId string) (string, bool, error) {
+	return s.findId(s.filter(
+		bson.E{Key: "cart_id", Value: cartId},
+		bson.E{Key: "status", Value: bson.D{{Key: "$ne", Value: string(OrderFailed)}}},
+	))
+}
+
+func (s *MongoStore) FindByNumber(orderNumber int64) (string, bool, error) {
+	return s.findId(s.filter(bson.E{Key: "order_number", Value: orderNumber}))
+}
+
+// findId returns the id of the first order matching the filter
+func (s *MongoStore) findId(filter bson.D) (string, bool, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	var doc struct {
+		ID string `bson:"id"`
+	}
+	err := s.orders.FindOne(ctx, filter, options.FindOne().SetProjection(bson.D{{Key: "id", Value: 1}})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", false, nil
+	}
+	return doc.ID, err == nil, err
+}
+
+func (s *MongoStore) MarkFailed(orderId, reason string, now time.Time) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	_, err := s.orders.UpdateOne(ctx, s.filter(bson.E{Key: "id", Value: orderId}), bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: string(OrderFailed)},
+			{Key: "failure_reason", Value: reason},
+			{Key: "status_changed_at", Value: now},
+			{Key: "updated_at", Value: formatTimestamp(now)},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+		{Key: "$push", Value: bson.D{{Key: "status_history", Value: failedStatusChange(now)}}},
+	})
+	return err
+}
+
+// DeleteOrder removes the order with its embedded items, then its idempotency key
+func (s *MongoStore) DeleteOrder(orderId string, version int64) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	res, err := s.orders.DeleteOne(ctx, s.filter(bson.E{Key: "id", Value: orderId}, bson.E{Key: "version", Value: version}))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errVersionConflict
+	}
+	_, err = s.idempotencyKeys.DeleteMany(ctx, s.filter(bson.E{Key: "order_id", Value: orderId}))
+	return err
+}
+
+// ClaimIdempotencyKey relies on the unique index, a key claimed concurrently fails the insert
+func (s *MongoStore) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	_, err := s.idempotencyKeys.InsertOne(ctx, s.filter(
+		bson.E{Key: "key", Value: key},
+		bson.E{Key: "request_hash", Value: record.RequestHash},
+		bson.E{Key: "order_id", Value: record.OrderId},
+	))
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return IdempotencyRecord{}, false, err
+	}
+
+	var existing struct {
+		RequestHash string `bson:"request_hash"`
+		OrderId     string `bson:"order_id"`
+	}
+	err = s.idempotencyKeys.FindOne(ctx, s.filter(bson.E{Key: "key", Value: key})).Decode(&existing)
+	return IdempotencyRecord{RequestHash: existing.RequestHash, OrderId: existing.OrderId}, false, err
+}
+
+func (s *MongoStore) ReleaseIdempotencyKey(key string) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	_, err := s.idempotencyKeys.DeleteOne(ctx, s.filter(bson.E{Key: "key", Value: key}))
+	return err
+}
+
+// RefreshSLAFlags only writes the orders whose flag changed
+func (s *MongoStore) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	orders, err := s.ListOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	breaches := make(map[OrderStatus]int)
+	for _, o := range orders {
+		isBreached := slaBreached(o, now)
+		if o.SlaBreached != isBreached {
+			ctx, cancel := s.callContext()
+			_, err := s.orders.UpdateOne(ctx,
+				s.filter(bson.E{Key: "id", Value: o.ID}, bson.E{Key: "status", Value: string(o.Status)}),
+				bson.D{{Key: "$set", Value: bson.D{{Key: "sla_breached", Value: isBreached}}}})
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if isBreached {
+			breaches[o.Status]++
+		}
+	}
+	return breaches, nil
+}
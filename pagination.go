@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// PageLinks link a page of a listing to its neighbours, keeping the filters and the sort of the request
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	// a cursor only pages forward, the pages fetched by cursor have no prev link
+	Prev string `json:"prev,omitempty"`
+}
+
+// pageLinks returns the links of the page of the listing starting at offset. A request paging by cursor
+// gets its next link with the cursor of the page, any other with the offset of the next page.
+func pageLinks(r *http.Request, offset, limit, total int, nextCursor string) PageLinks {
+	links := PageLinks{Self: r.URL.RequestURI()}
+	linkWith := func(param, value string) string {
+		query := r.URL.Query()
+		query.Set(param, value)
+		return r.URL.Path + "?" + query.Encode()
+	}
+
+	if r.URL.Query().Get("cursor") != "" {
+		if nextCursor != "" {
+			links.Next = linkWith("cursor", nextCursor)
+		}
+		return links
+	}
+	if offset+limit < total {
+		links.Next = linkWith("offset", strconv.Itoa(offset+limit))
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links.Prev = linkWith("offset", strconv.Itoa(prev))
+	}
+	return links
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type OrderPriority string
+
+const (
+	PriorityLow    OrderPriority = "low"
+	PriorityNormal OrderPriority = "normal"
+	PriorityHigh   OrderPriority = "high"
+)
+
+// fields of an order that can't be changed through PATCH /orders/{order_id}
+var immutableOrderFields = map[string]bool{
+	"id":                true,
+	"items":             true,
+	"amount":            true,
+	"amount_formatted":  true,
+	"currency":          true,
+	"discount":          true,
+	"discounts":         true,
+	"status":            true,
+	"status_reason":     true,
+	"status_timestamps": true,
+	"history":           true,
+	"dispatched_at":     true,
+	"created_at":        true,
+	"updated_at":        true,
+	"deleted_at":        true,
+	"sla_breached":      true,
+	"cart_id":           true,
+	"customer_id":       true,
+	"order_number":      true,
+	"skipped_items":     true,
+	"failure_reason":    true,
+	"refund":            true,
+	"version":           true,
+}
+
+// PatchOrderRequest holds the mutable fields of an order, a field is only applied if present in the
+// request and a null value clears it
+type PatchOrderRequest struct {
+	Notes       *string            `json:"notes"`
+	Metadata    *map[string]string `json:"metadata"`
+	Priority    *OrderPriority     `json:"priority"`
+	CallbackURL *string            `json:"callback_url"`
+
+	present map[string]bool
+}
+
+// errImmutableField is returned when the request tries to patch one of the immutable order fields
+var errImmutableField = errors.New("immutable field")
+
+func (p *PatchOrderRequest) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var immutable []string
+	p.present = make(map[string]bool)
+	for name, value := range fields {
+		if immutableOrderFields[name] {
+			immutable = append(immutable, name)
+			continue
+		}
+
+		var err error
+		switch name {
+		case "notes":
+			err = json.Unmarshal(value, &p.Notes)
+		case "metadata":
+			err = json.Unmarshal(value, &p.Metadata)
+		case "priority":
+			err = json.Unmarshal(value, &p.Priority)
+		case "callback_url":
+			err = json.Unmarshal(value, &p.CallbackURL)
+		default:
+			// worded like the decoder's own error so writeDecodeError reports it the same way
+			err = fmt.Errorf("json: unknown field %q", name)
+		}
+		if err != nil {
+			return err
+		}
+		p.present[name] = true
+	}
+	if len(immutable) > 0 {
+		sort.Strings(immutable)
+		return fmt.Errorf("%w: %v", errImmutableField, strings.Join(immutable, ", "))
+	}
+	return nil
+}
+
+func (p *PatchOrderRequest) Validate() (err error) {
+	if len(p.present) == 0 {
+		return errors.New("no fields to patch")
+	}
+
+	if p.Notes != nil && len(*p.Notes) > 1000 {
+		return errors.New("notes must be at most 1000 characters")
+	}
+
+	if p.Metadata != nil && len(*p.Metadata) > 50 {
+		return errors.New("metadata can have at most 50 entries")
+	}
+
+	if p.Priority != nil {
+		switch *p.Priority {
+		case PriorityLow, PriorityNormal, PriorityHigh:
+		default:
+			return errors.New("priority must be one of low, normal, high")
+		}
+	}
+
+	if p.CallbackURL != nil && *p.CallbackURL != "" {
+		u, err := url.Parse(*p.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("callback url must be an absolute http or https url")
+		}
+	}
+	return nil
+}
+
+// apply sets the fields present in the request on the order and reports whether anything changed
+func (p *PatchOrderRequest) apply(o *Order) bool {
+	changed := false
+
+	if p.present["notes"] {
+		notes := ""
+		if p.Notes != nil {
+			notes = *p.Notes
+		}
+		changed = changed || o.Notes != notes
+		o.Notes = notes
+	}
+	if p.present["metadata"] {
+		var metadata map[string]string
+		if p.Metadata != nil && len(*p.Metadata) > 0 {
+			metadata = *p.Metadata
+		}
+		changed = changed || !reflect.DeepEqual(o.Metadata, metadata)
+		o.Metadata = metadata
+	}
+	if p.present["priority"] {
+		var priority OrderPriority
+		if p.Priority != nil {
+			priority = *p.Priority
+		}
+		changed = changed || o.Priority != priority
+		o.Priority = priority
+	}
+	if p.present["callback_url"] {
+		callbackURL := ""
+		if p.CallbackURL != nil {
+			callbackURL = *p.CallbackURL
+		}
+		changed = changed || o.CallbackURL != callbackURL
+		o.CallbackURL = callbackURL
+	}
+	return changed
+}
+
+func PatchOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	var patchReq PatchOrderRequest
+	err := decodeJSONBody(w, r, &patchReq)
+	if errors.Is(err, errImmutableField) {
+		logger.InfoContext(r.Context(), "attempt to patch immutable fields", "order_id", orderId, "err", err)
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if err = patchReq.Validate(); err != nil {
+		logger.InfoContext(r.Context(), "error validating the request body", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+
+	if o.Status == OrderPending {
+		logger.InfoContext(r.Context(), "order is still being placed", "order_id", o.ID)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is still being placed", o.ID))
+		return
+	}
+	if status, message := ifMatchFailure(r, o); status != 0 {
+		writeJSONError(w, status, message)
+		return
+	}
+
+	readVersion := o.Version
+	if patchReq.apply(&o) {
+		o.UpdatedAt = clock.Now()
+		o.Version++
+
+		// Update the database
+		err = store.UpdateOrder(o, readVersion)
+		if errors.Is(err, errVersionConflict) {
+			logger.InfoContext(r.Context(), "order was modified concurrently", "order_id", o.ID)
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v was modified concurrently, retry the request", o.ID))
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		logger.InfoContext(r.Context(), "patched order", "order_id", o.ID)
+	}
+
+	// Prepare the response
+	orderDetails := newOrderResponse(o)
+
+	// Get the item details
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, amountCurrency(o), useLiveItemDetails(r))
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	orderDetails.Items = orderItemsDetailsList
+
+	setOrderETag(w, o)
+	writeJSON(w, http.StatusOK, orderDetails)
+}
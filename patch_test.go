@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPatchOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+		check      func(t *testing.T, o CreateOrderResponse)
+	}{
+		{"notes", `{"notes":"leave at the door"}`, http.StatusOK, "", func(t *testing.T, o CreateOrderResponse) {
+			if o.Notes != "leave at the door" {
+				t.Errorf("notes = %q", o.Notes)
+			}
+		}},
+		{"metadata", `{"metadata":{"gift":"yes"}}`, http.StatusOK, "", func(t *testing.T, o CreateOrderResponse) {
+			if len(o.Metadata) != 1 || o.Metadata["gift"] != "yes" {
+				t.Errorf("metadata = %v", o.Metadata)
+			}
+		}},
+		{"priority", `{"priority":"high"}`, http.StatusOK, "", func(t *testing.T, o CreateOrderResponse) {
+			if o.Priority != PriorityHigh {
+				t.Errorf("priority = %q", o.Priority)
+			}
+		}},
+		{"callback url", `{"callback_url":"https://example.com/hook"}`, http.StatusOK, "", func(t *testing.T, o CreateOrderResponse) {
+			if o.CallbackURL != "https://example.com/hook" {
+				t.Errorf("callback url = %q", o.CallbackURL)
+			}
+		}},
+		{"null clears the field", `{"notes":null}`, http.StatusOK, "", func(t *testing.T, o CreateOrderResponse) {
+			if o.Notes != "" {
+				t.Errorf("notes = %q, want them cleared", o.Notes)
+			}
+		}},
+		{"invalid priority", `{"priority":"urgent"}`, http.StatusBadRequest, "priority must be one of", nil},
+		{"unknown field", `{"colour":"red"}`, http.StatusBadRequest, "colour", nil},
+		{"no fields", `{}`, http.StatusBadRequest, "no fields to patch", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := patchTestOrder(t, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("patching the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.check == nil {
+				if !strings.Contains(rec.Body.String(), tt.wantError) {
+					t.Errorf("error = %s, want it to mention %q", rec.Body, tt.wantError)
+				}
+				assertOrderUnpatched(t)
+				return
+			}
+
+			var o CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+				t.Fatalf("decoding the order failed: %v", err)
+			}
+			if o.Version != 2 || rec.Header().Get("ETag") != orderETag(Order{Version: 2}) {
+				t.Errorf("version = %v with ETag %v, want 2", o.Version, rec.Header().Get("ETag"))
+			}
+			tt.check(t, o)
+		})
+	}
+}
+
+func TestPatchOrderImmutableFields(t *testing.T) {
+	for _, field := range []string{"id", "status", "amount", "currency", "order_number", "sla_breached", "deleted_at", "history", "version"} {
+		t.Run(field, func(t *testing.T) {
+			rec := patchTestOrder(t, `{"notes":"n","`+field+`":null}`)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("patching %v answered %v, want 422: %s", field, rec.Code, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), field) {
+				t.Errorf("error = %s, want it to name %v", rec.Body, field)
+			}
+			assertOrderUnpatched(t)
+		})
+	}
+}
+
+// patchTestOrder saves order o1 at version 1 with notes and patches it with body
+func patchTestOrder(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	if _, err := tenantStore("t1").SaveOrder(Order{ID: "o1", TenantId: "t1", Status: OrderPlaced, Version: 1, Notes: "ring twice"},
+		[]OrderItem{{ProductId: "p1", ProductQuantity: 1}}); err != nil {
+		t.Fatalf("saving the order failed: %v", err)
+	}
+
+	req := newTenantRequest(http.MethodPatch, "/orders/o1", body, map[string]string{"order_id": "o1"})
+	req.Header.Set("If-Match", orderETag(Order{Version: 1}))
+	rec := httptest.NewRecorder()
+	PatchOrderHandler(rec, req)
+	return rec
+}
+
+func assertOrderUnpatched(t *testing.T) {
+	t.Helper()
+	o, _, _, err := tenantStore("t1").GetOrder("o1")
+	if err != nil {
+		t.Fatalf("reading the order failed: %v", err)
+	}
+	if o.Version != 1 || o.Notes != "ring twice" {
+		t.Errorf("the rejected patch changed the order: %+v", o)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations are the schema migrations of the postgres store. The first one adopts the databases
+// created before the migrations, whose tables already exist.
+var postgresMigrations = []migration{
+	{1, "create the orders, order items and idempotency keys tables", execStatements(
+		`CREATE TABLE IF NOT EXISTS orders (
+			tenant_id         TEXT NOT NULL,
+			id                TEXT NOT NULL,
+			discount          BIGINT NOT NULL,
+			amount            DOUBLE PRECISION NOT NULL,
+			currency          TEXT NOT NULL,
+			status            TEXT NOT NULL,
+			dispatched_at     TEXT NOT NULL,
+			created_at        TEXT NOT NULL,
+			updated_at        TEXT NOT NULL,
+			status_changed_at TEXT NOT NULL,
+			sla_breached      BOOLEAN NOT NULL,
+			cart_id           TEXT NOT NULL,
+			discounts         TEXT NOT NULL,
+			version           BIGINT NOT NULL,
+			order_number      BIGINT NOT NULL,
+			notes             TEXT NOT NULL,
+			metadata          TEXT NOT NULL,
+			priority          TEXT NOT NULL,
+			callback_url      TEXT NOT NULL,
+			failure_reason    TEXT NOT NULL,
+			customer_id       TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (tenant_id, id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS orders_cart_id ON orders (tenant_id, cart_id)`,
+		// pending orders have no number yet, every other order of the tenant has its own
+		`CREATE UNIQUE INDEX IF NOT EXISTS orders_order_number ON orders (tenant_id, order_number) WHERE order_number > 0`,
+		`CREATE TABLE IF NOT EXISTS order_items (
+			tenant_id  TEXT NOT NULL,
+			order_id   TEXT NOT NULL,
+			position   INTEGER NOT NULL,
+			product_id TEXT NOT NULL,
+			quantity   BIGINT NOT NULL,
+			unit_price DOUBLE PRECISION NOT NULL,
+			category   TEXT NOT NULL,
+			discount   DOUBLE PRECISION NOT NULL,
+			PRIMARY KEY (tenant_id, order_id, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			tenant_id    TEXT NOT NULL,
+			key          TEXT NOT NULL,
+			request_hash TEXT NOT NULL,
+			order_id     TEXT NOT NULL,
+			PRIMARY KEY (tenant_id, key)
+		)`,
+	)},
+	{2, "add the refund to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS refund TEXT NOT NULL DEFAULT ''`,
+	)},
+	{3, "add the deletion time to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS deleted_at TEXT NOT NULL DEFAULT ''`,
+	)},
+	{4, "add the status history to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS status_history TEXT NOT NULL DEFAULT ''`,
+	)},
+	{5, "add the cancellation and return reason to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT ''`,
+	)},
+	{6, "add the product name to the order items", execStatements(
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT ''`,
+	)},
+	{7, "keep the amounts in the minor units of the currency", migrationSteps(
+		execStatements(
+			`ALTER TABLE orders ADD COLUMN IF NOT EXISTS amount_minor BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS unit_price_minor BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount_minor BIGINT NOT NULL DEFAULT 0`,
+		),
+		convertToMinorUnits(postgresDialect),
+		execStatements(
+			`ALTER TABLE orders DROP COLUMN amount`,
+			`ALTER TABLE order_items DROP COLUMN unit_price`,
+			`ALTER TABLE order_items DROP COLUMN discount`,
+		),
+	)},
+}
+
+// postgresSchemaLock is the advisory lock taken while migrating the schema, so instances starting
+// together don't race on it
+const postgresSchemaLock = 7691001
+
+// openPostgresDB connects to the database and migrates its schema, the pool is capped at maxOpenConns
+// connections
+func openPostgresDB(dsn string, maxOpenConns int) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the postgres database, err: %w", err)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+	if err := migrateSchema(db, postgresDialect, postgresMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// pgPlaceholders returns the numbered placeholders $from to $from+n-1
+func pgPlaceholders(from, n int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// pgOrderUpsert inserts the order or replaces the stored one
+var pgOrderUpsert = func() string {
+	var excluded []string
+	for _, column := range strings.Split(orderColumns, ",") {
+		excluded = append(excluded, "EXCLUDED."+strings.TrimSpace(column))
+	}
+	return `INSERT INTO orders (` + orderColumns + `) VALUES (` + pgPlaceholders(1, len(excluded)) + `)
+		ON CONFLICT (tenant_id, id) DO UPDATE SET (` + orderColumns + `) = (` + strings.Join(excluded, ", ") + `)`
+}()
+
+// PostgresStore keeps the orders of a single tenant in PostgreSQL, every tenant and every instance of
+// the service shares the database and the rows are scoped by the tenant id
+type PostgresStore struct {
+	db *sql.DB
+	// the transaction of WithTx the calls run in, nil outside of one
+	tx       *sql.Tx
+	tenantId string
+}
+
+func NewPostgresStore(db *sql.DB, tenantId string) *PostgresStore {
+	return &PostgresStore{db: db, tenantId: tenantId}
+}
+
+// conn returns the transaction of WithTx the calls run in, the database otherwise
+func (s *PostgresStore) conn() sqlConn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *PostgresStore) begin() (sqlTx, error) {
+	return beginSQLTx(s.db, s.tx)
+}
+
+// WithTx runs fn in a single SQL transaction
+func (s *PostgresStore) WithTx(fn func(tx OrderRepository) error) error {
+	return runSQLTx(s.db, s.tx, func(tx *sql.Tx) error {
+		return fn(&PostgresStore{db: s.db, tx: tx, tenantId: s.tenantId})
+	})
+}
+
+// SaveOrder numbers the order within the transaction. The instances share the numbers of the tenant, an
+// advisory lock on the tenant serializes the numbering across them.
+func (s *PostgresStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return o, err
+	}
+	defer tx.Rollback()
+
+	if o.Status != OrderPending && o.OrderNumber == 0 {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, s.tenantId); err != nil {
+			return o, err
+		}
+		var lastOrderNumber int64
+		err := tx.QueryRow(`SELECT COALESCE(MAX(order_number), 0) FROM orders WHERE tenant_id = $1`, s.tenantId).Scan(&lastOrderNumber)
+		if err != nil {
+			return o, err
+		}
+		o.OrderNumber = lastOrderNumber + 1
+	}
+
+	values, err := orderValues(o)
+	if err != nil {
+		return o, err
+	}
+	if _, err := tx.Exec(pgOrderUpsert, values...); err != nil {
+		return o, err
+	}
+
+	if err := s.replaceItems(tx, o.ID, items); err != nil {
+		return o, err
+	}
+	return o, tx.Commit()
+}
+
+// replaceItems replaces the stored items of the order with the items
+func (s *PostgresStore) replaceItems(tx sqlExecer, orderId string, items []OrderItem) error {
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE tenant_id = $1 AND order_id = $2`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	for i, item := range items {
+		_, err := tx.Exec(`INSERT INTO order_items (tenant_id, order_id, position, product_id, quantity, unit_price_minor, category, discount_minor, name)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			s.tenantId, orderId, i, item.ProductId, item.ProductQuantity, item.UnitPriceMinor, item.Category, item.DiscountMinor, item.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	row := s.conn().QueryRow(`SELECT `+orderColumns+` FROM orders WHERE tenant_id = $1 AND id = $2`, s.tenantId, orderId)
+	o, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, nil, false, nil
+	}
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+
+	rows, err := s.conn().Query(`SELECT product_id, quantity, unit_price_minor, category, discount_minor, name FROM order_items
+		WHERE tenant_id = $1 AND order_id = $2 ORDER BY position`, s.tenantId, orderId)
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+	defer rows.Close()
+
+	var items []OrderItem
+	for rows.Next() {
+		item := OrderItem{OrderId: orderId}
+		if err := rows.Scan(&item.ProductId, &item.ProductQuantity, &item.UnitPriceMinor, &item.Category, &item.DiscountMinor, &item.Name); err != nil {
+			return Order{}, nil, false, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Order{}, nil, false, err
+	}
+	return o, items, true, nil
+}
+
+func (s *PostgresStore) ListOrders() ([]Order, error) {
+	rows, err := s.conn().Query(`SELECT `+orderColumns+` FROM orders WHERE tenant_id = $1`, s.tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// UpdateOrder leaves the items of the order untouched, they don't change once the order is placed
+func (s *PostgresStore) UpdateOrder(o Order, version int64) error {
+	return s.updateOrder(s.conn(), o, version)
+}
+
+func (s *PostgresStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.updateOrder(tx, o, version); err != nil {
+		return err
+	}
+	if err := s.replaceItems(tx, o.ID, items); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) updateOrder(db sqlExecer, o Order, version int64) error {
+	values, err := orderValues(o)
+	if err != nil {
+		return err
+	}
+	n := len(values)
+	res, err := db.Exec(fmt.Sprintf(`UPDATE orders SET (`+orderColumns+`) = (%v)
+		WHERE tenant_id = $%d AND id = $%d AND version = $%d`, pgPlaceholders(1, n), n+1, n+2, n+3),
+		append(values, s.tenantId, o.ID, version)...)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// FindByCartId skips the failed orders, their carts are free to be ordered again
+func (s *PostgresStore) FindByCartId(cartId string) (string, bool, error) {
+	var orderId string
+	err := s.conn().QueryRow(`SELECT id FROM orders WHERE tenant_id = $1 AND cart_id = $2 AND status != $3 LIMIT 1`,
+		s.tenantId, cartId, string(OrderFailed)).Scan(&orderId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return orderId, err == nil, err
+}
+
+func (s *PostgresStore) FindByNumber(orderNumber int64) (string, bool, error) {
+	var orderId string
+	err := s.conn().QueryRow(`SELECT id FROM orders WHERE tenant_id = $1 AND order_number = $2`,
+		s.tenantId, orderNumber).Scan(&orderId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return orderId, err == nil, err
+}
+
+// MarkFailed appends to the status history within the transaction of the update, the row is locked
+// while it is read
+func (s *PostgresStore) MarkFailed(orderId, reason string, now time.Time) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	history, err := appendFailedStatusChange(tx.QueryRow(`SELECT status_history FROM orders WHERE tenant_id = $1 AND id = $2 FOR UPDATE`, s.tenantId, orderId), now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE orders SET status = $1, failure_reason = $2, status_changed_at = $3, updated_at = $4, status_history = $5, version = version + 1
+		WHERE tenant_id = $6 AND id = $7`,
+		string(OrderFailed), reason, now.Format(time.RFC3339Nano), formatTimestamp(now), history, s.tenantId, orderId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) DeleteOrder(orderId string, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM orders WHERE tenant_id = $1 AND id = $2 AND version = $3`, s.tenantId, orderId, version)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errVersionConflict
+	}
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE tenant_id = $1 AND order_id = $2`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE tenant_id = $1 AND order_id = $2`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimIdempotencyKey relies on the primary key, a key claimed concurrently is ignored by the insert
+func (s *PostgresStore) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	res, err := s.conn().Exec(`INSERT INTO idempotency_keys (tenant_id, key, request_hash, order_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, key) DO NOTHING`,
+		s.tenantId, key, record.RequestHash, record.OrderId)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if inserted == 1 {
+		return record, true, nil
+	}
+
+	var existing IdempotencyRecord
+	err = s.conn().QueryRow(`SELECT request_hash, order_id FROM idempotency_keys WHERE tenant_id = $1 AND key = $2`,
+		s.tenantId, key).Scan(&existing.RequestHash, &existing.OrderId)
+	return existing, false, err
+}
+
+func (s *PostgresStore) ReleaseIdempotencyKey(key string) error {
+	_, err := s.conn().Exec(`DELETE FROM idempotency_keys WHERE tenant_id = $1 AND key = $2`, s.tenantId, key)
+	return err
+}
+
+// RefreshSLAFlags only writes the orders whose flag changed
+func (s *PostgresStore) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	orders, err := s.ListOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	breaches := make(map[OrderStatus]int)
+	for _, o := range orders {
+		isBreached := slaBreached(o, now)
+		if o.SlaBreached != isBreached {
+			_, err := s.conn().Exec(`UPDATE orders SET sla_breached = $1 WHERE tenant_id = $2 AND id = $3 AND status = $4`,
+				isBreached, s.tenantId, o.ID, string(o.Status))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if isBreached {
+			breaches[o.Status]++
+		}
+	}
+	return breaches, nil
+}
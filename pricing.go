@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// policies for the items of products priced at zero
+const (
+	// zero priced items are placed like any other, e.g. free gifts
+	ZeroPriceAllow = "allow"
+	// orders with zero priced items are rejected, the price is taken to be a data error
+	ZeroPriceReject = "reject"
+	// orders with zero priced items are placed on hold for an admin to review
+	ZeroPriceReview = "review"
+)
+
+var zeroPricePolicy = ZeroPriceAllow
+
+// PricingConfig holds the order rules that promotions tune
+type PricingConfig struct {
+	// maximum quantity of a single product in an order
+	MaxItemQuantity int64 `json:"max_item_quantity"`
+	// discount given to orders with at least PremiumProductThreshold premium products
+	PremiumDiscountPercent  int64 `json:"premium_discount_percent"`
+	PremiumProductThreshold int64 `json:"premium_product_threshold"`
+}
+
+// pricingConfig is read by the validation and the amount calculation, the defaults are the rules the
+// service always had
+var pricingConfig = PricingConfig{
+	MaxItemQuantity:         10,
+	PremiumDiscountPercent:  10,
+	PremiumProductThreshold: 3,
+}
+
+// loadPricingConfig reads ZERO_PRICE_POLICY (allow/reject/review) and the PricingConfig. The PricingConfig
+// is read from the JSON file at PRICING_CONFIG_FILE, if set, and then from PRICING_MAX_ITEM_QUANTITY,
+// PRICING_PREMIUM_DISCOUNT_PERCENT and PRICING_PREMIUM_PRODUCT_THRESHOLD, fields missing from both keep
+// their defaults.
+func loadPricingConfig() error {
+	zeroPricePolicy = getEnv("ZERO_PRICE_POLICY", ZeroPriceAllow)
+	switch zeroPricePolicy {
+	case ZeroPriceAllow, ZeroPriceReject, ZeroPriceReview:
+	default:
+		logger.Warn("invalid ZERO_PRICE_POLICY, using the default", "value", zeroPricePolicy, "default", ZeroPriceAllow)
+		zeroPricePolicy = ZeroPriceAllow
+	}
+	logger.Info("zero price policy loaded", "policy", zeroPricePolicy)
+
+	config := pricingConfig
+	if path := getEnv("PRICING_CONFIG_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading the pricing config file: %v, err: %w", path, err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("error parsing the pricing config file: %v, err: %w", path, err)
+		}
+	}
+	config.MaxItemQuantity = int64(getEnvInt("PRICING_MAX_ITEM_QUANTITY", int(config.MaxItemQuantity)))
+	config.PremiumDiscountPercent = int64(getEnvInt("PRICING_PREMIUM_DISCOUNT_PERCENT", int(config.PremiumDiscountPercent)))
+	config.PremiumProductThreshold = int64(getEnvInt("PRICING_PREMIUM_PRODUCT_THRESHOLD", int(config.PremiumProductThreshold)))
+
+	if config.MaxItemQuantity <= 0 {
+		return fmt.Errorf("max item quantity must be greater than 0, got: %v", config.MaxItemQuantity)
+	}
+	if config.PremiumDiscountPercent < 0 || config.PremiumDiscountPercent > 100 {
+		return fmt.Errorf("premium discount percent must be between 0 and 100, got: %v", config.PremiumDiscountPercent)
+	}
+	if config.PremiumProductThreshold <= 0 {
+		return fmt.Errorf("premium product threshold must be greater than 0, got: %v", config.PremiumProductThreshold)
+	}
+	pricingConfig = config
+	logger.Info("pricing config loaded",
+		"max_item_quantity", pricingConfig.MaxItemQuantity,
+		"premium_discount_percent", pricingConfig.PremiumDiscountPercent,
+		"premium_product_threshold", pricingConfig.PremiumProductThreshold,
+	)
+	return nil
+}
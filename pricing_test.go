@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestZeroPricePolicies(t *testing.T) {
+	// p6, the gift wrap, is free
+	tests := []struct {
+		policy     string
+		wantStatus int
+		wantOrder  OrderStatus
+		wantError  string
+	}{
+		{ZeroPriceAllow, http.StatusOK, OrderPlaced, ""},
+		{ZeroPriceReject, http.StatusUnprocessableEntity, "", "product with id: p6 has a zero price and can't be ordered"},
+		{ZeroPriceReview, http.StatusOK, OrderOnHold, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			useMemoryStores(t)
+			useFakeProductClient(t, fakeSampleProducts()...)
+			prev := zeroPricePolicy
+			zeroPricePolicy = tt.policy
+			t.Cleanup(func() { zeroPricePolicy = prev })
+
+			rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1},{"product_id":"p6","quantity":1}]}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("placing the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantError != "" {
+				if !strings.Contains(rec.Body.String(), tt.wantError) {
+					t.Errorf("error = %s, want %q", rec.Body, tt.wantError)
+				}
+				return
+			}
+			var o CreateOrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &o); err != nil {
+				t.Fatalf("decoding the order failed: %v", err)
+			}
+			if o.Status != tt.wantOrder || o.Amount != 5 || len(o.Items) != 2 {
+				t.Errorf("order is %v for %v with %v items, want %v for 5 with both items", o.Status, o.Amount, len(o.Items), tt.wantOrder)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/microServicesExamples/gRPC/product/productpb"
+)
+
+// ProductDetails is the order-service's view of a product, independent of the source of the details
+type ProductDetails struct {
+	ID          string
+	Name        string
+	Description string
+	Category    string
+	Price       float64
+	// quantity available in the inventory
+	Quantity int64
+}
+
+// productDetailsFromProto maps the product service's response to the domain type
+func productDetailsFromProto(p *productpb.GetProductDetailsResponse) *ProductDetails {
+	if p == nil {
+		return nil
+	}
+	return &ProductDetails{
+		ID:          p.Id,
+		Name:        p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		Price:       p.Price,
+		Quantity:    p.Quantity,
+	}
+}
+
+// maxItemDescriptionLength caps the length in characters of the item descriptions in the responses,
+// 0 keeps them whole. Set by ITEM_DESCRIPTION_MAX_LENGTH.
+var maxItemDescriptionLength = 0
+
+// sanitizeDescription strips the control characters out of a product description, turning line breaks
+// and tabs into spaces, and truncates it to maxItemDescriptionLength with an ellipsis
+func sanitizeDescription(description string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, description)
+
+	if maxItemDescriptionLength <= 0 {
+		return sanitized
+	}
+	runes := []rune(sanitized)
+	if len(runes) <= maxItemDescriptionLength {
+		return sanitized
+	}
+	return string(runes[:maxItemDescriptionLength-1]) + "…"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// productErrorStatus maps a failed product service call to the response status and a message that is
+// safe to return to clients, the raw error only goes to the logs
+func productErrorStatus(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, "the product service did not respond in time"
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.DeadlineExceeded:
+			return http.StatusGatewayTimeout, "the product service did not respond in time"
+		case codes.Unavailable:
+			return http.StatusServiceUnavailable, "the product service is unavailable"
+		case codes.NotFound:
+			return http.StatusInternalServerError, "product details of the order could not be found"
+		}
+	}
+	return http.StatusInternalServerError, "product details could not be fetched"
+}
+
+// writeProductError logs the failed product service call and answers with its classified status
+func writeProductError(w http.ResponseWriter, err error) {
+	status, message := productErrorStatus(err)
+	logger.Error("product service call failed", "status", status, "err", err)
+	writeJSONError(w, status, message)
+}
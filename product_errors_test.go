@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingProductClient fails the product lookups with err, a nil err makes them wait past the deadline
+// of the request
+type failingProductClient struct {
+	*fakeProductClient
+	err error
+}
+
+func (c *failingProductClient) GetProductDetails(ctx context.Context, productId string) (*ProductDetails, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return c.fakeProductClient.GetProductDetails(ctx, productId)
+	}
+}
+
+func TestOrderDetailsProductErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{"deadline", nil, http.StatusGatewayTimeout, "the product service did not respond in time"},
+		{"grpc deadline", status.Error(codes.DeadlineExceeded, "grpc: deadline exceeded on 10.0.0.7:50051"), http.StatusGatewayTimeout, "the product service did not respond in time"},
+		{"unavailable", status.Error(codes.Unavailable, "connection refused 10.0.0.7:50051"), http.StatusServiceUnavailable, "the product service is unavailable"},
+		{"not found", status.Error(codes.NotFound, "no row in products for p1"), http.StatusInternalServerError, "product details of the order could not be found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			productClient = &failingProductClient{fakeProductClient: fake, err: tt.err}
+			saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, "/orders/o1", nil)
+			req = req.WithContext(context.WithValue(ctx, tenantContextKey{}, "t1"))
+			req = mux.SetURLVars(req, map[string]string{"order_id": "o1"})
+			rec := httptest.NewRecorder()
+			GetOrderDetailsHandler(rec, req)
+
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("the body is not the error envelope: %v: %s", err, rec.Body)
+			}
+			if rec.Code != tt.wantStatus || body.Status != tt.wantStatus || body.Error != tt.wantMessage {
+				t.Errorf("answered %v with %+v, want %v with %q", rec.Code, body, tt.wantStatus, tt.wantMessage)
+			}
+			// the raw error of the product service stays in the logs
+			if strings.Contains(rec.Body.String(), "10.0.0.7") || strings.Contains(rec.Body.String(), "grpc") {
+				t.Errorf("the response leaks the product service error: %s", rec.Body)
+			}
+		})
+	}
+}
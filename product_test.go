@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSanitizeDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxLength   int
+		description string
+		want        string
+	}{
+		{"kept whole by default", 0, "Analog wrist watch with a leather strap", "Analog wrist watch with a leather strap"},
+		{"control characters", 0, "Analog\twrist\r\nwatch\x00\x1b[31m", "Analog wrist  watch[31m"},
+		{"truncated with an ellipsis", 10, "Analog wrist watch", "Analog wr…"},
+		{"short enough", 18, "Analog wrist watch", "Analog wrist watch"},
+		{"truncated by character", 4, "Montre à aiguilles", "Mon…"},
+		{"sanitized before truncation", 8, "\x00\x01\x02Analog watch", "Analog …"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := maxItemDescriptionLength
+			maxItemDescriptionLength = tt.maxLength
+			t.Cleanup(func() { maxItemDescriptionLength = prev })
+
+			if got := sanitizeDescription(tt.description); got != tt.want {
+				t.Errorf("sanitizeDescription(%q) = %q, want %q", tt.description, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderItemDescriptions(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	prev := maxItemDescriptionLength
+	maxItemDescriptionLength = 12
+	t.Cleanup(func() { maxItemDescriptionLength = prev })
+	fake.SetProduct(&ProductDetails{ID: "p1", Name: "Watch", Category: "premium", Price: 250, Quantity: 100,
+		Description: "Analog\nwrist watch\x07 with a leather strap"})
+	saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+
+	rec := httptest.NewRecorder()
+	GetOrderDetailsHandler(rec, newTenantRequest(http.MethodGet, "/orders/o1?refresh=true", "", map[string]string{"order_id": "o1"}))
+	var o CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &o); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("reading the order answered %v: %s", rec.Code, rec.Body)
+	}
+	if len(o.Items) != 1 || o.Items[0].Description != "Analog wris…" {
+		t.Errorf("items = %+v, want the description sanitized and truncated", o.Items)
+	}
+}
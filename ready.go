@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+)
+
+type ReadyResponse struct {
+	Ready bool `json:"ready"`
+	// state of the product service connection, "fake" for the in-memory product client
+	ProductConnection string `json:"product_connection"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+// ReadyHandler is the readiness probe, it fails while the product service connection can't serve
+// requests. /ping stays the liveness probe.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	state, ok := productConnectionState()
+	if !ok {
+		writeJSON(w, http.StatusOK, ReadyResponse{Ready: true, ProductConnection: "fake"})
+		return
+	}
+
+	if !productConnectionReady() {
+		logger.WarnContext(r.Context(), "not ready", "product_connection", state)
+		writeJSON(w, http.StatusServiceUnavailable, ReadyResponse{
+			ProductConnection: state.String(),
+			Reason:            "the product service connection is " + state.String(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, ReadyResponse{Ready: true, ProductConnection: state.String()})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reasonCodes are the reason codes accepted when an order is cancelled or returned, set by
+// CANCELLATION_REASON_CODES and RETURN_REASON_CODES, comma separated lists
+var reasonCodes = map[OrderStatus]map[string]bool{
+	OrderCancelled: reasonCodeSet("customer_request,out_of_stock,payment_failed,fraud_suspected,other"),
+	OrderReturned:  reasonCodeSet("damaged,wrong_item,not_as_described,no_longer_needed,other"),
+}
+
+// maxStatusNoteLength caps the note given with a cancellation or a return
+const maxStatusNoteLength = 500
+
+// StatusReason is why an order was cancelled or returned
+type StatusReason struct {
+	Code string `json:"code"`
+	Note string `json:"note,omitempty"`
+}
+
+func reasonCodeSet(codes string) map[string]bool {
+	set := make(map[string]bool)
+	for _, code := range strings.Split(codes, ",") {
+		if code = strings.ToLower(strings.TrimSpace(code)); code != "" {
+			set[code] = true
+		}
+	}
+	return set
+}
+
+func loadReasonCodeConfig() error {
+	for status, env := range map[OrderStatus]string{OrderCancelled: "CANCELLATION_REASON_CODES", OrderReturned: "RETURN_REASON_CODES"} {
+		v := getEnv(env, "")
+		if v == "" {
+			continue
+		}
+		codes := reasonCodeSet(v)
+		if len(codes) == 0 {
+			return fmt.Errorf("%v has no reason codes", env)
+		}
+		reasonCodes[status] = codes
+	}
+	logger.Info("reason codes loaded", "cancelled", sortedReasonCodes(OrderCancelled), "returned", sortedReasonCodes(OrderReturned))
+	return nil
+}
+
+func sortedReasonCodes(status OrderStatus) []string {
+	codes := make([]string, 0, len(reasonCodes[status]))
+	for code := range reasonCodes[status] {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// validateStatusReason checks the reason given with a move to the status, a cancellation or a return
+// need one of the reason codes of the status and any other status takes no reason
+func validateStatusReason(status OrderStatus, reasonCode, note string) error {
+	codes, needsReason := reasonCodes[status]
+	if !needsReason {
+		if reasonCode != "" || note != "" {
+			return errors.New("a reason can only be given when the order is cancelled or returned")
+		}
+		return nil
+	}
+	if reasonCode == "" {
+		return fmt.Errorf("reason code is required when the order is %v", status)
+	}
+	if !codes[reasonCode] {
+		return fmt.Errorf("reason code must be one of %v", strings.Join(sortedReasonCodes(status), ", "))
+	}
+	if len(note) > maxStatusNoteLength {
+		return fmt.Errorf("note must be at most %d characters", maxStatusNoteLength)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// receiptVersion is bumped on every breaking change to the receipt schema
+const receiptVersion = "1"
+
+type ReceiptSeller struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxId   string `json:"tax_id,omitempty"`
+}
+
+type ReceiptLineItem struct {
+	ProductId string  `json:"product_id"`
+	Name      string  `json:"name"`
+	UnitPrice float64 `json:"unit_price"`
+	Quantity  int64   `json:"quantity"`
+	LineTotal float64 `json:"line_total"`
+	Discount  float64 `json:"discount"`
+}
+
+// ReceiptResponse is a structured receipt of an order for accounting systems, its fields are always
+// present so importers can rely on the shape
+type ReceiptResponse struct {
+	ReceiptVersion string             `json:"receipt_version"`
+	OrderId        string             `json:"order_id"`
+	Seller         ReceiptSeller      `json:"seller"`
+	Items          []ReceiptLineItem  `json:"items"`
+	Subtotal       float64            `json:"subtotal"`
+	Discounts      []DiscountResponse `json:"discounts"`
+	Discount       float64            `json:"discount"`
+	// the service doesn't charge tax or shipping, they are part of the schema for the accounting tools
+	Tax        float64   `json:"tax"`
+	Shipping   float64   `json:"shipping"`
+	GrandTotal float64   `json:"grand_total"`
+	Currency   string    `json:"currency"`
+	CreatedAt  Timestamp `json:"created_at"`
+	UpdatedAt  Timestamp `json:"updated_at"`
+	IssuedAt   Timestamp `json:"issued_at"`
+}
+
+// receiptSeller reads the seller printed on the receipts from SELLER_NAME, SELLER_ADDRESS and SELLER_TAX_ID
+func receiptSeller() ReceiptSeller {
+	return ReceiptSeller{
+		Name:    getEnv("SELLER_NAME", "order-service"),
+		Address: getEnv("SELLER_ADDRESS", ""),
+		TaxId:   getEnv("SELLER_TAX_ID", ""),
+	}
+}
+
+func GetOrderReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId := vars["order_id"]
+	store := tenantStore(tenantFromContext(r.Context()))
+
+	o, oItems, ok, err := store.GetOrder(orderId)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// Verify if the order is present in the database
+	if !ok || isDeleted(o) {
+		logger.InfoContext(r.Context(), "order does not exist", "order_id", orderId)
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("order with id: %v does not exist", orderId))
+		return
+	}
+	if o.Status == OrderPending || o.Status == OrderFailed {
+		logger.InfoContext(r.Context(), "order has no receipt in its status", "order_id", o.ID, "status", o.Status)
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("order with id: %v is %v and has no receipt", o.ID, o.Status))
+		return
+	}
+
+	currency := amountCurrency(o)
+
+	// the names and the prices come from the order's snapshots, the product service is only asked for the
+	// names of the items placed before they were snapshotted
+	orderItemsDetailsList, err := GetOrderItemsDetailsList(r.Context(), oItems, currency, false)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+
+	receipt := ReceiptResponse{
+		ReceiptVersion: receiptVersion,
+		OrderId:        o.ID,
+		Seller:         receiptSeller(),
+		Items:          []ReceiptLineItem{},
+		Discounts:      newDiscountResponses(o.Discounts, currency),
+		GrandTotal:     fromMinorUnits(o.AmountMinor, currency),
+		Currency:       currency,
+		CreatedAt:      Timestamp{o.CreatedAt},
+		UpdatedAt:      Timestamp{o.UpdatedAt},
+		IssuedAt:       Timestamp{clock.Now()},
+	}
+	// the totals are added up in the minor units of the currency
+	var subtotal, discount int64
+	for i, item := range oItems {
+		lineTotal := lineTotalMinorUnits(item)
+		receipt.Items = append(receipt.Items, ReceiptLineItem{
+			ProductId: item.ProductId,
+			Name:      orderItemsDetailsList[i].Name,
+			UnitPrice: fromMinorUnits(item.UnitPriceMinor, currency),
+			Quantity:  item.ProductQuantity,
+			LineTotal: fromMinorUnits(lineTotal, currency),
+			Discount:  fromMinorUnits(item.DiscountMinor, currency),
+		})
+		subtotal += lineTotal
+	}
+	for _, d := range o.Discounts {
+		discount += d.AmountMinor
+	}
+	receipt.Subtotal = fromMinorUnits(subtotal, currency)
+	receipt.Discount = fromMinorUnits(discount, currency)
+
+	writeJSON(w, http.StatusOK, receipt)
+}
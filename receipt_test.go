@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+func TestOrderReceiptGolden(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	useFakeClock(t, now)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	t.Setenv("SELLER_NAME", "Example Shop")
+	t.Setenv("SELLER_ADDRESS", "1 Market Street")
+	t.Setenv("SELLER_TAX_ID", "GB123456789")
+
+	o := Order{
+		ID:          "3d8a3c9e-7f52-4a43-9d55-2a1f0b6e4c11",
+		TenantId:    "t1",
+		Status:      OrderConfirmed,
+		Currency:    "USD",
+		CreatedAt:   now.Add(-2 * time.Hour),
+		UpdatedAt:   now.Add(-time.Hour),
+		Version:     2,
+		Discount:    10,
+		Discounts:   []AppliedDiscount{{Type: DiscountPremium, Percent: 10, AmountMinor: 4500}},
+		AmountMinor: 40500,
+	}
+	items := []OrderItem{
+		{ProductId: "p1", ProductQuantity: 1, UnitPriceMinor: 25000, Category: "premium", Name: "Watch", DiscountMinor: 2500},
+		{ProductId: "p2", ProductQuantity: 1, UnitPriceMinor: 12000, Category: "premium", Name: "Sunglasses", DiscountMinor: 1200},
+		{ProductId: "p3", ProductQuantity: 1, UnitPriceMinor: 8000, Category: "premium", Name: "Wallet", DiscountMinor: 800},
+	}
+	if _, err := tenantStore("t1").SaveOrder(o, items); err != nil {
+		t.Fatalf("saving the order failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	GetOrderReceiptHandler(rec, newTenantRequest(http.MethodGet, "/orders/"+o.ID+"/receipt", "", map[string]string{"order_id": o.ID}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getting the receipt answered %v: %s", rec.Code, rec.Body)
+	}
+	var got bytes.Buffer
+	if err := json.Indent(&got, rec.Body.Bytes(), "", "  "); err != nil {
+		t.Fatalf("the receipt is not json: %v", err)
+	}
+	got.WriteString("\n")
+
+	golden := filepath.Join("testdata", "receipt.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+			t.Fatalf("writing the golden file failed: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading the golden file failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("the receipt doesn't match %v, rerun with -update if the change is intended:\n%s", golden, got.Bytes())
+	}
+}
+
+func TestOrderReceiptUnknownOrder(t *testing.T) {
+	useMemoryStores(t)
+	rec := httptest.NewRecorder()
+	GetOrderReceiptHandler(rec, newTenantRequest(http.MethodGet, "/orders/o404/receipt", "", map[string]string{"order_id": "o404"}))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("getting the receipt of an unknown order answered %v, want 404: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/connectivity"
+)
+
+func init() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "orders_product_connection_ready",
+		Help: "Whether the product service connection can serve requests (1) or not (0).",
+	}, func() float64 {
+		if productConnectionReady() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// monitorProductConnection recreates the product service connection when it has been stuck in
+// TransientFailure for longer than GRPC_RECONNECT_AFTER, at most once every GRPC_RECONNECT_MIN_INTERVAL
+// so a product service that stays down doesn't cause a reconnection storm
+func monitorProductConnection(c *grpcProductClient) {
+	reconnectAfter := getEnvDuration("GRPC_RECONNECT_AFTER", 30*time.Second)
+	minInterval := getEnvDuration("GRPC_RECONNECT_MIN_INTERVAL", time.Minute)
+	pollInterval := getEnvDuration("GRPC_STATE_POLL_INTERVAL", 5*time.Second)
+	logger.Info("monitoring the gRPC connection", "reconnect_after", reconnectAfter, "min_interval", minInterval)
+
+	var failingSince, lastReconnect time.Time
+	for {
+		cc := c.clientConn()
+		state := cc.GetState()
+
+		if state != connectivity.TransientFailure {
+			failingSince = time.Time{}
+		} else {
+			now := time.Now()
+			if failingSince.IsZero() {
+				failingSince = now
+			}
+			if now.Sub(failingSince) >= reconnectAfter && now.Sub(lastReconnect) >= minInterval {
+				logger.Warn("recreating the gRPC connection", "state", state, "since", failingSince)
+				newCC, err := dialProductService()
+				if err != nil {
+					logger.Error("failed to recreate the gRPC connection", "err", err)
+				} else {
+					c.replaceConn(newCC)
+					newCC.Connect()
+					logger.Info("recreated the gRPC connection")
+				}
+				lastReconnect = now
+				failingSince = time.Time{}
+				continue
+			}
+		}
+
+		// wake up on the next state change, or after the poll interval to re-check how long it's been failing
+		ctx, cancel := context.WithTimeout(context.Background(), pollInterval)
+		cc.WaitForStateChange(ctx, state)
+		cancel()
+	}
+}
+
+// productConnectionState returns the state of the product service connection,
+// ok is false when the service doesn't use a gRPC connection (e.g. the fake product client)
+func productConnectionState() (state connectivity.State, ok bool) {
+	if productGRPCClient == nil {
+		return connectivity.Idle, false
+	}
+	return productGRPCClient.clientConn().GetState(), true
+}
+
+// productConnectionReady reports whether the product service connection can serve requests,
+// an idle connection is reconnected on the next call so it counts as ready
+func productConnectionReady() bool {
+	state, ok := productConnectionState()
+	return !ok || state == connectivity.Ready || state == connectivity.Idle
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/pborman/uuid"
+)
+
+// requestID returns the id the client sent in X-Request-ID, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return uuid.New()
+}
+
+// recoverMiddleware turns a panicking handler into a 500 with the error envelope instead of a dropped
+// connection, and logs the stack trace with the id of the request. It wraps everything else, so a panic
+// in the other middlewares is recovered too. The request id is the one requestLogMiddleware put in the
+// response header, if it got that far.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// the server aborts the response on purpose with this one
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			ctx := context.WithValue(r.Context(), requestIDContextKey{}, w.Header().Get("X-Request-ID"))
+			logger.ErrorContext(ctx, "panic serving the request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"err", err,
+				"stack", string(debug.Stack()),
+			)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		panic func()
+	}{
+		{"string", func() { panic("boom") }},
+		{"error", func() { panic(errors.New("boom")) }},
+		{"nil map", func() {
+			var m map[string]int
+			m["boom"]++
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) { tt.panic() })
+			mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, map[string]bool{"ok": true}) })
+			srv := httptest.NewServer(recoverMiddleware(requestLogMiddleware(mux)))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/panic")
+			if err != nil {
+				t.Fatalf("request to the panicking handler failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Errorf("status = %v, want %v", resp.StatusCode, http.StatusInternalServerError)
+			}
+			if resp.Header.Get("X-Request-ID") == "" {
+				t.Error("the response has no X-Request-ID")
+			}
+			var body ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("the body is not the error envelope: %v", err)
+			}
+			if body.Status != http.StatusInternalServerError || body.Error == "" {
+				t.Errorf("body = %+v, want the 500 error envelope", body)
+			}
+
+			// the server keeps serving
+			resp, err = http.Get(srv.URL + "/ok")
+			if err != nil {
+				t.Fatalf("request after the panic failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status after the panic = %v, want %v", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
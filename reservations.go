@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// reservedQuantities tracks, per product, the quantity held by placements that passed the inventory
+// check but haven't decremented the product service's inventory yet, so concurrent placements can't
+// be accepted against the same stock
+var (
+	reservationsMu     sync.Mutex
+	reservedQuantities = make(map[string]int64)
+)
+
+var (
+	// quantity every product keeps in stock that can't be ordered through the service, set by SAFETY_STOCK
+	defaultSafetyStock int64
+	// product id -> safety stock overriding the default, set by SAFETY_STOCK_PRODUCTS as a comma separated
+	// list of product_id:quantity pairs
+	productSafetyStock = map[string]int64{}
+)
+
+func loadSafetyStockConfig() {
+	defaultSafetyStock = int64(getEnvInt("SAFETY_STOCK", 0))
+	productSafetyStock = map[string]int64{}
+	for _, entry := range strings.Split(getEnv("SAFETY_STOCK_PRODUCTS", ""), ",") {
+		if entry == "" {
+			continue
+		}
+		productId, quantity, found := strings.Cut(entry, ":")
+		q, err := strconv.ParseInt(quantity, 10, 64)
+		if !found || err != nil || q < 0 {
+			logger.Warn("invalid safety stock entry", "entry", entry)
+			continue
+		}
+		productSafetyStock[strings.TrimSpace(productId)] = q
+	}
+	logger.Info("safety stock loaded", "default", defaultSafetyStock, "products", productSafetyStock)
+}
+
+// safetyStock returns the quantity of the product that must stay in stock
+func safetyStock(productId string) int64 {
+	if q, ok := productSafetyStock[productId]; ok {
+		return q
+	}
+	return defaultSafetyStock
+}
+
+// availableQuantity returns the quantity of the product that can be ordered out of the reported stock
+func availableQuantity(productId string, reported int64) int64 {
+	return reported - safetyStock(productId)
+}
+
+type reservation struct {
+	productId string
+	quantity  int64
+}
+
+// tryReserveQuantity reserves the quantity if it's available after the outstanding reservations
+func tryReserveQuantity(productId string, reported, quantity int64) bool {
+	reservationsMu.Lock()
+	defer reservationsMu.Unlock()
+
+	if reported-reservedQuantities[productId] < quantity {
+		return false
+	}
+	reservedQuantities[productId] += quantity
+	return true
+}
+
+// releaseReservations gives back reservations once the inventory was decremented or the placement failed
+func releaseReservations(reservations []reservation) {
+	reservationsMu.Lock()
+	defer reservationsMu.Unlock()
+
+	for _, res := range reservations {
+		reservedQuantities[res.productId] -= res.quantity
+		if reservedQuantities[res.productId] <= 0 {
+			delete(reservedQuantities, res.productId)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestReservationsReduceAvailability(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	placeP4 := func(quantity string) int {
+		return placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":`+quantity+`}]}`).Code
+	}
+
+	// an in-flight placement holds 95 of the 100 notebooks
+	held := []reservation{{productId: "p4", quantity: 95}}
+	if !tryReserveQuantity("p4", 100, 95) {
+		t.Fatal("reserving the notebooks failed")
+	}
+	released := false
+	release := func() {
+		if !released {
+			releaseReservations(held)
+			released = true
+		}
+	}
+	t.Cleanup(release)
+
+	if code := placeP4("10"); code != http.StatusNotFound {
+		t.Errorf("placing 10 of the 5 unreserved notebooks answered %v, want 404", code)
+	}
+	if code := placeP4("5"); code != http.StatusOK {
+		t.Errorf("placing the 5 unreserved notebooks answered %v, want 200", code)
+	}
+
+	// the reservation is gone once the other placement is done
+	release()
+	if code := placeP4("10"); code != http.StatusOK {
+		t.Errorf("placing 10 notebooks after the release answered %v, want 200", code)
+	}
+	p4, err := fake.GetProductDetails(context.Background(), "p4")
+	if err != nil {
+		t.Fatalf("reading the product failed: %v", err)
+	}
+	if p4.Quantity != 85 {
+		t.Errorf("product quantity = %v, want 85", p4.Quantity)
+	}
+}
+
+func TestSafetyStock(t *testing.T) {
+	tests := []struct {
+		name         string
+		safetyStock  string
+		products     string
+		quantity     string
+		wantStatus   int
+		wantQuantity int64
+	}{
+		// 12 in stock, 3 of them held back
+		{"enough above the safety stock", "3", "", "9", http.StatusOK, 3},
+		{"into the safety stock", "3", "", "10", http.StatusNotFound, 12},
+		{"product override", "3", "p4:5", "8", http.StatusNotFound, 12},
+		{"other products keep the default", "3", "p1:5", "9", http.StatusOK, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			fake := useFakeProductClient(t, fakeSampleProducts()...)
+			fake.SetProduct(&ProductDetails{ID: "p4", Name: "Notebook", Category: "regular", Price: 5, Quantity: 12})
+			t.Setenv("SAFETY_STOCK", tt.safetyStock)
+			t.Setenv("SAFETY_STOCK_PRODUCTS", tt.products)
+			loadSafetyStockConfig()
+			t.Cleanup(func() { defaultSafetyStock, productSafetyStock = 0, map[string]int64{} })
+
+			rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":`+tt.quantity+`}]}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("placing the order answered %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			p4, err := fake.GetProductDetails(context.Background(), "p4")
+			if err != nil {
+				t.Fatalf("reading the product failed: %v", err)
+			}
+			if p4.Quantity != tt.wantQuantity {
+				t.Errorf("product quantity = %v, want %v", p4.Quantity, tt.wantQuantity)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON marshals v and writes it with the given status, a marshal failure is logged and
+// answered with a 500 carrying the error envelope instead
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("error marshaling the response", "err", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"internal server error","status":500}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// ErrorResponse is the envelope of the JSON error responses, Code tells the errors clients need to
+// handle apart without matching the message
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+	Code   string `json:"code,omitempty"`
+}
+
+// writeJSONError writes the message in the error envelope with the given status
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message, Status: status})
+}
+
+// writeJSONErrorCode writes the message in the error envelope with the given status and code
+func writeJSONErrorCode(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message, Status: status, Code: code})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		v          interface{}
+		wantStatus int
+		wantBody   string
+	}{
+		{"value", http.StatusCreated, map[string]string{"id": "o1"}, http.StatusCreated, `{"id":"o1"}`},
+		{"error envelope", http.StatusNotFound, ErrorResponse{Error: "order not found", Status: http.StatusNotFound}, http.StatusNotFound, `{"error":"order not found","status":404}`},
+		{"unsupported value", http.StatusOK, make(chan int), http.StatusInternalServerError, `{"error":"internal server error","status":500}`},
+		{"unsupported float", http.StatusOK, map[string]float64{"amount": math.NaN()}, http.StatusInternalServerError, `{"error":"internal server error","status":500}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeJSON(rec, tt.status, tt.v)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantBody)
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("body %s isn't valid JSON", rec.Body)
+			}
+		})
+	}
+}
+
+func TestErrorResponsesAreJSON(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		req        *http.Request
+		wantStatus int
+		wantError  string
+	}{
+		{"unknown order", GetOrderDetailsHandler, newTenantRequest(http.MethodGet, "/orders/o404", "", map[string]string{"order_id": "o404"}),
+			http.StatusNotFound, "order with id: o404 does not exist"},
+		{"unknown product", PlaceOrderHandler, newTenantRequest(http.MethodPost, "/orders",
+			`{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p404","quantity":1}]}`, nil),
+			http.StatusNotFound, "product with id: p404 does not exist"},
+		{"invalid listing parameter", GetOrdersHandler, newTenantRequest(http.MethodGet, "/orders?limit=0", "", nil),
+			http.StatusBadRequest, "limit must be a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, tt.req)
+			if rec.Code != tt.wantStatus || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("answered %v with content type %q, want %v with json", rec.Code, rec.Header().Get("Content-Type"), tt.wantStatus)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("the body is not the error envelope: %v: %s", err, rec.Body)
+			}
+			if body.Status != tt.wantStatus || body.Error != tt.wantError {
+				t.Errorf("body = %+v, want %v with %q", body, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// productCallTimeout bounds every attempt of a call to the product service so a hanging product
+	// service doesn't block the handlers, set by PRODUCT_CALL_TIMEOUT
+	productCallTimeout = 3 * time.Second
+	// productCallMaxAttempts is the number of attempts of a call failing with a transient error, set by
+	// PRODUCT_CALL_MAX_ATTEMPTS
+	productCallMaxAttempts = 3
+	// backoff before the first retry, doubled for every following one
+	productRetryBaseDelay = 100 * time.Millisecond
+)
+
+// isTransientProductError reports whether the call may succeed if retried, e.g. while the product
+// service restarts
+func isTransientProductError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// retryProductCall makes the call until it succeeds, fails with an error that isn't transient or runs
+// out of attempts. Every attempt gets its own timeout, the backoff between the attempts doubles from
+// productRetryBaseDelay with up to 50% jitter, and no attempt is made once ctx is done.
+func retryProductCall(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	delay := productRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, productCallTimeout)
+		start := time.Now()
+		err := call(callCtx)
+		cancel()
+		recordProductCall(method, start, err)
+		if err == nil || attempt >= productCallMaxAttempts || !isTransientProductError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		logger.WarnContext(ctx, "product service call failed, retrying", "method", method, "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		delay *= 2
+	}
+}
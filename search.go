@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// orderSearch holds the criteria of GET /orders/search, an order has to match all of them
+type orderSearch struct {
+	// the order has one of the statuses
+	statuses  map[OrderStatus]bool
+	minAmount *float64
+	maxAmount *float64
+	// the order has an item of the product
+	productId  string
+	customerId string
+	// free text matched against the search index, the product names, customer, notes and metadata
+	text string
+}
+
+// parseOrderSearch reads the criteria of the search, at least one is required
+func parseOrderSearch(query url.Values) (orderSearch, error) {
+	var search orderSearch
+	if v := query.Get("status"); v != "" {
+		search.statuses = make(map[OrderStatus]bool)
+		for _, s := range strings.Split(v, ",") {
+			status := OrderStatus(strings.TrimSpace(s))
+			if !validOrderStatus(status) {
+				return search, fmt.Errorf("invalid order status: %q", status)
+			}
+			search.statuses[status] = true
+		}
+	}
+	if v := query.Get("min_amount"); v != "" {
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil || amount < 0 {
+			return search, errors.New("min_amount must be a non negative number")
+		}
+		search.minAmount = &amount
+	}
+	if v := query.Get("max_amount"); v != "" {
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil || amount < 0 {
+			return search, errors.New("max_amount must be a non negative number")
+		}
+		search.maxAmount = &amount
+	}
+	if search.minAmount != nil && search.maxAmount != nil && *search.minAmount > *search.maxAmount {
+		return search, errors.New("min_amount can't be greater than max_amount")
+	}
+	search.productId = query.Get("product_id")
+	search.customerId = query.Get("customer_id")
+	search.text = strings.TrimSpace(query.Get("q"))
+
+	if search.statuses == nil && search.minAmount == nil && search.maxAmount == nil && search.productId == "" && search.customerId == "" && search.text == "" {
+		return search, errors.New("at least one of q, status, min_amount, max_amount, product_id or customer_id is required")
+	}
+	return search, nil
+}
+
+// matchesHeader reports whether the order matches the criteria that don't need its items
+func (s orderSearch) matchesHeader(o Order) bool {
+	if s.statuses != nil && !s.statuses[o.Status] {
+		return false
+	}
+	// the bounds are in units of the currency, the orders are compared in its minor units
+	if s.minAmount != nil && o.AmountMinor < toMinorUnits(*s.minAmount, amountCurrency(o)) {
+		return false
+	}
+	if s.maxAmount != nil && o.AmountMinor > toMinorUnits(*s.maxAmount, amountCurrency(o)) {
+		return false
+	}
+	return s.customerId == "" || o.CustomerId == s.customerId
+}
+
+// SearchOrdersHandler finds the orders of the tenant matching the criteria, newest first. The orders
+// are returned without their items.
+func SearchOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	store := tenantStore(tenantFromContext(r.Context()))
+	query := r.URL.Query()
+
+	limit, offset, err := parsePageParams(query)
+	if err != nil {
+		logger.InfoContext(r.Context(), "invalid paging parameters", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	search, err := parseOrderSearch(query)
+	if err != nil {
+		logger.InfoContext(r.Context(), "invalid search criteria", "err", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	// the free text narrows the orders down to the hits of the index, the other criteria scan the store
+	var orders []Order
+	if search.text != "" {
+		if orderSearchIndex == nil {
+			logger.InfoContext(r.Context(), "free text search without a search index")
+			writeJSONError(w, http.StatusBadRequest, "free text search is not enabled")
+			return
+		}
+		orderIds, err := searchOrderIds(tenantFromContext(r.Context()), search.text)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "order search index query failed", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "the order search index is unavailable")
+			return
+		}
+		for _, orderId := range orderIds {
+			// the index may lag behind the store, the store decides
+			o, _, ok, err := store.GetOrder(orderId)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			if ok {
+				orders = append(orders, o)
+			}
+		}
+	} else {
+		orders, err = store.ListOrders()
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	var matching []Order
+	for _, o := range orders {
+		if (isDeleted(o) && !includeDeleted) || !search.matchesHeader(o) {
+			continue
+		}
+		// the items are only read for the orders matching the other criteria
+		if search.productId != "" {
+			_, items, ok, err := store.GetOrder(o.ID)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			if !ok || !hasProduct(items, search.productId) {
+				continue
+			}
+		}
+		matching = append(matching, o)
+	}
+
+	// newest first
+	sortOrders(matching, "created_at", true)
+
+	resp := OrderListResponse{Orders: []CreateOrderResponse{}, Total: len(matching), Limit: limit, Offset: offset}
+	start, end := offset, offset+limit
+	if start > len(matching) {
+		start = len(matching)
+	}
+	if end > len(matching) {
+		end = len(matching)
+	}
+	resp.Links = pageLinks(r, offset, limit, len(matching), "")
+	fields := orderHeaderFields()
+	for _, o := range matching[start:end] {
+		orderDetails := newOrderResponse(o)
+		orderDetails.fields = fields
+		resp.Orders = append(resp.Orders, orderDetails)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func hasProduct(items []OrderItem, productId string) bool {
+	for _, item := range items {
+		if item.ProductId == productId {
+			return true
+		}
+	}
+	return false
+}
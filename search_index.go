@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// orderSearchIndex is the full text index of the orders behind ?q= of GET /orders/search, nil unless
+// ORDER_SEARCH_INDEX=bleve. The store stays the source of truth, the index only narrows down the orders
+// to read from it.
+var orderSearchIndex bleve.Index
+
+// maxSearchIndexHits caps the orders a full text search reads from the store
+const maxSearchIndexHits = 1000
+
+// orderIndexQueue feeds the orders to reindex to the indexer, in the order they changed
+var orderIndexQueue = make(chan orderIndexJob, 1024)
+
+// orderIndexJob asks for the order to be reindexed from its current state in the store
+type orderIndexJob struct {
+	tenantId string
+	orderId  string
+}
+
+// orderDocument is the indexed form of an order
+type orderDocument struct {
+	TenantId     string   `json:"tenant_id"`
+	CustomerId   string   `json:"customer_id"`
+	Status       string   `json:"status"`
+	OrderNumber  int64    `json:"order_number"`
+	CartId       string   `json:"cart_id"`
+	Notes        string   `json:"notes"`
+	Metadata     []string `json:"metadata"`
+	ProductIds   []string `json:"product_ids"`
+	ProductNames []string `json:"product_names"`
+	Categories   []string `json:"categories"`
+}
+
+// loadSearchIndexConfig reads ORDER_SEARCH_INDEX (none/bleve) and ORDER_SEARCH_INDEX_PATH, the directory
+// of the index. Without a path the index is kept in memory. It runs before loadStoreConfig so the stores
+// of the tenants are created indexed.
+func loadSearchIndexConfig() error {
+	switch backend := getEnv("ORDER_SEARCH_INDEX", "none"); backend {
+	case "none":
+		return nil
+	case "bleve":
+	default:
+		return fmt.Errorf("unsupported order search index: %v", backend)
+	}
+
+	path := getEnv("ORDER_SEARCH_INDEX_PATH", "")
+	index, err := openBleveIndex(path)
+	if err != nil {
+		return err
+	}
+	orderSearchIndex = index
+	logger.Info("order search index: bleve", "path", path)
+	return nil
+}
+
+func openBleveIndex(path string) (bleve.Index, error) {
+	if path == "" {
+		return bleve.NewMemOnly(orderIndexMapping())
+	}
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(path, orderIndexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening the bleve index: %v, err: %w", path, err)
+	}
+	return index, nil
+}
+
+// orderIndexMapping matches the tenant exactly and leaves it out of the free text, every other field
+// is searched as text
+func orderIndexMapping() *mapping.IndexMappingImpl {
+	tenant := bleve.NewKeywordFieldMapping()
+	tenant.IncludeInAll = false
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("tenant_id", tenant)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = doc
+	return indexMapping
+}
+
+// orderDocumentId is the id of the order in the index, the orders of the tenants share the index
+func orderDocumentId(tenantId, orderId string) string {
+	return tenantId + "/" + orderId
+}
+
+// indexedStore reindexes the orders it changes. The reindexing happens in the background so a slow
+// product lookup for the product names never holds up a request.
+type indexedStore struct {
+	OrderRepository
+	tenantId string
+	// the orders changed within WithTx, reindexed once the transaction is committed
+	txOrderIds *[]string
+}
+
+// asInMemoryRepository returns the memory store behind the store, if it is one
+func asInMemoryRepository(s OrderRepository) (*InMemoryRepository, bool) {
+	if indexed, ok := s.(*indexedStore); ok {
+		s = indexed.OrderRepository
+	}
+	m, ok := s.(*InMemoryRepository)
+	return m, ok
+}
+
+func (s *indexedStore) reindex(orderId string) {
+	if s.txOrderIds != nil {
+		*s.txOrderIds = append(*s.txOrderIds, orderId)
+		return
+	}
+	select {
+	case orderIndexQueue <- orderIndexJob{tenantId: s.tenantId, orderId: orderId}:
+	default:
+		logger.Warn("order search index queue is full, the order isn't reindexed", "order_id", orderId, "tenant_id", s.tenantId)
+	}
+}
+
+func (s *indexedStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	o, err := s.OrderRepository.SaveOrder(o, items)
+	if err == nil {
+		s.reindex(o.ID)
+	}
+	return o, err
+}
+
+func (s *indexedStore) UpdateOrder(o Order, version int64) error {
+	err := s.OrderRepository.UpdateOrder(o, version)
+	if err == nil {
+		s.reindex(o.ID)
+	}
+	return err
+}
+
+func (s *indexedStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	err := s.OrderRepository.UpdateOrderItems(o, items, version)
+	if err == nil {
+		s.reindex(o.ID)
+	}
+	return err
+}
+
+func (s *indexedStore) MarkFailed(orderId, reason string, now time.Time) error {
+	err := s.OrderRepository.MarkFailed(orderId, reason, now)
+	if err == nil {
+		s.reindex(orderId)
+	}
+	return err
+}
+
+// WithTx reindexes the orders changed by fn once the transaction is committed, the indexer reading them
+// before the commit would index their previous state
+func (s *indexedStore) WithTx(fn func(tx OrderRepository) error) error {
+	if s.txOrderIds != nil {
+		return s.OrderRepository.WithTx(func(tx OrderRepository) error {
+			return fn(&indexedStore{OrderRepository: tx, tenantId: s.tenantId, txOrderIds: s.txOrderIds})
+		})
+	}
+	var orderIds []string
+	err := s.OrderRepository.WithTx(func(tx OrderRepository) error {
+		orderIds = orderIds[:0]
+		return fn(&indexedStore{OrderRepository: tx, tenantId: s.tenantId, txOrderIds: &orderIds})
+	})
+	if err != nil {
+		return err
+	}
+	for _, orderId := range orderIds {
+		s.reindex(orderId)
+	}
+	return nil
+}
+
+func (s *indexedStore) DeleteOrder(orderId string, version int64) error {
+	err := s.OrderRepository.DeleteOrder(orderId, version)
+	if err == nil {
+		s.reindex(orderId)
+	}
+	return err
+}
+
+// runOrderIndexer indexes the orders of the stores when the index is empty, a new index or one kept in
+// memory, and then keeps reindexing the changed orders until the service stops
+func runOrderIndexer() {
+	if orderSearchIndex == nil {
+		return
+	}
+	if count, err := orderSearchIndex.DocCount(); err == nil && count == 0 {
+		indexed := 0
+		tenantsMu.RLock()
+		stores := make(map[string]OrderRepository, len(tenants))
+		for tenantId, t := range tenants {
+			stores[tenantId] = t
+		}
+		tenantsMu.RUnlock()
+		for tenantId, t := range stores {
+			orders, err := t.ListOrders()
+			if err != nil {
+				logger.Error("error listing the orders to index", "tenant_id", tenantId, "err", err)
+				continue
+			}
+			for _, o := range orders {
+				indexOrder(tenantId, o.ID)
+				indexed++
+			}
+		}
+		logger.Info("indexed the stored orders", "orders", indexed)
+	}
+
+	for job := range orderIndexQueue {
+		indexOrder(job.tenantId, job.orderId)
+	}
+}
+
+// indexOrder indexes the current state of the order, an order no longer in the store is removed from the
+// index. The product names are looked up from the product service, an order whose products can't be
+// looked up is indexed without them.
+func indexOrder(tenantId, orderId string) {
+	docId := orderDocumentId(tenantId, orderId)
+	o, items, ok, err := tenantStore(tenantId).GetOrder(orderId)
+	if err != nil {
+		logger.Error("error reading the order to index", "order_id", orderId, "tenant_id", tenantId, "err", err)
+		return
+	}
+	if !ok {
+		if err := orderSearchIndex.Delete(docId); err != nil {
+			logger.Error("error removing the order from the search index", "order_id", orderId, "err", err)
+		}
+		return
+	}
+
+	doc := orderDocument{
+		TenantId:    tenantId,
+		CustomerId:  o.CustomerId,
+		Status:      string(o.Status),
+		OrderNumber: o.OrderNumber,
+		CartId:      o.CartId,
+		Notes:       o.Notes,
+	}
+	for key, value := range o.Metadata {
+		doc.Metadata = append(doc.Metadata, key+" "+value)
+	}
+	productIds := make([]string, 0, len(items))
+	for _, item := range items {
+		productIds = append(productIds, item.ProductId)
+		doc.Categories = append(doc.Categories, item.Category)
+	}
+	doc.ProductIds = productIds
+	if len(productIds) > 0 {
+		products, err := productClient.ListProductDetails(context.Background(), productIds)
+		if err != nil {
+			logger.Warn("indexing the order without its product names", "order_id", orderId, "err", err)
+		}
+		for _, p := range products {
+			doc.ProductNames = append(doc.ProductNames, p.Name)
+		}
+	}
+
+	if err := orderSearchIndex.Index(docId, doc); err != nil {
+		logger.Error("error indexing the order", "order_id", orderId, "tenant_id", tenantId, "err", err)
+	}
+}
+
+// searchOrderIds returns the ids of the orders of the tenant matching every word of the text, at most
+// maxSearchIndexHits of them
+func searchOrderIds(tenantId, text string) ([]string, error) {
+	tenant := bleve.NewTermQuery(tenantId)
+	tenant.SetField("tenant_id")
+	words := bleve.NewMatchQuery(text)
+	words.SetOperator(query.MatchQueryOperatorAnd)
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(tenant, words), maxSearchIndexHits, 0, false)
+	res, err := orderSearchIndex.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	orderIds := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		orderIds = append(orderIds, strings.TrimPrefix(hit.ID, tenantId+"/"))
+	}
+	return orderIds, nil
+}
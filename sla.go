@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// orderSLAs holds the maximum time an order may stay in a status before it is flagged as breached,
+// statuses without an entry (the terminal ones) are never flagged
+var orderSLAs = map[OrderStatus]time.Duration{}
+
+// slaScanInterval is how often the background scan refreshes the breach flags and the metric, set by
+// ORDER_SLA_SCAN_INTERVAL, 0 disables the scan
+var slaScanInterval = time.Minute
+
+var slaBreachedOrders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "orders_sla_breached",
+	Help: "Number of orders that have been in their current status longer than its SLA, by status.",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(slaBreachedOrders)
+}
+
+func loadSLAConfig() {
+	orderSLAs = map[OrderStatus]time.Duration{
+		OrderPlaced:         getEnvDuration("ORDER_SLA_PLACED", 2*time.Hour),
+		OrderConfirmed:      getEnvDuration("ORDER_SLA_CONFIRMED", 24*time.Hour),
+		OrderPacked:         getEnvDuration("ORDER_SLA_PACKED", 24*time.Hour),
+		OrderDispatched:     getEnvDuration("ORDER_SLA_DISPATCHED", 72*time.Hour),
+		OrderShipped:        getEnvDuration("ORDER_SLA_SHIPPED", 72*time.Hour),
+		OrderOutForDelivery: getEnvDuration("ORDER_SLA_OUT_FOR_DELIVERY", 24*time.Hour),
+	}
+	slaScanInterval = getEnvDuration("ORDER_SLA_SCAN_INTERVAL", slaScanInterval)
+	logger.Info("order status SLAs loaded", "slas", orderSLAs, "scan_interval", slaScanInterval)
+}
+
+// runSLAScanner scans the orders for SLA breaches every slaScanInterval until the service stops
+func runSLAScanner() {
+	if slaScanInterval <= 0 {
+		logger.Info("order SLA scan disabled")
+		return
+	}
+
+	ticker := time.NewTicker(slaScanInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		scanSLABreaches()
+	}
+}
+
+// slaBreached reports whether the order has been in its current status longer than the SLA allows
+func slaBreached(o Order, now time.Time) bool {
+	sla, ok := orderSLAs[o.Status]
+	if !ok || sla <= 0 {
+		return false
+	}
+	return now.Sub(o.StatusChangedAt) > sla
+}
+
+// scanSLABreaches flags the orders of every tenant stuck in their status past the SLA and refreshes the breach metric
+func scanSLABreaches() {
+	now := clock.Now()
+
+	breaches := make(map[OrderStatus]int)
+	for _, t := range tenantStores() {
+		tenantBreaches, err := t.RefreshSLAFlags(now)
+		if err != nil {
+			logger.Error("error refreshing the SLA flags", "err", err)
+			continue
+		}
+		for status, count := range tenantBreaches {
+			breaches[status] += count
+		}
+	}
+
+	slaBreachedOrders.Reset()
+	for status, count := range breaches {
+		slaBreachedOrders.WithLabelValues(string(status)).Set(float64(count))
+	}
+}
+
+func (s *InMemoryRepository) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	breaches := make(map[OrderStatus]int)
+	for id, o := range s.orders {
+		isBreached := slaBreached(o, now)
+		if o.SlaBreached != isBreached {
+			o.SlaBreached = isBreached
+			s.orders[id] = o
+		}
+		if isBreached {
+			breaches[o.Status]++
+		}
+	}
+	return breaches, nil
+}
+
+type SLABreachResponse struct {
+	ID            string      `json:"id"`
+	Status        OrderStatus `json:"status"`
+	InStatusSince Timestamp   `json:"in_status_since"`
+	SLA           string      `json:"sla"`
+	OverdueBy     string      `json:"overdue_by"`
+}
+
+// GetSLABreachesHandler lists the orders of the caller's tenant past their SLA. It only reads, the
+// breaches are checked against the current time so they don't wait for the next background scan.
+func GetSLABreachesHandler(w http.ResponseWriter, r *http.Request) {
+	now := clock.Now()
+	breaches := []SLABreachResponse{}
+
+	store := tenantStore(tenantFromContext(r.Context()))
+	orders, err := store.ListOrders()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for _, o := range orders {
+		if !slaBreached(o, now) {
+			continue
+		}
+		sla := orderSLAs[o.Status]
+		breaches = append(breaches, SLABreachResponse{
+			ID:            o.ID,
+			Status:        o.Status,
+			InStatusSince: Timestamp{o.StatusChangedAt},
+			SLA:           sla.String(),
+			OverdueBy:     (now.Sub(o.StatusChangedAt) - sla).Round(time.Second).String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, breaches)
+}
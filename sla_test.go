@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClock is a Clock set by the tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// useFakeClock replaces the clock and the tenants for the duration of the test
+func useFakeClock(t *testing.T, now time.Time) *fakeClock {
+	t.Helper()
+	useMemoryStores(t)
+	fake := &fakeClock{now: now}
+	prev := clock
+	clock = fake
+	t.Cleanup(func() { clock = prev })
+	return fake
+}
+
+func TestSLABreaches(t *testing.T) {
+	prevSLAs := orderSLAs
+	orderSLAs = map[OrderStatus]time.Duration{OrderPlaced: 2 * time.Hour, OrderShipped: 72 * time.Hour}
+	t.Cleanup(func() { orderSLAs = prevSLAs })
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		status   OrderStatus
+		elapsed  time.Duration
+		breached bool
+	}{
+		{"placed within the SLA", OrderPlaced, time.Hour, false},
+		{"placed at the SLA", OrderPlaced, 2 * time.Hour, false},
+		{"placed past the SLA", OrderPlaced, 2*time.Hour + time.Second, true},
+		{"shipped within the SLA", OrderShipped, 71 * time.Hour, false},
+		{"shipped past the SLA", OrderShipped, 73 * time.Hour, true},
+		{"status without an SLA", OrderCompleted, 1000 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeClock(t, start)
+			store := tenantStore("t1")
+			other := tenantStore("t2")
+			o := Order{ID: "o1", Status: tt.status, StatusChangedAt: start, TenantId: "t1"}
+			if _, err := store.SaveOrder(o, nil); err != nil {
+				t.Fatalf("saving the order failed: %v", err)
+			}
+			// a breach of another tenant is neither listed nor touched by the handler
+			if _, err := other.SaveOrder(Order{ID: "o2", Status: OrderPlaced, StatusChangedAt: start.Add(-24 * time.Hour), TenantId: "t2"}, nil); err != nil {
+				t.Fatalf("saving the order failed: %v", err)
+			}
+
+			fake.now = start.Add(tt.elapsed)
+			req := httptest.NewRequest(http.MethodGet, "/orders/sla-breaches", nil)
+			req = req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, "t1"))
+			rec := httptest.NewRecorder()
+			GetSLABreachesHandler(rec, req)
+
+			var breaches []SLABreachResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &breaches); err != nil {
+				t.Fatalf("decoding the breaches failed: %v", err)
+			}
+			if got := len(breaches) == 1 && breaches[0].ID == "o1"; got != tt.breached {
+				t.Errorf("breaches = %+v, want o1 listed: %v", breaches, tt.breached)
+			}
+			if stored, _, _, _ := other.GetOrder("o2"); stored.SlaBreached {
+				t.Error("the handler flagged the order of another tenant")
+			}
+
+			scanSLABreaches()
+			if stored, _, _, _ := store.GetOrder("o1"); stored.SlaBreached != tt.breached {
+				t.Errorf("SlaBreached = %v after the scan, want %v", stored.SlaBreached, tt.breached)
+			}
+			// o2 of the other tenant is a placed breach too
+			want := 0.0
+			if tt.status == OrderPlaced {
+				want++
+			}
+			if tt.breached {
+				want++
+			}
+			if got := testutil.ToFloat64(slaBreachedOrders.WithLabelValues(string(tt.status))); got != want {
+				t.Errorf("orders_sla_breached{status=%q} = %v, want %v", tt.status, got, want)
+			}
+
+			// moving on clears the flag at the next scan
+			moved, _, _, _ := store.GetOrder("o1")
+			moved.Status, moved.StatusChangedAt = OrderCompleted, fake.now
+			if err := store.UpdateOrder(moved, moved.Version); err != nil {
+				t.Fatalf("updating the order failed: %v", err)
+			}
+			scanSLABreaches()
+			if stored, _, _, _ := store.GetOrder("o1"); stored.SlaBreached {
+				t.Error("SlaBreached is still set after the order left the status")
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotPath is the file the memory store is snapshotted to, set by MEMORY_STORE_SNAPSHOT_PATH, empty
+// disables the snapshots
+var snapshotPath = ""
+
+// snapshotInterval is the time between two snapshots, set by MEMORY_STORE_SNAPSHOT_INTERVAL
+var snapshotInterval = time.Minute
+
+// memorySnapshot is the file format of the snapshots, the stores of every tenant at the time it was taken
+type memorySnapshot struct {
+	TakenAt string `json:"taken_at"`
+	// tenant id -> the encoded memoryStoreSnapshot of the tenant
+	Tenants map[string]json.RawMessage `json:"tenants"`
+}
+
+// memoryStoreSnapshot holds the state of a InMemoryRepository, the index of order id -> idempotency key is
+// rebuilt on restore
+type memoryStoreSnapshot struct {
+	Orders          map[string]Order             `json:"orders"`
+	Items           map[string][]OrderItem       `json:"items"`
+	OrdersByCartId  map[string]string            `json:"orders_by_cart_id"`
+	OrdersByNumber  map[int64]string             `json:"orders_by_number"`
+	LastOrderNumber int64                        `json:"last_order_number"`
+	IdempotencyKeys map[string]IdempotencyRecord `json:"idempotency_keys"`
+}
+
+// snapshot returns the state of the store, encoded under the read lock so writers only wait for the copy
+func (s *InMemoryRepository) snapshot() (json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(memoryStoreSnapshot{
+		Orders:          s.orders,
+		Items:           s.items,
+		OrdersByCartId:  s.ordersByCartId,
+		OrdersByNumber:  s.ordersByNumber,
+		LastOrderNumber: s.lastOrderNumber,
+		IdempotencyKeys: s.idempotencyKeys,
+	})
+}
+
+// restore replaces the state of the store with the snapshot
+func (s *InMemoryRepository) restore(snap memoryStoreSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders = snap.Orders
+	s.items = snap.Items
+	s.ordersByCartId = snap.OrdersByCartId
+	s.ordersByNumber = snap.OrdersByNumber
+	s.lastOrderNumber = snap.LastOrderNumber
+	s.idempotencyKeys = snap.IdempotencyKeys
+	// a snapshot written by an older version may lack some of the maps
+	if s.orders == nil {
+		s.orders = make(map[string]Order)
+	}
+	if s.items == nil {
+		s.items = make(map[string][]OrderItem)
+	}
+	if s.ordersByCartId == nil {
+		s.ordersByCartId = make(map[string]string)
+	}
+	if s.ordersByNumber == nil {
+		s.ordersByNumber = make(map[int64]string)
+	}
+	if s.idempotencyKeys == nil {
+		s.idempotencyKeys = make(map[string]IdempotencyRecord)
+	}
+	s.idempotencyKeysByOrder = make(map[string]string, len(s.idempotencyKeys))
+	for key, record := range s.idempotencyKeys {
+		s.idempotencyKeysByOrder[record.OrderId] = key
+	}
+}
+
+// loadSnapshotConfig reads MEMORY_STORE_SNAPSHOT_PATH and MEMORY_STORE_SNAPSHOT_INTERVAL and restores the
+// orders from the last snapshot. Snapshots only apply to the memory store, the other stores persist the
+// orders themselves.
+func loadSnapshotConfig() error {
+	snapshotPath = getEnv("MEMORY_STORE_SNAPSHOT_PATH", "")
+	if snapshotPath == "" {
+		return nil
+	}
+	if backend := getEnv("ORDER_STORE", "memory"); backend != "memory" {
+		logger.Warn("memory store snapshots disabled, the order store isn't the memory store", "order_store", backend)
+		snapshotPath = ""
+		return nil
+	}
+	snapshotInterval = getEnvDuration("MEMORY_STORE_SNAPSHOT_INTERVAL", snapshotInterval)
+	if snapshotInterval <= 0 {
+		return fmt.Errorf("memory store snapshot interval must be positive, got: %v", snapshotInterval)
+	}
+	return restoreSnapshot(snapshotPath)
+}
+
+// restoreSnapshot loads the stores of the tenants from the snapshot file, a missing file is a first start
+func restoreSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("no memory store snapshot to restore", "path", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading the memory store snapshot: %v, err: %w", path, err)
+	}
+
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("invalid memory store snapshot: %v, err: %w", path, err)
+	}
+	orders := 0
+	for tenantId, data := range snap.Tenants {
+		var tenantSnap memoryStoreSnapshot
+		if err := json.Unmarshal(data, &tenantSnap); err != nil {
+			return fmt.Errorf("invalid memory store snapshot of tenant: %v, err: %w", tenantId, err)
+		}
+		if m, ok := asInMemoryRepository(tenantStore(tenantId)); ok {
+			m.restore(tenantSnap)
+			orders += len(tenantSnap.Orders)
+		}
+	}
+	logger.Info("restored the memory store snapshot", "path", path, "taken_at", snap.TakenAt, "tenants", len(snap.Tenants), "orders", orders)
+	return nil
+}
+
+// runSnapshots snapshots the memory store every snapshotInterval until the service stops
+func runSnapshots() {
+	if snapshotPath == "" {
+		return
+	}
+	logger.Info("snapshotting the memory store", "path", snapshotPath, "interval", snapshotInterval)
+
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := writeSnapshot(snapshotPath); err != nil {
+			logger.Error("error writing the memory store snapshot", "path", snapshotPath, "err", err)
+		}
+	}
+}
+
+// writeSnapshot writes the stores of every tenant to the snapshot file. The snapshot goes to a temporary
+// file renamed over the previous one, so a crash mid write leaves the previous snapshot intact.
+func writeSnapshot(path string) error {
+	tenantsMu.RLock()
+	stores := make(map[string]*InMemoryRepository, len(tenants))
+	for tenantId, t := range tenants {
+		if m, ok := asInMemoryRepository(t); ok {
+			stores[tenantId] = m
+		}
+	}
+	tenantsMu.RUnlock()
+
+	snap := memorySnapshot{
+		TakenAt: formatTimestamp(clock.Now()),
+		Tenants: make(map[string]json.RawMessage, len(stores)),
+	}
+	for tenantId, m := range stores {
+		data, err := m.snapshot()
+		if err != nil {
+			return fmt.Errorf("error encoding the orders of tenant: %v, err: %w", tenantId, err)
+		}
+		snap.Tenants[tenantId] = data
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	logger.Debug("wrote the memory store snapshot", "path", path, "tenants", len(stores))
+	return nil
+}
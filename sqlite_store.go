@@ -0,0 +1,622 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are the schema migrations of the sqlite store. The first one adopts the databases
+// created before the migrations, whose tables already exist.
+var sqliteMigrations = []migration{
+	{1, "create the orders, order items and idempotency keys tables", execStatements(
+		`CREATE TABLE IF NOT EXISTS orders (
+			tenant_id         TEXT NOT NULL,
+			id                TEXT NOT NULL,
+			discount          INTEGER NOT NULL,
+			amount            REAL NOT NULL,
+			currency          TEXT NOT NULL,
+			status            TEXT NOT NULL,
+			dispatched_at     TEXT NOT NULL,
+			created_at        TEXT NOT NULL,
+			updated_at        TEXT NOT NULL,
+			status_changed_at TEXT NOT NULL,
+			sla_breached      INTEGER NOT NULL,
+			cart_id           TEXT NOT NULL,
+			discounts         TEXT NOT NULL,
+			version           INTEGER NOT NULL,
+			order_number      INTEGER NOT NULL,
+			notes             TEXT NOT NULL,
+			metadata          TEXT NOT NULL,
+			priority          TEXT NOT NULL,
+			callback_url      TEXT NOT NULL,
+			failure_reason    TEXT NOT NULL,
+			PRIMARY KEY (tenant_id, id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS orders_cart_id ON orders (tenant_id, cart_id)`,
+		`CREATE INDEX IF NOT EXISTS orders_order_number ON orders (tenant_id, order_number)`,
+		`CREATE TABLE IF NOT EXISTS order_items (
+			tenant_id  TEXT NOT NULL,
+			order_id   TEXT NOT NULL,
+			position   INTEGER NOT NULL,
+			product_id TEXT NOT NULL,
+			quantity   INTEGER NOT NULL,
+			unit_price REAL NOT NULL,
+			category   TEXT NOT NULL,
+			discount   REAL NOT NULL,
+			PRIMARY KEY (tenant_id, order_id, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			tenant_id    TEXT NOT NULL,
+			key          TEXT NOT NULL,
+			request_hash TEXT NOT NULL,
+			order_id     TEXT NOT NULL,
+			PRIMARY KEY (tenant_id, key)
+		)`,
+	)},
+	{2, "add the customer id to the orders", func(tx *sql.Tx) error {
+		// the databases created before the migrations may have the column already
+		var exists bool
+		err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('orders') WHERE name = 'customer_id'`).Scan(&exists)
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec(`ALTER TABLE orders ADD COLUMN customer_id TEXT NOT NULL DEFAULT ''`)
+		return err
+	}},
+	{3, "add the refund to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN refund TEXT NOT NULL DEFAULT ''`,
+	)},
+	{4, "add the deletion time to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN deleted_at TEXT NOT NULL DEFAULT ''`,
+	)},
+	{5, "add the status history to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN status_history TEXT NOT NULL DEFAULT ''`,
+	)},
+	{6, "add the cancellation and return reason to the orders", execStatements(
+		`ALTER TABLE orders ADD COLUMN status_reason TEXT NOT NULL DEFAULT ''`,
+	)},
+	{7, "add the product name to the order items", execStatements(
+		`ALTER TABLE order_items ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+	)},
+	{8, "keep the amounts in the minor units of the currency", migrationSteps(
+		execStatements(
+			`ALTER TABLE orders ADD COLUMN amount_minor INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE order_items ADD COLUMN unit_price_minor INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE order_items ADD COLUMN discount_minor INTEGER NOT NULL DEFAULT 0`,
+		),
+		convertToMinorUnits(sqliteDialect),
+		execStatements(
+			`ALTER TABLE orders DROP COLUMN amount`,
+			`ALTER TABLE order_items DROP COLUMN unit_price`,
+			`ALTER TABLE order_items DROP COLUMN discount`,
+		),
+	)},
+}
+
+// orderColumns are the columns of the orders table, shared by the SQL stores
+const orderColumns = `id, tenant_id, discount, amount_minor, currency, status, dispatched_at, created_at, updated_at,
+	status_changed_at, sla_breached, cart_id, discounts, version, order_number, notes, metadata, priority, callback_url,
+	failure_reason, customer_id, refund, deleted_at, status_history, status_reason`
+
+// openSQLiteDB opens the database file and migrates its schema. SQLite allows a single writer, so the pool
+// is limited to one connection and the transactions are serialized.
+func openSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the sqlite database: %v, err: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := migrateSchema(db, sqliteDialect, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqlTenants returns the tenants with orders in the database of a SQL store
+func sqlTenants(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT tenant_id FROM orders`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenantIds []string
+	for rows.Next() {
+		var tenantId string
+		if err := rows.Scan(&tenantId); err != nil {
+			return nil, err
+		}
+		tenantIds = append(tenantIds, tenantId)
+	}
+	return tenantIds, rows.Err()
+}
+
+// SQLiteStore keeps the orders of a single tenant in a SQLite database so they survive a restart, every
+// tenant shares the database and its rows are scoped by the tenant id
+type SQLiteStore struct {
+	db *sql.DB
+	// the transaction of WithTx the calls run in, nil outside of one
+	tx       *sql.Tx
+	tenantId string
+}
+
+func NewSQLiteStore(db *sql.DB, tenantId string) *SQLiteStore {
+	return &SQLiteStore{db: db, tenantId: tenantId}
+}
+
+// conn returns the transaction of WithTx the calls run in, the database otherwise. With its single
+// connection a SQLite call within WithTx must never use the database directly.
+func (s *SQLiteStore) conn() sqlConn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *SQLiteStore) begin() (sqlTx, error) {
+	return beginSQLTx(s.db, s.tx)
+}
+
+// WithTx runs fn in a single SQL transaction
+func (s *SQLiteStore) WithTx(fn func(tx OrderRepository) error) error {
+	return runSQLTx(s.db, s.tx, func(tx *sql.Tx) error {
+		return fn(&SQLiteStore{db: s.db, tx: tx, tenantId: s.tenantId})
+	})
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlExecer is implemented by *sql.DB and *sql.Tx, so a statement runs the same on its own or within a
+// transaction
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlConn is implemented by *sql.DB and *sql.Tx, the reads of a SQL store run on the transaction of
+// WithTx when there is one
+type sqlConn interface {
+	sqlExecer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlTx is the transaction of a single store call
+type sqlTx interface {
+	sqlConn
+	Commit() error
+	Rollback() error
+}
+
+// beginSQLTx starts the transaction of a store call. Within the transaction of WithTx the call runs in a
+// savepoint of it instead, so a failed call is undone without aborting the whole transaction.
+func beginSQLTx(db *sql.DB, tx *sql.Tx) (sqlTx, error) {
+	if tx == nil {
+		return db.Begin()
+	}
+	if _, err := tx.Exec(`SAVEPOINT store_call`); err != nil {
+		return nil, err
+	}
+	return &savepointTx{Tx: tx}, nil
+}
+
+// savepointTx is a store call within the transaction of WithTx, its commit releases the savepoint and
+// leaves committing the transaction to WithTx
+type savepointTx struct {
+	*sql.Tx
+	done bool
+}
+
+func (t *savepointTx) Commit() error {
+	t.done = true
+	_, err := t.Tx.Exec(`RELEASE SAVEPOINT store_call`)
+	return err
+}
+
+// Rollback after Commit does nothing, like the Rollback of a committed *sql.Tx
+func (t *savepointTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	_, err := t.Tx.Exec(`ROLLBACK TO SAVEPOINT store_call`)
+	return err
+}
+
+// runSQLTx runs fn in a transaction of the database committed once fn returns nil. Within the
+// transaction of WithTx, fn runs in a savepoint of it.
+func runSQLTx(db *sql.DB, outer *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if outer != nil {
+		savepoint, err := beginSQLTx(db, outer)
+		if err != nil {
+			return err
+		}
+		defer savepoint.Rollback()
+		if err := fn(outer); err != nil {
+			return err
+		}
+		return savepoint.Commit()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanOrder(row rowScanner) (Order, error) {
+	var o Order
+	var status, dispatchedAt, createdAt, updatedAt, statusChangedAt, discounts, metadata, priority, refund, deletedAt, history, statusReason string
+	err := row.Scan(&o.ID, &o.TenantId, &o.Discount, &o.AmountMinor, &o.Currency, &status, &dispatchedAt, &createdAt,
+		&updatedAt, &statusChangedAt, &o.SlaBreached, &o.CartId, &discounts, &o.Version, &o.OrderNumber, &o.Notes,
+		&metadata, &priority, &o.CallbackURL, &o.FailureReason, &o.CustomerId, &refund, &deletedAt, &history, &statusReason)
+	if err != nil {
+		return o, err
+	}
+	o.Status = OrderStatus(status)
+	o.Priority = OrderPriority(priority)
+	// rows written before the timestamps were RFC 3339 are in the legacy layout
+	if o.DispatchedAt, err = parseStoredTimestamp(dispatchedAt); err != nil {
+		return o, fmt.Errorf("invalid dispatched at of order: %v, err: %w", o.ID, err)
+	}
+	if o.CreatedAt, err = parseStoredTimestamp(createdAt); err != nil {
+		return o, fmt.Errorf("invalid created at of order: %v, err: %w", o.ID, err)
+	}
+	if o.UpdatedAt, err = parseStoredTimestamp(updatedAt); err != nil {
+		return o, fmt.Errorf("invalid updated at of order: %v, err: %w", o.ID, err)
+	}
+	if o.StatusChangedAt, err = time.Parse(time.RFC3339Nano, statusChangedAt); err != nil {
+		return o, fmt.Errorf("invalid status changed at of order: %v, err: %w", o.ID, err)
+	}
+	if o.DeletedAt, err = parseStoredTimestamp(deletedAt); err != nil {
+		return o, fmt.Errorf("invalid deleted at of order: %v, err: %w", o.ID, err)
+	}
+	if err := json.Unmarshal([]byte(discounts), &o.Discounts); err != nil {
+		return o, fmt.Errorf("invalid discounts of order: %v, err: %w", o.ID, err)
+	}
+	if err := json.Unmarshal([]byte(metadata), &o.Metadata); err != nil {
+		return o, fmt.Errorf("invalid metadata of order: %v, err: %w", o.ID, err)
+	}
+	// only returned orders have a refund
+	if refund != "" {
+		if err := json.Unmarshal([]byte(refund), &o.Refund); err != nil {
+			return o, fmt.Errorf("invalid refund of order: %v, err: %w", o.ID, err)
+		}
+	}
+	// the orders placed before the history was recorded have none
+	if history != "" {
+		if err := json.Unmarshal([]byte(history), &o.History); err != nil {
+			return o, fmt.Errorf("invalid status history of order: %v, err: %w", o.ID, err)
+		}
+	}
+	if statusReason != "" {
+		if err := json.Unmarshal([]byte(statusReason), &o.StatusReason); err != nil {
+			return o, fmt.Errorf("invalid status reason of order: %v, err: %w", o.ID, err)
+		}
+	}
+	return o, nil
+}
+
+// orderValues returns the column values of the order in the order of orderColumns
+func orderValues(o Order) ([]interface{}, error) {
+	discounts, err := json.Marshal(o.Discounts)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := json.Marshal(o.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	var refund []byte
+	if o.Refund != nil {
+		if refund, err = json.Marshal(o.Refund); err != nil {
+			return nil, err
+		}
+	}
+	var history []byte
+	if len(o.History) > 0 {
+		if history, err = json.Marshal(o.History); err != nil {
+			return nil, err
+		}
+	}
+	var statusReason []byte
+	if o.StatusReason != nil {
+		if statusReason, err = json.Marshal(o.StatusReason); err != nil {
+			return nil, err
+		}
+	}
+	return []interface{}{o.ID, o.TenantId, o.Discount, o.AmountMinor, o.Currency, string(o.Status), formatOptionalTimestamp(o.DispatchedAt),
+		formatTimestamp(o.CreatedAt), formatTimestamp(o.UpdatedAt), o.StatusChangedAt.Format(time.RFC3339Nano), o.SlaBreached, o.CartId,
+		string(discounts), o.Version, o.OrderNumber, o.Notes, string(metadata), string(o.Priority), o.CallbackURL,
+		o.FailureReason, o.CustomerId, string(refund), formatOptionalTimestamp(o.DeletedAt), string(history), string(statusReason)}, nil
+}
+
+// SaveOrder numbers the order within the transaction, so the numbers carry on after a restart
+func (s *SQLiteStore) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return o, err
+	}
+	defer tx.Rollback()
+
+	if o.Status != OrderPending && o.OrderNumber == 0 {
+		var lastOrderNumber int64
+		err := tx.QueryRow(`SELECT COALESCE(MAX(order_number), 0) FROM orders WHERE tenant_id = ?`, s.tenantId).Scan(&lastOrderNumber)
+		if err != nil {
+			return o, err
+		}
+		o.OrderNumber = lastOrderNumber + 1
+	}
+
+	values, err := orderValues(o)
+	if err != nil {
+		return o, err
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO orders (`+orderColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, values...)
+	if err != nil {
+		return o, err
+	}
+
+	if err := s.replaceItems(tx, o.ID, items); err != nil {
+		return o, err
+	}
+	return o, tx.Commit()
+}
+
+// replaceItems replaces the stored items of the order with the items
+func (s *SQLiteStore) replaceItems(tx sqlExecer, orderId string, items []OrderItem) error {
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE tenant_id = ? AND order_id = ?`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	for i, item := range items {
+		_, err := tx.Exec(`INSERT INTO order_items (tenant_id, order_id, position, product_id, quantity, unit_price_minor, category, discount_minor, name)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.tenantId, orderId, i, item.ProductId, item.ProductQuantity, item.UnitPriceMinor, item.Category, item.DiscountMinor, item.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	row := s.conn().QueryRow(`SELECT `+orderColumns+` FROM orders WHERE tenant_id = ? AND id = ?`, s.tenantId, orderId)
+	o, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, nil, false, nil
+	}
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+
+	rows, err := s.conn().Query(`SELECT product_id, quantity, unit_price_minor, category, discount_minor, name FROM order_items
+		WHERE tenant_id = ? AND order_id = ? ORDER BY position`, s.tenantId, orderId)
+	if err != nil {
+		return Order{}, nil, false, err
+	}
+	defer rows.Close()
+
+	var items []OrderItem
+	for rows.Next() {
+		item := OrderItem{OrderId: orderId}
+		if err := rows.Scan(&item.ProductId, &item.ProductQuantity, &item.UnitPriceMinor, &item.Category, &item.DiscountMinor, &item.Name); err != nil {
+			return Order{}, nil, false, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Order{}, nil, false, err
+	}
+	return o, items, true, nil
+}
+
+func (s *SQLiteStore) ListOrders() ([]Order, error) {
+	rows, err := s.conn().Query(`SELECT `+orderColumns+` FROM orders WHERE tenant_id = ?`, s.tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// UpdateOrder leaves the items of the order untouched, UpdateOrderItems changes them
+func (s *SQLiteStore) UpdateOrder(o Order, version int64) error {
+	return s.updateOrder(s.conn(), o, version)
+}
+
+func (s *SQLiteStore) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.updateOrder(tx, o, version); err != nil {
+		return err
+	}
+	if err := s.replaceItems(tx, o.ID, items); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) updateOrder(db sqlExecer, o Order, version int64) error {
+	values, err := orderValues(o)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`UPDATE orders SET (`+orderColumns+`) = (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		WHERE tenant_id = ? AND id = ? AND version = ?`, append(values, s.tenantId, o.ID, version)...)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// FindByCartId skips the failed orders, their carts are free to be ordered again
+func (s *SQLiteStore) FindByCartId(cartId string) (string, bool, error) {
+	var orderId string
+	err := s.conn().QueryRow(`SELECT id FROM orders WHERE tenant_id = ? AND cart_id = ? AND status != ? LIMIT 1`,
+		s.tenantId, cartId, string(OrderFailed)).Scan(&orderId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return orderId, err == nil, err
+}
+
+func (s *SQLiteStore) FindByNumber(orderNumber int64) (string, bool, error) {
+	var orderId string
+	err := s.conn().QueryRow(`SELECT id FROM orders WHERE tenant_id = ? AND order_number = ?`,
+		s.tenantId, orderNumber).Scan(&orderId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return orderId, err == nil, err
+}
+
+// MarkFailed appends to the status history within the transaction of the update
+func (s *SQLiteStore) MarkFailed(orderId, reason string, now time.Time) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	history, err := appendFailedStatusChange(tx.QueryRow(`SELECT status_history FROM orders WHERE tenant_id = ? AND id = ?`, s.tenantId, orderId), now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE orders SET status = ?, failure_reason = ?, status_changed_at = ?, updated_at = ?, status_history = ?, version = version + 1
+		WHERE tenant_id = ? AND id = ?`,
+		string(OrderFailed), reason, now.Format(time.RFC3339Nano), formatTimestamp(now), history, s.tenantId, orderId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendFailedStatusChange appends the failure of the placement to the stored status history of the row
+func appendFailedStatusChange(row rowScanner, now time.Time) (string, error) {
+	var stored string
+	if err := row.Scan(&stored); err != nil {
+		return "", err
+	}
+	var history []StatusChange
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &history); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(append(history, failedStatusChange(now)))
+	return string(data), err
+}
+
+func (s *SQLiteStore) DeleteOrder(orderId string, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM orders WHERE tenant_id = ? AND id = ? AND version = ?`, s.tenantId, orderId, version)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errVersionConflict
+	}
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE tenant_id = ? AND order_id = ?`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE tenant_id = ? AND order_id = ?`, s.tenantId, orderId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimIdempotencyKey relies on the primary key, a key claimed concurrently is ignored by the insert
+func (s *SQLiteStore) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	res, err := s.conn().Exec(`INSERT OR IGNORE INTO idempotency_keys (tenant_id, key, request_hash, order_id) VALUES (?, ?, ?, ?)`,
+		s.tenantId, key, record.RequestHash, record.OrderId)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if inserted == 1 {
+		return record, true, nil
+	}
+
+	var existing IdempotencyRecord
+	err = s.conn().QueryRow(`SELECT request_hash, order_id FROM idempotency_keys WHERE tenant_id = ? AND key = ?`,
+		s.tenantId, key).Scan(&existing.RequestHash, &existing.OrderId)
+	return existing, false, err
+}
+
+func (s *SQLiteStore) ReleaseIdempotencyKey(key string) error {
+	_, err := s.conn().Exec(`DELETE FROM idempotency_keys WHERE tenant_id = ? AND key = ?`, s.tenantId, key)
+	return err
+}
+
+// RefreshSLAFlags only writes the orders whose flag changed
+func (s *SQLiteStore) RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error) {
+	orders, err := s.ListOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	breaches := make(map[OrderStatus]int)
+	for _, o := range orders {
+		isBreached := slaBreached(o, now)
+		if o.SlaBreached != isBreached {
+			_, err := s.conn().Exec(`UPDATE orders SET sla_breached = ? WHERE tenant_id = ? AND id = ? AND status = ?`,
+				isBreached, s.tenantId, o.ID, string(o.Status))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if isBreached {
+			breaches[o.Status]++
+		}
+	}
+	return breaches, nil
+}
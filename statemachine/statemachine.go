@@ -0,0 +1,89 @@
+// Package statemachine checks the moves of an entity between statuses against a graph of the allowed
+// transitions and runs the hooks registered on them, so every entry point applying a status change (the
+// rest api, a gRPC server, an event consumer) follows the same rules.
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTransitionNotAllowed is returned by Transition for a move the graph doesn't have
+var ErrTransitionNotAllowed = errors.New("status transition not allowed")
+
+// Hook is run once a transition is done, with the entity moved by it. Hooks can't undo the transition,
+// whatever they fail to do they handle themselves.
+type Hook[S comparable, T any] func(ctx context.Context, from, to S, subject T)
+
+// Machine is the graph of the transitions between the statuses S of an entity T, with the hooks run on
+// them. It is built once with New and its hooks are registered before it is shared, it is only read after.
+type Machine[S comparable, T any] struct {
+	transitions map[S][]S
+	hooks       []Hook[S, T]
+	enterHooks  map[S][]Hook[S, T]
+}
+
+// New builds a machine from the statuses each status can be moved to, statuses with no next statuses are
+// final. A status can't be moved to itself.
+func New[S comparable, T any](transitions map[S][]S) (*Machine[S, T], error) {
+	m := &Machine[S, T]{
+		transitions: make(map[S][]S, len(transitions)),
+		enterHooks:  make(map[S][]Hook[S, T]),
+	}
+	for from, next := range transitions {
+		for _, to := range next {
+			if to == from {
+				return nil, fmt.Errorf("status %v can't be moved to itself", from)
+			}
+		}
+		m.transitions[from] = append([]S(nil), next...)
+	}
+	return m, nil
+}
+
+// CanTransition reports whether the graph allows moving from one status to the other
+func (m *Machine[S, T]) CanTransition(from, to S) bool {
+	for _, s := range m.transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the statuses the status can be moved to, in the order they were given to New
+func (m *Machine[S, T]) Next(from S) []S {
+	return append([]S(nil), m.transitions[from]...)
+}
+
+// IsFinal reports whether the status can't be moved out of
+func (m *Machine[S, T]) IsFinal(status S) bool {
+	return len(m.transitions[status]) == 0
+}
+
+// OnTransition registers a hook run on every transition
+func (m *Machine[S, T]) OnTransition(hook Hook[S, T]) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// OnEnter registers a hook run on the transitions to the status, after the OnTransition hooks
+func (m *Machine[S, T]) OnEnter(status S, hook Hook[S, T]) {
+	m.enterHooks[status] = append(m.enterHooks[status], hook)
+}
+
+// Transition completes the move of the subject from one status to the other by running its hooks, in the
+// order they were registered. It is called once the new status is stored, callers check the move with
+// CanTransition before storing it.
+func (m *Machine[S, T]) Transition(ctx context.Context, from, to S, subject T) error {
+	if !m.CanTransition(from, to) {
+		return fmt.Errorf("%w: %v to %v", ErrTransitionNotAllowed, from, to)
+	}
+	for _, hook := range m.hooks {
+		hook(ctx, from, to, subject)
+	}
+	for _, hook := range m.enterHooks[to] {
+		hook(ctx, from, to, subject)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type status string
+
+const (
+	draft     status = "draft"
+	review    status = "review"
+	published status = "published"
+	archived  status = "archived"
+)
+
+var transitions = map[status][]status{
+	draft:     {review, archived},
+	review:    {draft, published},
+	published: {archived},
+}
+
+func newTestMachine(t *testing.T) *Machine[status, string] {
+	t.Helper()
+	m, err := New[status, string](transitions)
+	if err != nil {
+		t.Fatalf("building the machine failed: %v", err)
+	}
+	return m
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions map[status][]status
+		wantErr     bool
+	}{
+		{"graph", transitions, false},
+		{"empty graph", map[status][]status{}, false},
+		{"nil graph", nil, false},
+		{"status moved to itself", map[status][]status{draft: {review, draft}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New[status, string](tt.transitions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCopiesTheGraph(t *testing.T) {
+	graph := map[status][]status{draft: {review}}
+	m, err := New[status, string](graph)
+	if err != nil {
+		t.Fatalf("building the machine failed: %v", err)
+	}
+	graph[draft][0] = archived
+	graph[review] = []status{published}
+
+	if !m.CanTransition(draft, review) || m.CanTransition(draft, archived) || m.CanTransition(review, published) {
+		t.Error("changing the graph after New changed the machine")
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	m := newTestMachine(t)
+	tests := []struct {
+		from, to status
+		want     bool
+	}{
+		{draft, review, true},
+		{draft, archived, true},
+		{review, draft, true},
+		{review, published, true},
+		{published, archived, true},
+		{draft, published, false},
+		{published, draft, false},
+		{archived, draft, false},
+		{draft, draft, false},
+		{"unknown", draft, false},
+		{draft, "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+" to "+string(tt.to), func(t *testing.T) {
+			if got := m.CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAndIsFinal(t *testing.T) {
+	m := newTestMachine(t)
+	tests := []struct {
+		status status
+		next   []status
+		final  bool
+	}{
+		{draft, []status{review, archived}, false},
+		{review, []status{draft, published}, false},
+		{published, []status{archived}, false},
+		{archived, nil, true},
+		{"unknown", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			next := m.Next(tt.status)
+			if len(next) != len(tt.next) || (len(next) > 0 && !reflect.DeepEqual(next, tt.next)) {
+				t.Errorf("Next(%v) = %v, want %v", tt.status, next, tt.next)
+			}
+			if got := m.IsFinal(tt.status); got != tt.final {
+				t.Errorf("IsFinal(%v) = %v, want %v", tt.status, got, tt.final)
+			}
+		})
+	}
+
+	// the returned statuses are a copy
+	m.Next(draft)[0] = published
+	if !m.CanTransition(draft, review) || m.CanTransition(draft, published) {
+		t.Error("changing the statuses returned by Next changed the machine")
+	}
+}
+
+func TestTransitionRunsHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to status
+		wantErr  error
+		want     []string
+	}{
+		{"allowed", draft, review, nil, []string{"any draft>review doc", "second draft>review doc", "enter review doc"}},
+		{"allowed without enter hooks", review, draft, nil, []string{"any review>draft doc", "second review>draft doc"}},
+		{"not allowed", draft, published, ErrTransitionNotAllowed, nil},
+		{"out of a final status", archived, draft, ErrTransitionNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine(t)
+			var calls []string
+			record := func(prefix string) Hook[status, string] {
+				return func(ctx context.Context, from, to status, subject string) {
+					calls = append(calls, prefix+" "+string(from)+">"+string(to)+" "+subject)
+				}
+			}
+			enter := func(ctx context.Context, from, to status, subject string) {
+				calls = append(calls, "enter "+string(to)+" "+subject)
+			}
+			m.OnEnter(review, enter)
+			m.OnTransition(record("any"))
+			m.OnTransition(record("second"))
+			m.OnEnter(published, enter)
+
+			err := m.Transition(context.Background(), tt.from, tt.to, "doc")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("hooks ran %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionPassesTheContext(t *testing.T) {
+	type key struct{}
+	m := newTestMachine(t)
+	var got any
+	m.OnTransition(func(ctx context.Context, from, to status, subject string) {
+		got = ctx.Value(key{})
+	})
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	if err := m.Transition(ctx, draft, review, "doc"); err != nil {
+		t.Fatalf("transition failed: %v", err)
+	}
+	if got != "request" {
+		t.Errorf("the hook got %v from the context, want the caller's value", got)
+	}
+}
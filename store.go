@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errVersionConflict is returned by UpdateOrder when the order was changed or removed since it was read
+var errVersionConflict = errors.New("order was modified concurrently")
+
+// OrderRepository persists the orders of a single tenant. The handlers only depend on this interface, the
+// implementation is picked at startup by ORDER_STORE, InMemoryRepository by default.
+//
+// Every method is atomic: an order is always written or removed together with its items by a single
+// call, which every implementation applies all or nothing (a lock, a SQL or bolt transaction, a single
+// MongoDB document or DynamoDB record). Writes spanning several calls go through WithTx.
+type OrderRepository interface {
+	// SaveOrder stores the order with its items. An order saved past pending without an order number
+	// gets the next one of the tenant. It returns the order as stored.
+	SaveOrder(o Order, items []OrderItem) (Order, error)
+	// GetOrder returns the order with its items, false if there is no such order
+	GetOrder(orderId string) (Order, []OrderItem, bool, error)
+	// ListOrders returns every order of the store, in no particular order
+	ListOrders() ([]Order, error)
+	// UpdateOrder stores the updated order if the stored one is still at the version the update was
+	// based on, errVersionConflict otherwise
+	UpdateOrder(o Order, version int64) error
+	// UpdateOrderItems stores the updated order together with its changed items, under the same version
+	// check as UpdateOrder
+	UpdateOrderItems(o Order, items []OrderItem, version int64) error
+	// FindByCartId returns the id of the order placed from the cart
+	FindByCartId(cartId string) (string, bool, error)
+	// FindByNumber returns the id of the order with the order number
+	FindByNumber(orderNumber int64) (string, bool, error)
+	// MarkFailed moves the pending order to failed with the reason and frees its cart to be ordered again
+	MarkFailed(orderId, reason string, now time.Time) error
+	// DeleteOrder removes the order with its items if the stored one is still at the version the delete
+	// was based on, errVersionConflict otherwise
+	DeleteOrder(orderId string, version int64) error
+	// RefreshSLAFlags flags the orders stuck in their status past the SLA and returns the number of
+	// breaches by status
+	RefreshSLAFlags(now time.Time) (map[OrderStatus]int, error)
+	// ClaimIdempotencyKey binds the key to the record if the key isn't bound yet, otherwise it returns
+	// the record the key is bound to and false
+	ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// ReleaseIdempotencyKey unbinds the key of a request that didn't create an order
+	ReleaseIdempotencyKey(key string) error
+	// WithTx runs fn with a repository whose calls form a single transaction: their writes are kept if fn
+	// returns nil and dropped if it returns an error. fn only uses tx, and a WithTx within fn joins the
+	// transaction. fn must not call the product service or anything slow, the transaction holds the
+	// store's lock or database transaction until it returns. DynamoDB has no such transactions and
+	// returns errTransactionsNotSupported, MongoDB needs a replica set.
+	WithTx(fn func(tx OrderRepository) error) error
+}
+
+// errTransactionsNotSupported is returned by WithTx of the stores that can't run a transaction
+var errTransactionsNotSupported = errors.New("the order store doesn't support transactions")
+
+// writeStoreError logs the failed store call and answers with a 500, the raw error only goes to the logs
+func writeStoreError(w http.ResponseWriter, err error) {
+	logger.Error("order store call failed", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, "the order store is unavailable")
+}
+
+// InMemoryRepository holds the orders of a single tenant in memory, the default store. It is safe for
+// concurrent use, reads take the read lock and mutations the write lock, and the orders are handed out
+// and taken in as deep copies so no lock is held while the handlers call the product service and a
+// handler changing its copy never writes into the stored order.
+type InMemoryRepository struct {
+	mu     sync.RWMutex
+	orders map[string]Order
+	items  map[string][]OrderItem
+	// index of cart id -> order id
+	ordersByCartId map[string]string
+	// index of order number -> order id
+	ordersByNumber map[int64]string
+	// number of the last placed order, order numbers increase by one with every placed order
+	lastOrderNumber int64
+	// idempotency key -> the request that claimed it, and the index of order id -> its key
+	idempotencyKeys        map[string]IdempotencyRecord
+	idempotencyKeysByOrder map[string]string
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{
+		orders:                 make(map[string]Order),
+		items:                  make(map[string][]OrderItem),
+		ordersByCartId:         make(map[string]string),
+		ordersByNumber:         make(map[int64]string),
+		idempotencyKeys:        make(map[string]IdempotencyRecord),
+		idempotencyKeysByOrder: make(map[string]string),
+	}
+}
+
+// SaveOrder numbers the order under the lock so the numbers have no gaps or duplicates
+func (s *InMemoryRepository) SaveOrder(o Order, items []OrderItem) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o.Status != OrderPending && o.OrderNumber == 0 {
+		s.lastOrderNumber++
+		o.OrderNumber = s.lastOrderNumber
+		s.ordersByNumber[o.OrderNumber] = o.ID
+	}
+	s.orders[o.ID] = cloneOrder(o)
+	s.items[o.ID] = slices.Clone(items)
+	if o.CartId != "" {
+		s.ordersByCartId[o.CartId] = o.ID
+	}
+	return o, nil
+}
+
+func (s *InMemoryRepository) GetOrder(orderId string) (Order, []OrderItem, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[orderId]
+	if !ok {
+		return Order{}, nil, false, nil
+	}
+	return cloneOrder(o), slices.Clone(s.items[orderId]), true, nil
+}
+
+func (s *InMemoryRepository) ListOrders() ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, cloneOrder(o))
+	}
+	return orders, nil
+}
+
+// cloneOrder copies the slices, the map and the pointers of the order so the copy shares no memory
+// with the original
+func cloneOrder(o Order) Order {
+	o.Discounts = slices.Clone(o.Discounts)
+	o.Metadata = maps.Clone(o.Metadata)
+	o.History = slices.Clone(o.History)
+	if o.Refund != nil {
+		refund := *o.Refund
+		o.Refund = &refund
+	}
+	if o.StatusReason != nil {
+		reason := *o.StatusReason
+		o.StatusReason = &reason
+	}
+	return o
+}
+
+// WithTx holds the write lock for the transaction and runs fn on a copy of the store, the copy replaces
+// the store's state once fn returns nil. Readers wait for the transaction to end.
+func (s *InMemoryRepository) WithTx(fn func(tx OrderRepository) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the stored values are copied on every read and write, copying the maps is enough
+	tx := &InMemoryRepository{
+		orders:                 maps.Clone(s.orders),
+		items:                  maps.Clone(s.items),
+		ordersByCartId:         maps.Clone(s.ordersByCartId),
+		ordersByNumber:         maps.Clone(s.ordersByNumber),
+		lastOrderNumber:        s.lastOrderNumber,
+		idempotencyKeys:        maps.Clone(s.idempotencyKeys),
+		idempotencyKeysByOrder: maps.Clone(s.idempotencyKeysByOrder),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.orders = tx.orders
+	s.items = tx.items
+	s.ordersByCartId = tx.ordersByCartId
+	s.ordersByNumber = tx.ordersByNumber
+	s.lastOrderNumber = tx.lastOrderNumber
+	s.idempotencyKeys = tx.idempotencyKeys
+	s.idempotencyKeysByOrder = tx.idempotencyKeysByOrder
+	return nil
+}
+
+// Len returns the number of orders in the store
+func (s *InMemoryRepository) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.orders)
+}
+
+func (s *InMemoryRepository) UpdateOrder(o Order, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[o.ID]
+	if !ok || stored.Version != version {
+		return errVersionConflict
+	}
+	s.orders[o.ID] = cloneOrder(o)
+	return nil
+}
+
+func (s *InMemoryRepository) UpdateOrderItems(o Order, items []OrderItem, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[o.ID]
+	if !ok || stored.Version != version {
+		return errVersionConflict
+	}
+	s.orders[o.ID] = cloneOrder(o)
+	s.items[o.ID] = slices.Clone(items)
+	return nil
+}
+
+func (s *InMemoryRepository) FindByCartId(cartId string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orderId, ok := s.ordersByCartId[cartId]
+	return orderId, ok, nil
+}
+
+func (s *InMemoryRepository) FindByNumber(orderNumber int64) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orderId, ok := s.ordersByNumber[orderNumber]
+	return orderId, ok, nil
+}
+
+func (s *InMemoryRepository) MarkFailed(orderId, reason string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderId]
+	if !ok {
+		return nil
+	}
+	o.Status = OrderFailed
+	o.FailureReason = reason
+	o.StatusChangedAt = now
+	o.UpdatedAt = now
+	o.History = append(slices.Clip(o.History), failedStatusChange(now))
+	o.Version++
+	s.orders[orderId] = o
+	if o.CartId != "" && s.ordersByCartId[o.CartId] == orderId {
+		delete(s.ordersByCartId, o.CartId)
+	}
+	return nil
+}
+
+// DeleteOrder also drops the index entries of the order
+func (s *InMemoryRepository) DeleteOrder(orderId string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderId]
+	if !ok || o.Version != version {
+		return errVersionConflict
+	}
+	delete(s.orders, orderId)
+	delete(s.items, orderId)
+	if o.CartId != "" && s.ordersByCartId[o.CartId] == orderId {
+		delete(s.ordersByCartId, o.CartId)
+	}
+	if o.OrderNumber != 0 {
+		delete(s.ordersByNumber, o.OrderNumber)
+	}
+	if key, ok := s.idempotencyKeysByOrder[orderId]; ok {
+		delete(s.idempotencyKeys, key)
+		delete(s.idempotencyKeysByOrder, orderId)
+	}
+	return nil
+}
+
+func (s *InMemoryRepository) ClaimIdempotencyKey(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.idempotencyKeys[key]; ok {
+		return existing, false, nil
+	}
+	s.idempotencyKeys[key] = record
+	s.idempotencyKeysByOrder[record.OrderId] = key
+	return record, true, nil
+}
+
+func (s *InMemoryRepository) ReleaseIdempotencyKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.idempotencyKeys[key]; ok {
+		delete(s.idempotencyKeys, key)
+		delete(s.idempotencyKeysByOrder, record.OrderId)
+	}
+	return nil
+}
+
+// oldestInactive returns the completed, returned, refunded, cancelled or failed order that left active use the
+// longest ago
+func (s *InMemoryRepository) oldestInactive() (Order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var oldest Order
+	found := false
+	for _, o := range s.orders {
+		if activeStatus(o.Status) {
+			continue
+		}
+		if !found || o.StatusChangedAt.Before(oldest.StatusChangedAt) {
+			oldest = o
+			found = true
+		}
+	}
+	return oldest, found
+}
+
+// newStore creates the store of a tenant, set by loadStoreConfig
+var newStore = func(tenantId string) OrderRepository {
+	return NewInMemoryRepository()
+}
+
+// loadStoreConfig reads ORDER_STORE (memory/sqlite/bolt/postgres/mongodb/dynamodb), ORDER_STORE_PATH, the
+// database file of the sqlite and bolt stores, ORDER_STORE_DSN and ORDER_STORE_MAX_OPEN_CONNS, the connection string
+// and the pool size of the postgres store, ORDER_STORE_URI and ORDER_STORE_DATABASE for the mongodb store,
+// and ORDER_STORE_TABLE and ORDER_STORE_ENDPOINT for the dynamodb store. ORDER_STORE_TIMEOUT bounds the
+// calls of the mongodb and dynamodb stores. The tenants already in the database are registered so the
+// background jobs see their orders.
+func loadStoreConfig() error {
+	switch backend := getEnv("ORDER_STORE", "memory"); backend {
+	case "memory":
+		logger.Info("order store: memory")
+		return nil
+	case "sqlite":
+		path := getEnv("ORDER_STORE_PATH", "orders.db")
+		db, err := openSQLiteDB(path)
+		if err != nil {
+			return err
+		}
+		newStore = func(tenantId string) OrderRepository {
+			return NewSQLiteStore(db, tenantId)
+		}
+		tenantIds, err := sqlTenants(db)
+		if err != nil {
+			return fmt.Errorf("error reading the tenants of the sqlite store, err: %w", err)
+		}
+		for _, tenantId := range tenantIds {
+			tenantStore(tenantId)
+		}
+		logger.Info("order store: sqlite", "path", path, "tenants", len(tenantIds))
+		return nil
+	case "bolt":
+		path := getEnv("ORDER_STORE_PATH", "orders.bolt")
+		db, err := openBoltDB(path)
+		if err != nil {
+			return err
+		}
+		newStore = func(tenantId string) OrderRepository {
+			return NewBoltStore(db, tenantId)
+		}
+		tenantIds, err := boltTenants(db)
+		if err != nil {
+			return fmt.Errorf("error reading the tenants of the bolt store, err: %w", err)
+		}
+		for _, tenantId := range tenantIds {
+			tenantStore(tenantId)
+		}
+		logger.Info("order store: bolt", "path", path, "tenants", len(tenantIds))
+		return nil
+	case "postgres":
+		dsn := getEnv("ORDER_STORE_DSN", "")
+		if dsn == "" {
+			return errors.New("ORDER_STORE_DSN is required for the postgres store")
+		}
+		db, err := openPostgresDB(dsn, getEnvInt("ORDER_STORE_MAX_OPEN_CONNS", 10))
+		if err != nil {
+			return err
+		}
+		newStore = func(tenantId string) OrderRepository {
+			return NewPostgresStore(db, tenantId)
+		}
+		tenantIds, err := sqlTenants(db)
+		if err != nil {
+			return fmt.Errorf("error reading the tenants of the postgres store, err: %w", err)
+		}
+		for _, tenantId := range tenantIds {
+			tenantStore(tenantId)
+		}
+		logger.Info("order store: postgres", "tenants", len(tenantIds))
+		return nil
+	case "mongodb":
+		uri := getEnv("ORDER_STORE_URI", "")
+		if uri == "" {
+			return errors.New("ORDER_STORE_URI is required for the mongodb store")
+		}
+		mongoTimeout = getEnvDuration("ORDER_STORE_TIMEOUT", mongoTimeout)
+		database := getEnv("ORDER_STORE_DATABASE", "orders")
+		db, err := openMongoDB(uri, database)
+		if err != nil {
+			return err
+		}
+		newStore = func(tenantId string) OrderRepository {
+			return NewMongoStore(db, tenantId)
+		}
+		tenantIds, err := mongoTenants(db)
+		if err != nil {
+			return fmt.Errorf("error reading the tenants of the mongodb store, err: %w", err)
+		}
+		for _, tenantId := range tenantIds {
+			tenantStore(tenantId)
+		}
+		logger.Info("order store: mongodb", "database", database, "tenants", len(tenantIds))
+		return nil
+	case "dynamodb":
+		dynamoTimeout = getEnvDuration("ORDER_STORE_TIMEOUT", dynamoTimeout)
+		table := getEnv("ORDER_STORE_TABLE", "orders")
+		client, err := openDynamoDB(table, getEnv("ORDER_STORE_ENDPOINT", ""))
+		if err != nil {
+			return err
+		}
+		newStore = func(tenantId string) OrderRepository {
+			return NewDynamoStore(client, table, tenantId)
+		}
+		tenantIds, err := dynamoTenants(client, table)
+		if err != nil {
+			return fmt.Errorf("error reading the tenants of the dynamodb store, err: %w", err)
+		}
+		for _, tenantId := range tenantIds {
+			tenantStore(tenantId)
+		}
+		logger.Info("order store: dynamodb", "table", table, "tenants", len(tenantIds))
+		return nil
+	default:
+		return fmt.Errorf("unsupported order store: %v", backend)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInMemoryRepositoryVersionChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(repo OrderRepository) error
+		wantErr error
+	}{
+		{"update at the stored version", func(repo OrderRepository) error {
+			return repo.UpdateOrder(Order{ID: "o1", Status: OrderConfirmed, Version: 2}, 1)
+		}, nil},
+		{"update at a stale version", func(repo OrderRepository) error {
+			return repo.UpdateOrder(Order{ID: "o1", Status: OrderConfirmed, Version: 3}, 2)
+		}, errVersionConflict},
+		{"update of a missing order", func(repo OrderRepository) error {
+			return repo.UpdateOrder(Order{ID: "o2", Version: 2}, 1)
+		}, errVersionConflict},
+		{"items update at a stale version", func(repo OrderRepository) error {
+			return repo.UpdateOrderItems(Order{ID: "o1", Version: 3}, nil, 2)
+		}, errVersionConflict},
+		{"delete at the stored version", func(repo OrderRepository) error {
+			return repo.DeleteOrder("o1", 1)
+		}, nil},
+		{"delete at a stale version", func(repo OrderRepository) error {
+			return repo.DeleteOrder("o1", 2)
+		}, errVersionConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewInMemoryRepository()
+			if _, err := repo.SaveOrder(Order{ID: "o1", Status: OrderPlaced, Version: 1}, []OrderItem{{ProductId: "p1", ProductQuantity: 1}}); err != nil {
+				t.Fatalf("saving the order failed: %v", err)
+			}
+			if err := tt.write(repo); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepositoryHandsOutCopies(t *testing.T) {
+	repo := NewInMemoryRepository()
+	o, err := repo.SaveOrder(Order{ID: "o1", Status: OrderPlaced, CartId: "c1", Version: 1, Metadata: map[string]string{"gift": "yes"}},
+		[]OrderItem{{ProductId: "p1", ProductQuantity: 1}})
+	if err != nil {
+		t.Fatalf("saving the order failed: %v", err)
+	}
+	if o.OrderNumber != 1 {
+		t.Errorf("order number = %v, want 1", o.OrderNumber)
+	}
+
+	got, items, ok, err := repo.GetOrder("o1")
+	if err != nil || !ok {
+		t.Fatalf("reading the order failed: %v, found: %v", err, ok)
+	}
+	got.Metadata["gift"] = "no"
+	items[0].ProductQuantity = 5
+
+	got, items, _, _ = repo.GetOrder("o1")
+	if got.Metadata["gift"] != "yes" || items[0].ProductQuantity != 1 {
+		t.Errorf("changing the returned order changed the stored one: %+v, %+v", got, items)
+	}
+
+	for _, find := range []func() (string, bool, error){
+		func() (string, bool, error) { return repo.FindByCartId("c1") },
+		func() (string, bool, error) { return repo.FindByNumber(1) },
+	} {
+		if orderId, ok, err := find(); err != nil || !ok || orderId != "o1" {
+			t.Errorf("lookup = %v, %v, %v, want o1", orderId, ok, err)
+		}
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) OrderRepository
+	}{
+		{"memory", func(t *testing.T) OrderRepository { return NewInMemoryRepository() }},
+		{"sqlite", func(t *testing.T) OrderRepository {
+			db, err := openSQLiteDB(filepath.Join(t.TempDir(), "orders.db"))
+			if err != nil {
+				t.Fatalf("opening the database failed: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLiteStore(db, "t1")
+		}},
+		{"bolt", func(t *testing.T) OrderRepository {
+			db, err := openBoltDB(filepath.Join(t.TempDir(), "orders.db"))
+			if err != nil {
+				t.Fatalf("opening the database failed: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewBoltStore(db, "t1")
+		}},
+	}
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name       string
+		fn         func(tx OrderRepository) error
+		wantErr    error
+		wantOrders []string
+	}{
+		{"commit", func(tx OrderRepository) error {
+			if _, err := tx.SaveOrder(Order{ID: "o2", TenantId: "t1", Status: OrderPlaced, Version: 1}, nil); err != nil {
+				return err
+			}
+			return tx.UpdateOrder(Order{ID: "o1", TenantId: "t1", Status: OrderConfirmed, Version: 2}, 1)
+		}, nil, []string{"o1:confirmed", "o2:placed"}},
+		{"rollback", func(tx OrderRepository) error {
+			if _, err := tx.SaveOrder(Order{ID: "o2", TenantId: "t1", Status: OrderPlaced, Version: 1}, nil); err != nil {
+				return err
+			}
+			if err := tx.DeleteOrder("o1", 1); err != nil {
+				return err
+			}
+			return errAbort
+		}, errAbort, []string{"o1:placed"}},
+		{"failed call within the transaction", func(tx OrderRepository) error {
+			if err := tx.UpdateOrder(Order{ID: "o1", TenantId: "t1", Status: OrderConfirmed, Version: 3}, 2); !errors.Is(err, errVersionConflict) {
+				return fmt.Errorf("stale update: %v", err)
+			}
+			_, err := tx.SaveOrder(Order{ID: "o2", TenantId: "t1", Status: OrderPlaced, Version: 1}, nil)
+			return err
+		}, nil, []string{"o1:placed", "o2:placed"}},
+		{"nested", func(tx OrderRepository) error {
+			return tx.WithTx(func(nested OrderRepository) error {
+				_, err := nested.SaveOrder(Order{ID: "o2", TenantId: "t1", Status: OrderPlaced, Version: 1}, nil)
+				return err
+			})
+		}, nil, []string{"o1:placed", "o2:placed"}},
+	}
+	for _, backend := range backends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				repo := backend.open(t)
+				if _, err := repo.SaveOrder(Order{ID: "o1", TenantId: "t1", Status: OrderPlaced, Version: 1}, []OrderItem{{ProductId: "p1", ProductQuantity: 1}}); err != nil {
+					t.Fatalf("saving the order failed: %v", err)
+				}
+
+				if err := repo.WithTx(tt.fn); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				orders, err := repo.ListOrders()
+				if err != nil {
+					t.Fatalf("listing the orders failed: %v", err)
+				}
+				var got []string
+				for _, o := range orders {
+					got = append(got, o.ID+":"+string(o.Status))
+				}
+				sort.Strings(got)
+				if strings.Join(got, ",") != strings.Join(tt.wantOrders, ",") {
+					t.Errorf("orders = %v, want %v", got, tt.wantOrders)
+				}
+
+				// the numbers handed out by a dropped transaction are handed out again
+				o, err := repo.SaveOrder(Order{ID: "o3", TenantId: "t1", Status: OrderPlaced, Version: 1}, nil)
+				if err != nil {
+					t.Fatalf("saving the order failed: %v", err)
+				}
+				if want := int64(len(tt.wantOrders) + 1); o.OrderNumber != want {
+					t.Errorf("order number = %v, want %v", o.OrderNumber, want)
+				}
+			})
+		}
+	}
+}
+
+func TestIndexedStoreReindexesAfterTheCommit(t *testing.T) {
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{"commit", nil, []string{"o1", "o2"}},
+		{"rollback", errAbort, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &indexedStore{OrderRepository: NewInMemoryRepository(), tenantId: "t1"}
+			err := store.WithTx(func(tx OrderRepository) error {
+				for _, orderId := range []string{"o1", "o2"} {
+					if _, err := tx.SaveOrder(Order{ID: orderId, TenantId: "t1", Status: OrderPlaced, Version: 1}, nil); err != nil {
+						return err
+					}
+				}
+				if len(orderIndexQueue) != 0 {
+					t.Error("the orders were queued for reindexing before the commit")
+				}
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			var got []string
+			for len(orderIndexQueue) > 0 {
+				job := <-orderIndexQueue
+				got = append(got, job.orderId)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("reindexed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// tenants maps a tenant id to its orders, every tenant is isolated in its own store
+var (
+	tenantsMu sync.RWMutex
+	tenants   = make(map[string]OrderRepository)
+)
+
+// tenantStore returns the orders of the tenant, creating the tenant's store on first use
+func tenantStore(tenantId string) OrderRepository {
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+
+	t, ok := tenants[tenantId]
+	if !ok {
+		t = newStore(tenantId)
+		if orderSearchIndex != nil {
+			t = &indexedStore{OrderRepository: t, tenantId: tenantId}
+		}
+		tenants[tenantId] = t
+	}
+	return t
+}
+
+// tenantStores returns the stores of every tenant
+func tenantStores() []OrderRepository {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+
+	stores := make([]OrderRepository, 0, len(tenants))
+	for _, t := range tenants {
+		stores = append(stores, t)
+	}
+	return stores
+}
+
+type tenantContextKey struct{}
+
+// resolveTenant derives the tenant of the request from the X-Tenant-ID header,
+// falling back to DEFAULT_TENANT_ID for single tenant deployments
+func resolveTenant(r *http.Request) string {
+	tenantId := strings.TrimSpace(r.Header.Get("X-Tenant-ID"))
+	if tenantId == "" {
+		tenantId = getEnv("DEFAULT_TENANT_ID", "")
+	}
+	if len(tenantId) > 64 || strings.ContainsAny(tenantId, " \t\r\n") {
+		return ""
+	}
+	return tenantId
+}
+
+// tenantMiddleware rejects requests without a resolvable tenant and stores the tenant id in the request context
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantId := resolveTenant(r)
+		if tenantId == "" {
+			logger.InfoContext(r.Context(), "request without a resolvable tenant", "method", r.Method, "path", r.URL.Path)
+			writeJSONError(w, http.StatusBadRequest, "a valid X-Tenant-ID header is required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenantId)))
+	})
+}
+
+func tenantFromContext(ctx context.Context) string {
+	tenantId, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantId
+}
+
+// maxStoredOrders caps the number of orders kept in memory across all tenants, 0 means unlimited.
+// Set by MEMORY_STORE_MAX_ORDERS, it only applies to the memory store.
+var maxStoredOrders = 0
+
+func countStoredOrders() int {
+	count := 0
+	for _, t := range tenantStores() {
+		if m, ok := asInMemoryRepository(t); ok {
+			count += m.Len()
+		}
+	}
+	return count
+}
+
+// evictOrders drops the completed, returned, cancelled and failed orders that left active use the longest ago
+// until the store is back under its cap. Pending, placed, on hold and dispatched orders are never evicted.
+func evictOrders() {
+	if maxStoredOrders <= 0 {
+		return
+	}
+
+	for countStoredOrders() > maxStoredOrders {
+		var oldest Order
+		var oldestStore *InMemoryRepository
+		for _, t := range tenantStores() {
+			m, ok := asInMemoryRepository(t)
+			if !ok {
+				continue
+			}
+			o, ok := m.oldestInactive()
+			if ok && (oldestStore == nil || o.StatusChangedAt.Before(oldest.StatusChangedAt)) {
+				oldest = o
+				oldestStore = m
+			}
+		}
+		if oldestStore == nil {
+			logger.Warn("order store is over its cap but holds only active orders", "cap", maxStoredOrders)
+			return
+		}
+
+		if err := oldestStore.DeleteOrder(oldest.ID, oldest.Version); err != nil {
+			logger.Warn("order changed while being evicted", "order_id", oldest.ID, "err", err)
+			continue
+		}
+		logger.Info("evicted order", "order_id", oldest.ID, "tenant_id", oldest.TenantId, "status", oldest.Status)
+	}
+}
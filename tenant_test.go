@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// serveTenantRequest serves the request of the tenant through the tenant middleware, an empty tenant
+// sends no X-Tenant-ID header
+func serveTenantRequest(handler http.HandlerFunc, tenantId, method, target, body string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if tenantId != "" {
+		req.Header.Set("X-Tenant-ID", tenantId)
+	}
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	req.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+	tenantMiddleware(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenantIsolation(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	t.Setenv("DEFAULT_TENANT_ID", "")
+	saveTestOrders(t, Order{ID: "o1", Status: OrderPlaced})
+	vars := map[string]string{"order_id": "o1"}
+
+	if rec := serveTenantRequest(GetOrdersHandler, "", http.MethodGet, "/orders", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("a request without a tenant answered %v, want 400", rec.Code)
+	}
+	if rec := serveTenantRequest(GetOrderDetailsHandler, "t1", http.MethodGet, "/orders/o1", "", vars); rec.Code != http.StatusOK {
+		t.Errorf("the owning tenant reading the order answered %v: %s", rec.Code, rec.Body)
+	}
+
+	// another tenant can neither see nor change the order
+	if rec := serveTenantRequest(GetOrderDetailsHandler, "t2", http.MethodGet, "/orders/o1", "", vars); rec.Code != http.StatusNotFound {
+		t.Errorf("another tenant reading the order answered %v, want 404", rec.Code)
+	}
+	rec := serveTenantRequest(GetOrdersHandler, "t2", http.MethodGet, "/orders", "", nil)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "o1") {
+		t.Errorf("another tenant listing the orders answered %v: %s", rec.Code, rec.Body)
+	}
+	if rec := serveTenantRequest(UpdateOrderStatusHandler, "t2", http.MethodPut, "/orders/o1", `{"status":"cancelled","reason_code":"customer_request"}`, vars); rec.Code != http.StatusNotFound {
+		t.Errorf("another tenant cancelling the order answered %v, want 404", rec.Code)
+	}
+	o, _, _, err := tenantStore("t1").GetOrder("o1")
+	if err != nil || o.Status != OrderPlaced {
+		t.Errorf("the order is %v, %v, want it still placed", o.Status, err)
+	}
+}
+
+func TestInMemoryRepositoryEviction(t *testing.T) {
+	useMemoryStores(t)
+	useFakeProductClient(t, fakeSampleProducts()...)
+	prev := maxStoredOrders
+	maxStoredOrders = 3
+	t.Cleanup(func() { maxStoredOrders = prev })
+	now := time.Now().UTC()
+	saveTestOrders(t,
+		Order{ID: "completed", Status: OrderCompleted, StatusChangedAt: now.Add(-2 * time.Hour)},
+		Order{ID: "cancelled", Status: OrderCancelled, StatusChangedAt: now.Add(-time.Hour)},
+		// older than both, but still active
+		Order{ID: "placed", Status: OrderPlaced, StatusChangedAt: now.Add(-3 * time.Hour)},
+	)
+
+	stored := func() string {
+		orders, err := tenantStore("t1").ListOrders()
+		if err != nil {
+			t.Fatalf("listing the orders failed: %v", err)
+		}
+		var ids []string
+		for _, o := range orders {
+			if o.ID == "completed" || o.ID == "cancelled" || o.ID == "placed" {
+				ids = append(ids, o.ID)
+			}
+		}
+		sort.Strings(ids)
+		return fmt.Sprintf("%v of %v", strings.Join(ids, ","), len(orders))
+	}
+	// every placement over the cap evicts the order that left active use the longest ago, the active
+	// orders are kept even over the cap
+	for _, want := range []string{"cancelled,placed of 3", "placed of 3", "placed of 4"} {
+		if rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p4","quantity":1}]}`); rec.Code != http.StatusOK {
+			t.Fatalf("placing an order answered %v: %s", rec.Code, rec.Body)
+		}
+		if got := stored(); got != want {
+			t.Errorf("stored %v, want %v", got, want)
+		}
+	}
+}
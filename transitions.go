@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/microServicesExamples/order-service/statemachine"
+)
+
+// orderStatuses are all the statuses an order can be in
+var orderStatuses = []OrderStatus{
+	OrderPending, OrderPlaced, OrderOnHold, OrderConfirmed, OrderPacked, OrderDispatched, OrderShipped,
+	OrderOutForDelivery, OrderCompleted, OrderReturned, OrderRefunded, OrderCancelled, OrderFailed,
+}
+
+// defaultOrderTransitions is the graph of the status updates, the statuses an order can be moved to from
+// each status. Statuses missing from it are final. Replaced by ORDER_TRANSITIONS_FILE.
+var defaultOrderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPlaced:         {OrderConfirmed, OrderDispatched, OrderCancelled, OrderOnHold},
+	OrderOnHold:         {OrderPlaced},
+	OrderConfirmed:      {OrderPacked, OrderDispatched, OrderCancelled},
+	OrderPacked:         {OrderDispatched, OrderCancelled},
+	OrderDispatched:     {OrderShipped, OrderCompleted, OrderCancelled},
+	OrderShipped:        {OrderOutForDelivery, OrderCompleted},
+	OrderOutForDelivery: {OrderCompleted},
+	OrderCompleted:      {OrderReturned},
+	OrderReturned:       {OrderRefunded},
+}
+
+// orderTransition is the order moved by a status update, handed to the hooks of the order state machine
+type orderTransition struct {
+	order Order
+	items []OrderItem
+	// when the order entered the status it left
+	since time.Time
+}
+
+// orderStateMachine checks the status updates against the transitions graph and runs their side effects
+var orderStateMachine = mustNewOrderStateMachine(defaultOrderTransitions)
+
+// newOrderStateMachine builds the state machine of the transitions with the side effects of a status
+// change: the metrics, the status changed event and, for cancelled and returned orders, the stock going
+// back to the inventory. The versioned update of the order makes sure they only run once per change.
+func newOrderStateMachine(transitions map[OrderStatus][]OrderStatus) (*statemachine.Machine[OrderStatus, orderTransition], error) {
+	m, err := statemachine.New[OrderStatus, orderTransition](transitions)
+	if err != nil {
+		return nil, err
+	}
+	m.OnTransition(func(ctx context.Context, from, to OrderStatus, t orderTransition) {
+		recordStatusTransition(from, to, t.since, t.order.StatusChangedAt)
+		notifyStatusChange(ctx, t.order, from)
+	})
+	restock := func(ctx context.Context, from, to OrderStatus, t orderTransition) {
+		restoreInventory(ctx, t.order.ID, t.items)
+	}
+	m.OnEnter(OrderCancelled, restock)
+	m.OnEnter(OrderReturned, restock)
+	return m, nil
+}
+
+func mustNewOrderStateMachine(transitions map[OrderStatus][]OrderStatus) *statemachine.Machine[OrderStatus, orderTransition] {
+	m, err := newOrderStateMachine(transitions)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func validOrderStatus(status OrderStatus) bool {
+	for _, s := range orderStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// updatableStatus reports whether orders can be moved from or to the status with a status update, pending
+// and failed orders only come out of the asynchronous placement
+func updatableStatus(status OrderStatus) bool {
+	return validOrderStatus(status) && status != OrderPending && status != OrderFailed
+}
+
+// activeStatus reports whether the order is still being placed or fulfilled
+func activeStatus(status OrderStatus) bool {
+	switch status {
+	case OrderPending, OrderPlaced, OrderOnHold, OrderConfirmed, OrderPacked, OrderDispatched, OrderShipped, OrderOutForDelivery:
+		return true
+	}
+	return false
+}
+
+// loadTransitionConfig reads the graph of the status updates from the JSON file at ORDER_TRANSITIONS_FILE,
+// an object of each status to the list of statuses it can be moved to, e.g. {"placed": ["dispatched"]}.
+// The file replaces the whole default graph.
+func loadTransitionConfig() error {
+	path := getEnv("ORDER_TRANSITIONS_FILE", "")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading the order transitions file: %v, err: %w", path, err)
+	}
+	var transitions map[OrderStatus][]OrderStatus
+	if err := json.Unmarshal(data, &transitions); err != nil {
+		return fmt.Errorf("error parsing the order transitions file: %v, err: %w", path, err)
+	}
+	for from, next := range transitions {
+		if !updatableStatus(from) {
+			return fmt.Errorf("order transitions file has an invalid status: %q", from)
+		}
+		for _, to := range next {
+			if !updatableStatus(to) {
+				return fmt.Errorf("order transitions file has an invalid transition: %q to %q", from, to)
+			}
+		}
+	}
+	m, err := newOrderStateMachine(transitions)
+	if err != nil {
+		return fmt.Errorf("order transitions file has an invalid transition: %w", err)
+	}
+	orderStateMachine = m
+	logger.Info("order transitions loaded", "path", path, "transitions", transitions)
+	return nil
+}
+
+// nextStatuses lists the statuses the order can be moved to from the status, for the error messages
+func nextStatuses(from OrderStatus) string {
+	next := make([]string, 0)
+	for _, s := range orderStateMachine.Next(from) {
+		next = append(next, string(s))
+	}
+	return strings.Join(next, ", ")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOrderHold(t *testing.T) {
+	useMemoryStores(t)
+	fake := useFakeProductClient(t, fakeSampleProducts()...)
+	t.Setenv("ADMIN_API_TOKEN", "secret")
+
+	rec := placeTestOrder(t, `{"customer_id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","items":[{"product_id":"p1","quantity":3}]}`)
+	var placed CreateOrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &placed); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("placing the order answered %v: %s", rec.Code, rec.Body)
+	}
+
+	steps := []struct {
+		name       string
+		status     OrderStatus
+		admin      bool
+		wantStatus int
+	}{
+		{"hold by a client", OrderOnHold, false, http.StatusForbidden},
+		{"hold by an admin", OrderOnHold, true, http.StatusOK},
+		{"dispatch while held", OrderDispatched, true, http.StatusBadRequest},
+		{"release by a client", OrderPlaced, false, http.StatusForbidden},
+		{"release by an admin", OrderPlaced, true, http.StatusOK},
+		{"dispatch once released", OrderDispatched, false, http.StatusOK},
+	}
+	for _, step := range steps {
+		req := newTenantRequest(http.MethodPut, "/orders/"+placed.ID, `{"status":"`+string(step.status)+`"}`, map[string]string{"order_id": placed.ID})
+		req.Header.Set("If-Match", "*")
+		if step.admin {
+			req.Header.Set("X-Admin-Token", "secret")
+		}
+		rec := httptest.NewRecorder()
+		UpdateOrderStatusHandler(rec, req)
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%v answered %v, want %v: %s", step.name, rec.Code, step.wantStatus, rec.Body)
+		}
+
+		// the held order keeps its stock
+		p1, err := fake.GetProductDetails(context.Background(), "p1")
+		if err != nil {
+			t.Fatalf("reading the product failed: %v", err)
+		}
+		if p1.Quantity != 97 {
+			t.Errorf("after %v the product quantity is %v, want 97", step.name, p1.Quantity)
+		}
+	}
+
+	o, _, _, err := tenantStore("t1").GetOrder(placed.ID)
+	if err != nil {
+		t.Fatalf("reading the order failed: %v", err)
+	}
+	var history []string
+	for _, change := range o.History {
+		history = append(history, string(change.To)+" by "+change.Actor)
+	}
+	if got, want := strings.Join(history, ", "), "placed by client, on_hold by admin, placed by admin, dispatched by client"; got != want {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	amountDiscrepancies = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "orders_amount_discrepancies",
+		Help: "Number of active orders whose stored amount didn't match the amount recomputed from their items in the last verification run.",
+	})
+	amountCorrections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orders_amount_corrections_total",
+		Help: "Number of order amounts corrected by the consistency verifier.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(amountDiscrepancies, amountCorrections)
+}
+
+// amountDiscrepancy is an active order whose stored amount doesn't match its items
+type amountDiscrepancy struct {
+	store   OrderRepository
+	orderId string
+	version int64
+	// the amounts in minor units of the currency of the order
+	stored   int64
+	expected int64
+}
+
+// expectedOrderAmount recomputes the amount of the order from its item snapshots and the discounts
+// applied to it at placement, in minor units of the currency
+func expectedOrderAmount(o Order, items []OrderItem) int64 {
+	var subtotal int64
+	for _, item := range items {
+		subtotal += lineTotalMinorUnits(item)
+	}
+	var discount int64
+	for _, d := range o.Discounts {
+		discount += percentOfMinorUnits(subtotal, d.Percent)
+	}
+	return subtotal - discount
+}
+
+// runAmountVerifier checks the amounts of the active orders every ORDER_VERIFY_INTERVAL, an interval of 0
+// disables the verifier. With ORDER_VERIFY_AUTOCORRECT the mismatching amounts are replaced by the
+// recomputed ones.
+func runAmountVerifier() {
+	interval := getEnvDuration("ORDER_VERIFY_INTERVAL", 10*time.Minute)
+	autoCorrect := getEnvBool("ORDER_VERIFY_AUTOCORRECT", false)
+	if interval <= 0 {
+		logger.Info("order amount verifier disabled")
+		return
+	}
+	logger.Info("verifying the order amounts", "interval", interval, "auto_correct", autoCorrect)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		discrepancies := verifyOrderAmounts()
+		amountDiscrepancies.Set(float64(len(discrepancies)))
+		if autoCorrect {
+			correctOrderAmounts(discrepancies)
+		}
+	}
+}
+
+// verifyOrderAmounts returns the active orders whose amount doesn't match their items. Every order is
+// read on its own so a large store doesn't hold up writers for the whole run.
+func verifyOrderAmounts() []amountDiscrepancy {
+	var discrepancies []amountDiscrepancy
+	for _, t := range tenantStores() {
+		orders, err := t.ListOrders()
+		if err != nil {
+			logger.Error("error listing the orders to verify", "err", err)
+			continue
+		}
+		for _, o := range orders {
+			if o.Status == OrderPending || !activeStatus(o.Status) {
+				continue
+			}
+			_, items, ok, err := t.GetOrder(o.ID)
+			if err != nil || !ok {
+				continue
+			}
+			expected := expectedOrderAmount(o, items)
+			if o.AmountMinor == expected {
+				continue
+			}
+			logger.Error("order amount doesn't match its items", "order_id", o.ID, "tenant_id", o.TenantId, "amount_minor", o.AmountMinor, "expected", expected)
+			discrepancies = append(discrepancies, amountDiscrepancy{
+				store:    t,
+				orderId:  o.ID,
+				version:  o.Version,
+				stored:   o.AmountMinor,
+				expected: expected,
+			})
+		}
+	}
+	return discrepancies
+}
+
+// correctOrderAmounts replaces the stored amounts with the recomputed ones, orders changed since they
+// were verified are left for the next run
+func correctOrderAmounts(discrepancies []amountDiscrepancy) {
+	for _, d := range discrepancies {
+		o, _, ok, err := d.store.GetOrder(d.orderId)
+		if err != nil || !ok {
+			continue
+		}
+
+		o.AmountMinor = d.expected
+		o.UpdatedAt = clock.Now()
+		o.Version++
+		if err := d.store.UpdateOrder(o, d.version); err != nil {
+			logger.Warn("order could not be corrected, skipping the correction", "order_id", d.orderId, "err", err)
+			continue
+		}
+		amountCorrections.Inc()
+		logger.Info("corrected the order amount", "order_id", d.orderId, "from", d.stored, "to", d.expected)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// orderEventsWebhookURL receives the order status changes, set by ORDER_EVENTS_WEBHOOK_URL, empty
+// disables the webhook
+var orderEventsWebhookURL = ""
+
+// webhookClient posts the events, its timeout is set by ORDER_EVENTS_WEBHOOK_TIMEOUT
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// OrderStatusChangedEvent is published and posted to the webhook on every successful status update
+type OrderStatusChangedEvent struct {
+	OrderId   string      `json:"order_id"`
+	TenantId  string      `json:"tenant_id"`
+	OldStatus OrderStatus `json:"old_status"`
+	NewStatus OrderStatus `json:"new_status"`
+	Timestamp Timestamp   `json:"timestamp"`
+}
+
+// loadWebhookConfig reads ORDER_EVENTS_WEBHOOK_URL, an absolute http or https url, and
+// ORDER_EVENTS_WEBHOOK_TIMEOUT
+func loadWebhookConfig() error {
+	orderEventsWebhookURL = getEnv("ORDER_EVENTS_WEBHOOK_URL", "")
+	if orderEventsWebhookURL == "" {
+		logger.Info("order events webhook disabled")
+		return nil
+	}
+	u, err := url.Parse(orderEventsWebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("order events webhook url must be an absolute http or https url, got: %v", orderEventsWebhookURL)
+	}
+	webhookClient.Timeout = getEnvDuration("ORDER_EVENTS_WEBHOOK_TIMEOUT", webhookClient.Timeout)
+	logger.Info("order events webhook enabled", "host", u.Host, "timeout", webhookClient.Timeout)
+	return nil
+}
+
+// notifyStatusChange publishes the status change of the order and posts it to the webhook in the
+// background, it never holds up the response and a failed publish or post is only logged
+func notifyStatusChange(ctx context.Context, o Order, oldStatus OrderStatus) {
+	payload, err := json.Marshal(OrderStatusChangedEvent{
+		OrderId:   o.ID,
+		TenantId:  o.TenantId,
+		OldStatus: oldStatus,
+		NewStatus: o.Status,
+		Timestamp: Timestamp{o.UpdatedAt},
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "error marshaling the order status changed event", "order_id", o.ID, "err", err)
+		return
+	}
+	if err := eventPublisher.Publish(ctx, "order.status_changed", payload); err != nil {
+		logger.ErrorContext(ctx, "error publishing the order status changed event", "order_id", o.ID, "err", err)
+	}
+	if orderEventsWebhookURL == "" {
+		return
+	}
+
+	// the request context ends with the response, the post only keeps its request id
+	requestId := requestIDFromContext(ctx)
+	go func() {
+		ctx := context.WithValue(context.Background(), requestIDContextKey{}, requestId)
+		if err := postWebhookEvent(ctx, requestId, payload); err != nil {
+			logger.ErrorContext(ctx, "error posting the order status changed event", "order_id", o.ID, "status", o.Status, "err", err)
+			return
+		}
+		logger.DebugContext(ctx, "posted the order status changed event", "order_id", o.ID, "status", o.Status)
+	}()
+}
+
+func postWebhookEvent(ctx context.Context, requestId string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orderEventsWebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestId != "" {
+		req.Header.Set("X-Request-ID", requestId)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status: %v", resp.StatusCode)
+	}
+	return nil
+}